@@ -0,0 +1,331 @@
+// Package accounts is the single identity model: one document per person in
+// the accounts collection, with every way they can sign in linked to it.
+package accounts
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const CollectionName = "accounts"
+
+var ErrNotFound = errors.New("account not found")
+
+// Provider names a way of signing in.
+type Provider string
+
+const (
+	ProviderPassword Provider = "password"
+	ProviderGoogle   Provider = "google"
+)
+
+// Login is one sign-in method linked to an account. Subject is the
+// provider's stable user ID (Google's "sub"); PasswordHash is only set for
+// the password provider.
+type Login struct {
+	Provider     Provider  `bson:"provider"`
+	Subject      string    `bson:"subject,omitempty"`
+	PasswordHash string    `bson:"passwordHash,omitempty"`
+	LinkedAt     time.Time `bson:"linkedAt"`
+}
+
+type Account struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	Email       string             `bson:"email"`
+	Username    string             `bson:"username,omitempty"`
+	Logins      []Login            `bson:"logins"`
+	FullName    string             `bson:"fullName,omitempty"`
+	Phone       string             `bson:"phone,omitempty"`
+	Bio         string             `bson:"bio,omitempty"`
+	Website     string             `bson:"website,omitempty"`
+	Avatar      string             `bson:"avatar,omitempty"`
+	Country     string             `bson:"country,omitempty"`
+	Categories  []string           `bson:"categories,omitempty"`
+	NewsSources []string           `bson:"newsSources,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt"`
+	UpdatedAt   time.Time          `bson:"updatedAt"`
+	// DisabledAt is set while an operator has locked the account out
+	DisabledAt *time.Time `bson:"disabledAt,omitempty"`
+}
+
+// Disabled reports whether the account may not sign in.
+func (a *Account) Disabled() bool {
+	return a.DisabledAt != nil
+}
+
+// Login returns the account's login for a provider, if linked.
+func (a *Account) Login(p Provider) (Login, bool) {
+	for _, l := range a.Logins {
+		if l.Provider == p {
+			return l, true
+		}
+	}
+	return Login{}, false
+}
+
+// PasswordHash returns the bcrypt hash of the password login, or "" when the
+// account can only sign in through another provider.
+func (a *Account) PasswordHash() string {
+	l, _ := a.Login(ProviderPassword)
+	return l.PasswordHash
+}
+
+// Providers lists the linked login methods.
+func (a *Account) Providers() []Provider {
+	providers := make([]Provider, 0, len(a.Logins))
+	for _, l := range a.Logins {
+		providers = append(providers, l.Provider)
+	}
+	return providers
+}
+
+// View is the account as returned to its owner, without credentials.
+func (a *Account) View() map[string]interface{} {
+	return map[string]interface{}{
+		"_id":          a.ID,
+		"email":        a.Email,
+		"username":     a.Username,
+		"loginMethods": a.Providers(),
+		"fullName":     a.FullName,
+		"phone":        a.Phone,
+		"bio":          a.Bio,
+		"website":      a.Website,
+		"avatar":       a.Avatar,
+		"country":      a.Country,
+		"categories":   a.Categories,
+		"newsSources":  a.NewsSources,
+		"createdAt":    a.CreatedAt,
+	}
+}
+
+// NormalizeEmail returns email trimmed and lower-cased, the form accounts,
+// sessions and OTPs are stored and looked up by. Callers normalise an
+// address once, as it enters the system.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func collection(db *mongo.Database) *mongo.Collection {
+	return db.Collection(CollectionName)
+}
+
+func findOne(ctx context.Context, db *mongo.Database, filter bson.M) (*Account, error) {
+	var a Account
+	if err := collection(db).FindOne(ctx, filter).Decode(&a); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &a, nil
+}
+
+func FindByEmail(ctx context.Context, db *mongo.Database, email string) (*Account, error) {
+	return findOne(ctx, db, bson.M{"email": email})
+}
+
+// FindByLogin finds the account a provider identity is linked to.
+func FindByLogin(ctx context.Context, db *mongo.Database, p Provider, subject string) (*Account, error) {
+	return findOne(ctx, db, bson.M{"logins": bson.M{"$elemMatch": bson.M{"provider": p, "subject": subject}}})
+}
+
+// Conflict reports whether the username or email is already taken, and
+// which of the two fields ("username" or "email") clashed.
+func Conflict(ctx context.Context, db *mongo.Database, username, email string) (bool, string, error) {
+	if username != "" {
+		n, err := collection(db).CountDocuments(ctx, bson.M{"username": username})
+		if err != nil {
+			return false, "", err
+		}
+		if n > 0 {
+			return true, "username", nil
+		}
+	}
+	if email != "" {
+		n, err := collection(db).CountDocuments(ctx, bson.M{"email": email})
+		if err != nil {
+			return false, "", err
+		}
+		if n > 0 {
+			return true, "email", nil
+		}
+	}
+	return false, "", nil
+}
+
+func Create(ctx context.Context, db *mongo.Database, a *Account) error {
+	now := time.Now()
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = now
+	}
+	a.UpdatedAt = now
+	for i := range a.Logins {
+		if a.Logins[i].LinkedAt.IsZero() {
+			a.Logins[i].LinkedAt = now
+		}
+	}
+	res, err := collection(db).InsertOne(ctx, a)
+	if err != nil {
+		return err
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		a.ID = id
+	}
+	return nil
+}
+
+// LinkLogin adds a login to the account unless one for the provider exists.
+func LinkLogin(ctx context.Context, db *mongo.Database, email string, l Login) error {
+	if l.LinkedAt.IsZero() {
+		l.LinkedAt = time.Now()
+	}
+	_, err := collection(db).UpdateOne(ctx,
+		bson.M{"email": email, "logins.provider": bson.M{"$ne": l.Provider}},
+		bson.M{"$push": bson.M{"logins": l}, "$set": bson.M{"updatedAt": time.Now()}},
+	)
+	return err
+}
+
+// SetLoginSubject records the provider's user ID on a login linked before
+// the ID was known.
+func SetLoginSubject(ctx context.Context, db *mongo.Database, email string, p Provider, subject string) error {
+	_, err := collection(db).UpdateOne(ctx,
+		bson.M{"email": email, "logins.provider": p},
+		bson.M{"$set": bson.M{"logins.$.subject": subject, "updatedAt": time.Now()}},
+	)
+	return err
+}
+
+// SetPassword replaces the password login's hash, linking a password login
+// if the account did not have one.
+func SetPassword(ctx context.Context, db *mongo.Database, email, passwordHash string) error {
+	now := time.Now()
+	res, err := collection(db).UpdateOne(ctx,
+		bson.M{"email": email, "logins.provider": ProviderPassword},
+		bson.M{"$set": bson.M{"logins.$.passwordHash": passwordHash, "updatedAt": now}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+	res, err = collection(db).UpdateOne(ctx,
+		bson.M{"email": email},
+		bson.M{
+			"$push": bson.M{"logins": Login{Provider: ProviderPassword, PasswordHash: passwordHash, LinkedAt: now}},
+			"$set":  bson.M{"updatedAt": now},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdateProfile sets the given top-level profile fields and, unless
+// passwordHash is empty, the password as SetPassword would, in one write.
+func UpdateProfile(ctx context.Context, db *mongo.Database, email string, fields bson.M, passwordHash string) error {
+	now := time.Now()
+	var update any
+	if passwordHash == "" {
+		set := bson.M{"updatedAt": now}
+		for k, v := range fields {
+			set[k] = v
+		}
+		update = bson.M{"$set": set}
+	} else {
+		// An update pipeline can replace the hash or link a password login
+		// in the same write. Values are $literal so a "$" in a bio is not
+		// read as a field path.
+		set := bson.M{"updatedAt": now}
+		for k, v := range fields {
+			set[k] = bson.M{"$literal": v}
+		}
+		logins := bson.M{"$ifNull": bson.A{"$logins", bson.A{}}}
+		set["logins"] = bson.M{"$cond": bson.M{
+			"if": bson.M{"$in": bson.A{ProviderPassword, bson.M{"$ifNull": bson.A{"$logins.provider", bson.A{}}}}},
+			"then": bson.M{"$map": bson.M{
+				"input": logins,
+				"as":    "l",
+				"in": bson.M{"$cond": bson.A{
+					bson.M{"$eq": bson.A{"$$l.provider", ProviderPassword}},
+					bson.M{"$mergeObjects": bson.A{"$$l", bson.M{"passwordHash": bson.M{"$literal": passwordHash}}}},
+					"$$l",
+				}},
+			}},
+			"else": bson.M{"$concatArrays": bson.A{logins, bson.A{bson.M{"$literal": Login{Provider: ProviderPassword, PasswordHash: passwordHash, LinkedAt: now}}}}},
+		}}
+		update = bson.A{bson.M{"$set": set}}
+	}
+	res, err := collection(db).UpdateOne(ctx, bson.M{"email": email}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// List returns up to limit accounts, newest first, whose email or username
+// contains query (ignoring case). An empty query matches every account and
+// a limit of 0 means no limit.
+func List(ctx context.Context, db *mongo.Database, query string, limit int64) ([]*Account, error) {
+	filter := bson.M{}
+	if query != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"email": pattern}, bson.M{"username": pattern}}
+	}
+	cur, err := collection(db).Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []*Account{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SetDisabled locks the account out of signing in, or lets it back in.
+func SetDisabled(ctx context.Context, db *mongo.Database, email string, disabled bool) error {
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"disabledAt": now, "updatedAt": now}}
+	if !disabled {
+		update = bson.M{"$unset": bson.M{"disabledAt": ""}, "$set": bson.M{"updatedAt": now}}
+	}
+	res, err := collection(db).UpdateOne(ctx, bson.M{"email": email}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete removes the account document. Data kept elsewhere under the email
+// is the caller's to remove.
+func Delete(ctx context.Context, db *mongo.Database, email string) error {
+	res, err := collection(db).DeleteOne(ctx, bson.M{"email": email})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package accounts
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Legacy collections that MergeLegacy folds into accounts. They are left in
+// place so the result can be checked before they are dropped by hand.
+const (
+	LegacyUsersCollection  = "users"
+	LegacyGoogleCollection = "google-signup-users"
+)
+
+// MergeConflict is a disagreement between legacy documents that the merge had to
+// resolve by picking one side.
+type MergeConflict struct {
+	Email  string `json:"email"`
+	Field  string `json:"field"`
+	Kept   string `json:"kept"`
+	Reason string `json:"reason"`
+}
+
+// MergeReport summarises a MergeLegacy run.
+type MergeReport struct {
+	DryRun          bool            `json:"dryRun"`
+	LegacyUsers     int             `json:"legacyUsers"`
+	LegacyGoogle    int             `json:"legacyGoogle"`
+	Merged          int             `json:"merged"`
+	Created         int             `json:"created"`
+	AlreadyMigrated int             `json:"alreadyMigrated"`
+	Conflicts       []MergeConflict `json:"conflicts"`
+}
+
+func (r *MergeReport) conflict(email, field, kept, reason string) {
+	r.Conflicts = append(r.Conflicts, MergeConflict{Email: email, Field: field, Kept: kept, Reason: reason})
+}
+
+// MergeLegacy copies users and google-signup-users into accounts. A person
+// present in both collections becomes one account with both logins; when
+// the two documents disagree the manual signup wins and the disagreement is
+// reported. Emails that already have an account are skipped, so the merge
+// can be re-run safely.
+func MergeLegacy(ctx context.Context, db *mongo.Database, dryRun bool) (*MergeReport, error) {
+	report := &MergeReport{DryRun: dryRun}
+	merged := map[string]*Account{}
+	var order []string
+
+	manual, err := loadLegacy(ctx, db, LegacyUsersCollection)
+	if err != nil {
+		return nil, err
+	}
+	report.LegacyUsers = len(manual)
+	for _, doc := range manual {
+		a := accountFromLegacy(doc)
+		if a.Email == "" {
+			report.conflict("", "email", "", fmt.Sprintf("%s document %v has no email and was skipped", LegacyUsersCollection, doc["_id"]))
+			continue
+		}
+		if hash := stringField(doc, "password"); hash != "" {
+			a.Logins = append(a.Logins, Login{Provider: ProviderPassword, PasswordHash: hash, LinkedAt: a.CreatedAt})
+		}
+		if existing, ok := merged[a.Email]; ok {
+			report.conflict(a.Email, "email", existing.Email, fmt.Sprintf("duplicate %s documents; kept the first", LegacyUsersCollection))
+			continue
+		}
+		merged[a.Email] = a
+		order = append(order, a.Email)
+	}
+
+	google, err := loadLegacy(ctx, db, LegacyGoogleCollection)
+	if err != nil {
+		return nil, err
+	}
+	report.LegacyGoogle = len(google)
+	for _, doc := range google {
+		g := accountFromLegacy(doc)
+		if g.Email == "" {
+			report.conflict("", "email", "", fmt.Sprintf("%s document %v has no email and was skipped", LegacyGoogleCollection, doc["_id"]))
+			continue
+		}
+		googleLogin := Login{Provider: ProviderGoogle, Subject: stringField(doc, "googleSub"), LinkedAt: g.CreatedAt}
+		a, ok := merged[g.Email]
+		if !ok {
+			g.Logins = append(g.Logins, googleLogin)
+			if hash := stringField(doc, "password"); hash != "" {
+				g.Logins = append(g.Logins, Login{Provider: ProviderPassword, PasswordHash: hash, LinkedAt: g.CreatedAt})
+			}
+			merged[g.Email] = g
+			order = append(order, g.Email)
+			continue
+		}
+		report.Merged++
+		if _, linked := a.Login(ProviderGoogle); !linked {
+			a.Logins = append(a.Logins, googleLogin)
+		}
+		if hash := stringField(doc, "password"); hash != "" && hash != a.PasswordHash() {
+			if a.PasswordHash() == "" {
+				a.Logins = append(a.Logins, Login{Provider: ProviderPassword, PasswordHash: hash, LinkedAt: g.CreatedAt})
+			} else {
+				report.conflict(a.Email, "password", LegacyUsersCollection, "both collections hold different passwords")
+			}
+		}
+		mergeString(report, a.Email, "username", &a.Username, g.Username)
+		mergeString(report, a.Email, "fullName", &a.FullName, g.FullName)
+		mergeString(report, a.Email, "phone", &a.Phone, g.Phone)
+		mergeString(report, a.Email, "bio", &a.Bio, g.Bio)
+		mergeString(report, a.Email, "website", &a.Website, g.Website)
+		mergeString(report, a.Email, "avatar", &a.Avatar, g.Avatar)
+		mergeString(report, a.Email, "country", &a.Country, g.Country)
+		if len(a.Categories) == 0 {
+			a.Categories = g.Categories
+		}
+		if len(a.NewsSources) == 0 {
+			a.NewsSources = g.NewsSources
+		}
+		if !g.CreatedAt.IsZero() && g.CreatedAt.Before(a.CreatedAt) {
+			a.CreatedAt = g.CreatedAt
+		}
+	}
+
+	usernames := map[string]string{}
+	for _, email := range order {
+		a := merged[email]
+		if _, err := FindByEmail(ctx, db, email); err == nil {
+			report.AlreadyMigrated++
+			continue
+		} else if err != ErrNotFound {
+			return report, err
+		}
+		if a.Username != "" {
+			if owner, taken := usernames[a.Username]; taken {
+				report.conflict(email, "username", "", fmt.Sprintf("username %q already belongs to %s; left empty", a.Username, owner))
+				a.Username = ""
+			} else if n, err := collection(db).CountDocuments(ctx, bson.M{"username": a.Username}); err != nil {
+				return report, err
+			} else if n > 0 {
+				report.conflict(email, "username", "", fmt.Sprintf("username %q is taken by an existing account; left empty", a.Username))
+				a.Username = ""
+			} else {
+				usernames[a.Username] = email
+			}
+		}
+		if len(a.Logins) == 0 {
+			report.conflict(email, "logins", "", "account has no login method and can only be recovered by password reset")
+		}
+		report.Created++
+		if dryRun {
+			continue
+		}
+		if err := Create(ctx, db, a); err != nil {
+			return report, fmt.Errorf("creating account for %s: %w", email, err)
+		}
+	}
+	return report, nil
+}
+
+func loadLegacy(ctx context.Context, db *mongo.Database, name string) ([]bson.M, error) {
+	cur, err := db.Collection(name).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	defer cur.Close(ctx)
+	var docs []bson.M
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", name, err)
+	}
+	return docs, nil
+}
+
+func accountFromLegacy(doc bson.M) *Account {
+	a := &Account{
+		Email:       NormalizeEmail(stringField(doc, "email")),
+		Username:    stringField(doc, "username"),
+		FullName:    stringField(doc, "fullName"),
+		Phone:       stringField(doc, "phone"),
+		Bio:         stringField(doc, "bio"),
+		Website:     stringField(doc, "website"),
+		Avatar:      stringField(doc, "avatar"),
+		Country:     stringField(doc, "country"),
+		Categories:  stringsField(doc, "categories"),
+		NewsSources: stringsField(doc, "newsSources"),
+		Logins:      []Login{},
+	}
+	switch t := doc["createdAt"].(type) {
+	case primitive.DateTime:
+		a.CreatedAt = t.Time()
+	case time.Time:
+		a.CreatedAt = t
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	return a
+}
+
+func mergeString(report *MergeReport, email, field string, dst *string, other string) {
+	switch {
+	case other == "" || other == *dst:
+	case *dst == "":
+		*dst = other
+	default:
+		report.conflict(email, field, *dst, fmt.Sprintf("%s has %q", LegacyGoogleCollection, other))
+	}
+}
+
+func stringField(doc bson.M, key string) string {
+	s, _ := doc[key].(string)
+	return s
+}
+
+func stringsField(doc bson.M, key string) []string {
+	arr, ok := doc[key].(bson.A)
+	if !ok {
+		return nil
+	}
+	out := make([]string, 0, len(arr))
+	for _, v := range arr {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
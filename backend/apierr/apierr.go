@@ -0,0 +1,107 @@
+// Package apierr is the error half of the API: every failed request is
+// answered with
+//
+//	{"error": {"code": "OTP_EXPIRED", "message": "OTP expired", "details": ...}}
+//
+// so clients branch on the stable code and only show the message.
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Code identifies an error for clients. Codes are part of the API and must
+// not be renamed.
+type Code string
+
+const (
+	// Requests the server could not accept
+	InvalidJSON      Code = "INVALID_JSON"
+	ValidationFailed Code = "VALIDATION_FAILED"
+	BodyTooLarge     Code = "BODY_TOO_LARGE"
+	RouteNotFound    Code = "ROUTE_NOT_FOUND"
+	MethodNotAllowed Code = "METHOD_NOT_ALLOWED"
+	RateLimited      Code = "RATE_LIMITED"
+
+	// Authentication and authorisation
+	Unauthenticated       Code = "UNAUTHENTICATED"
+	InvalidToken          Code = "INVALID_TOKEN"
+	SessionRevoked        Code = "SESSION_REVOKED"
+	InvalidRefreshToken   Code = "INVALID_REFRESH_TOKEN"
+	InvalidCredentials    Code = "INVALID_CREDENTIALS"
+	PasswordLoginDisabled Code = "PASSWORD_LOGIN_UNAVAILABLE"
+	GoogleTokenInvalid    Code = "GOOGLE_TOKEN_INVALID"
+	GoogleNotConfigured   Code = "GOOGLE_NOT_CONFIGURED"
+	Forbidden             Code = "FORBIDDEN"
+	AccountDisabled       Code = "ACCOUNT_DISABLED"
+
+	// Accounts
+	UserNotFound  Code = "USER_NOT_FOUND"
+	UsernameTaken Code = "USERNAME_TAKEN"
+	EmailTaken    Code = "EMAIL_TAKEN"
+	AccountExists Code = "ACCOUNT_EXISTS"
+	// The Google account already signs in to a different account
+	GoogleAccountLinked Code = "GOOGLE_ACCOUNT_LINKED"
+
+	// One-time codes and reset tokens
+	OTPNotFound        Code = "OTP_NOT_FOUND"
+	OTPExpired         Code = "OTP_EXPIRED"
+	OTPInvalid         Code = "OTP_INVALID"
+	OTPTooManyAttempts Code = "OTP_TOO_MANY_ATTEMPTS"
+	ResetTokenInvalid  Code = "RESET_TOKEN_INVALID"
+	ResetTokenExpired  Code = "RESET_TOKEN_EXPIRED"
+
+	// News
+	NotFound        Code = "NOT_FOUND"
+	ArticleNotFound Code = "ARTICLE_NOT_FOUND"
+
+	// Failures on our side or upstream. Messages for these never carry
+	// upstream response bodies.
+	Internal           Code = "INTERNAL"
+	UpstreamError      Code = "UPSTREAM_ERROR"
+	ServiceUnavailable Code = "SERVICE_UNAVAILABLE"
+)
+
+// Error is an API error with the HTTP status it is sent with.
+type Error struct {
+	Status  int    `json:"-"`
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// WithDetails returns a copy of e carrying machine-readable details, such
+// as the fields that failed validation.
+func (e *Error) WithDetails(details any) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// Write sends an error response.
+func Write(w http.ResponseWriter, status int, code Code, message string) {
+	WriteError(w, New(status, code, message))
+}
+
+// WriteError sends err as an error response. Errors that are not an *Error
+// become a 500 whose message reveals nothing about the cause.
+func WriteError(w http.ResponseWriter, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = New(http.StatusInternalServerError, Internal, "Internal server error")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(map[string]*Error{"error": e})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"backend/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// readDocuments reads a JSON array of objects from path, or stdin for "-".
+// An object holding the array under key, such as a saved NewsAPI response
+// with its "articles", is accepted too.
+func readDocuments(path, key string) ([]repository.Document, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	var docs []repository.Document
+	if err := json.Unmarshal(raw, &docs); err == nil {
+		return docs, nil
+	}
+	var wrapped map[string][]repository.Document
+	if err := json.Unmarshal(raw, &wrapped); err != nil || wrapped[key] == nil {
+		return nil, fmt.Errorf("%s must hold a JSON array of objects, or an object with one under %q", path, key)
+	}
+	return wrapped[key], nil
+}
+
+func topicsList(e *env, args []string) error {
+	fs, out := flags("topics list")
+	if _, err := parse(fs, args, 0, "no arguments"); err != nil {
+		return err
+	}
+	ctx, cancel := timeout(30 * time.Second)
+	defer cancel()
+	topics, err := e.repos.Topics.List(ctx)
+	if err != nil {
+		return err
+	}
+	return out.documents(topics, "_id", "name", "title")
+}
+
+// topicsSeed replaces the explore topics with the file's.
+func topicsSeed(e *env, args []string) error {
+	fs, out := flags("topics seed")
+	pos, err := parse(fs, args, 1, "a JSON file of topics, or - for stdin")
+	if err != nil {
+		return err
+	}
+	topics, err := readDocuments(pos[0], "topics")
+	if err != nil {
+		return err
+	}
+	if len(topics) == 0 {
+		return errors.New("refusing to replace the topics with an empty list")
+	}
+	ctx, cancel := timeout(time.Minute)
+	defer cancel()
+	if err := e.repos.Topics.Replace(ctx, topics); err != nil {
+		return err
+	}
+	return out.fields(map[string]int{"topics": len(topics)}, [][2]string{{"topics", fmt.Sprint(len(topics))}})
+}
+
+// newsImport adds curated articles to the explore tab. Articles already
+// stored under the same URL are replaced.
+func newsImport(e *env, args []string) error {
+	fs, out := flags("news import")
+	category := fs.String("category", "", "set this category on articles that have none")
+	trending := fs.Bool("trending", false, "mark every imported article as trending")
+	pos, err := parse(fs, args, 1, "a JSON file of articles, or - for stdin")
+	if err != nil {
+		return err
+	}
+	docs, err := readDocuments(pos[0], "articles")
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if c, _ := doc["category"].(string); c == "" && *category != "" {
+			doc["category"] = *category
+		}
+		if *trending {
+			doc["trending"] = true
+		}
+	}
+	ctx, cancel := timeout(10 * time.Minute)
+	defer cancel()
+	added, replaced, err := e.repos.News.Import(ctx, docs)
+	result := map[string]int{"added": added, "replaced": replaced}
+	if perr := out.fields(result, [][2]string{{"added", fmt.Sprint(added)}, {"replaced", fmt.Sprint(replaced)}}); err == nil {
+		err = perr
+	}
+	return err
+}
+
+// newsPurge removes curated articles imported, or history entries viewed,
+// longer ago than the given ages.
+func newsPurge(e *env, args []string) error {
+	fs, out := flags("news purge")
+	olderThan := fs.Duration("older-than", 0, "remove curated articles imported longer ago than this, e.g. 720h")
+	viewedOlderThan := fs.Duration("viewed-older-than", 0, "remove reading history entries viewed longer ago than this, e.g. 2160h")
+	if _, err := parse(fs, args, 0, "no arguments"); err != nil {
+		return err
+	}
+	if *olderThan <= 0 && *viewedOlderThan <= 0 {
+		return errors.New("set -older-than, -viewed-older-than or both to a positive duration")
+	}
+	ctx, cancel := timeout(10 * time.Minute)
+	defer cancel()
+	now := time.Now()
+	result := map[string]int{"news": 0, "viewedNews": 0}
+	if *olderThan > 0 {
+		n, err := e.repos.News.PurgeBefore(ctx, now.Add(-*olderThan))
+		if err != nil {
+			return err
+		}
+		result["news"] = n
+	}
+	if *viewedOlderThan > 0 {
+		n, err := e.repos.ViewedNews.PurgeBefore(ctx, now.Add(-*viewedOlderThan))
+		if err != nil {
+			return err
+		}
+		result["viewedNews"] = n
+	}
+	return out.fields(result, [][2]string{{"news", fmt.Sprint(result["news"])}, {"viewedNews", fmt.Sprint(result["viewedNews"])}})
+}
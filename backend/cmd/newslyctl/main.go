@@ -0,0 +1,164 @@
+// Command newslyctl operates a Newsly backend from the shell: it reads the
+// same configuration as the server (flags, environment and .env) and works
+// on the same database through the repositories.
+//
+//	newslyctl [config flags] <group> <command> [flags] [args]
+//
+// e.g. `newslyctl -mongo-database newsly users list -q gmail -o table`.
+// Every command prints JSON unless given -o table.
+package main
+
+import (
+	"backend/config"
+	"backend/db"
+	"backend/logging"
+	"backend/otp"
+	"backend/repository"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// env is what every command works with.
+type env struct {
+	repos *repository.Repositories
+	otps  otp.Store
+}
+
+// stdout is where commands print their results.
+var stdout io.Writer = os.Stdout
+
+// command is one `<group> <command>`. run gets the arguments after it.
+type command struct {
+	usage string
+	run   func(e *env, args []string) error
+}
+
+var commands = map[string]map[string]command{
+	"users": {
+		"list":    {"[-q text] [-limit n]", usersList},
+		"show":    {"EMAIL", usersShow},
+		"disable": {"EMAIL", usersDisable},
+		"enable":  {"EMAIL", usersEnable},
+		"delete":  {"-yes EMAIL", usersDelete},
+	},
+	"topics": {
+		"list": {"", topicsList},
+		"seed": {"FILE", topicsSeed},
+	},
+	"news": {
+		"import": {"[-category c] [-trending] FILE", newsImport},
+		"purge":  {"[-older-than d] [-viewed-older-than d]", newsPurge},
+	},
+	"indexes": {
+		"sync": {"[-dry-run] [-prune]", indexesSync},
+	},
+	"otp": {
+		"flush": {"-email EMAIL | -all", otpFlush},
+	},
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("newslyctl: ")
+
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		usage()
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format))
+	// SetDefault routes the log package through slog; keep plain errors
+	log.SetOutput(os.Stderr)
+
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[args[0]][args[1]]
+	if !ok {
+		usage()
+		log.Fatalf("unknown command %q", strings.Join(args[:2], " "))
+	}
+	if err := cfg.ValidateMongo(); err != nil {
+		log.Fatal(err)
+	}
+	db.ConnectMongo(cfg.Mongo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	otps, err := otp.NewMongoStore(ctx, db.MongoDatabase)
+	if err != nil {
+		log.Fatal("setting up OTP store: ", err)
+	}
+	e := &env{repos: repository.NewMongo(db.MongoDatabase), otps: otps}
+	if err := cmd.run(e, args[2:]); err != nil {
+		log.Fatalf("%s %s: %v", args[0], args[1], err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: newslyctl [config flags] <group> <command> [-o json|table] [flags] [args]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	groups := make([]string, 0, len(commands))
+	for g := range commands {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		names := make([]string, 0, len(commands[g]))
+		for n := range commands[g] {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			fmt.Fprintln(os.Stderr, strings.TrimRight("  "+g+" "+n+" "+commands[g][n].usage, " "))
+		}
+	}
+	fmt.Fprintln(os.Stderr, "\nRun `newslyctl -h` for the config flags, or add -h after a command for its own.")
+}
+
+// parse parses fs from args, allowing flags after positional arguments
+// (`users show a@b.c -o table`), and returns the positional ones. want is
+// how many there must be.
+func parse(fs *flag.FlagSet, args []string, want int, names string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != want {
+		return nil, fmt.Errorf("expected %s", names)
+	}
+	return positional, nil
+}
+
+// flags returns a flag set for a command with the shared -o flag.
+func flags(name string) (*flag.FlagSet, *output) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	out := &output{format: "json", w: stdout}
+	fs.Func("o", "output format: json or table", out.set)
+	return fs, out
+}
+
+// timeout bounds a command's database work.
+func timeout(d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), d)
+}
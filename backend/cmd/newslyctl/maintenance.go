@@ -0,0 +1,66 @@
+package main
+
+import (
+	"backend/db"
+	"backend/repository"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// indexesSync is the server's sync-indexes command with table output.
+func indexesSync(e *env, args []string) error {
+	fs, out := flags("indexes sync")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing anything")
+	prune := fs.Bool("prune", false, "also drop indexes that are not declared")
+	if _, err := parse(fs, args, 0, "no arguments"); err != nil {
+		return err
+	}
+	// Building an index over a large collection can take a while
+	ctx, cancel := timeout(30 * time.Minute)
+	defer cancel()
+	report, err := repository.SyncIndexes(ctx, db.MongoDatabase, *dryRun, *prune)
+	if report != nil {
+		var rows [][]string
+		for _, group := range []struct {
+			action  string
+			indexes []string
+		}{
+			{"created", report.Created},
+			{"rebuilt", report.Rebuilt},
+			{"dropped", report.Dropped},
+			{"extra", report.Extra},
+			{"unchanged", report.Unchanged},
+		} {
+			for _, idx := range group.indexes {
+				rows = append(rows, []string{idx, group.action})
+			}
+		}
+		if perr := out.print(report, []string{"INDEX", "ACTION"}, rows); err == nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+// otpFlush removes outstanding codes and rate limit counters, e.g. to let
+// a user who hit the limit request a code again.
+func otpFlush(e *env, args []string) error {
+	fs, out := flags("otp flush")
+	email := fs.String("email", "", "only this address's codes and counters")
+	all := fs.Bool("all", false, "every code and counter, including per-network ones")
+	if _, err := parse(fs, args, 0, "no arguments"); err != nil {
+		return err
+	}
+	if (*email == "") == !*all {
+		return errors.New("set exactly one of -email and -all")
+	}
+	ctx, cancel := timeout(time.Minute)
+	defer cancel()
+	codes, counters, err := e.otps.Flush(ctx, *email)
+	if err != nil {
+		return err
+	}
+	result := map[string]int{"codes": codes, "counters": counters}
+	return out.fields(result, [][2]string{{"codes", fmt.Sprint(codes)}, {"counters", fmt.Sprint(counters)}})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// output prints a command's result as indented JSON or as an aligned table.
+type output struct {
+	format string
+	w      io.Writer
+}
+
+func (o *output) set(v string) error {
+	if v != "json" && v != "table" {
+		return fmt.Errorf("%q is not json or table", v)
+	}
+	o.format = v
+	return nil
+}
+
+// print writes v as JSON, or header and rows as a table.
+func (o *output) print(v any, header []string, rows [][]string) error {
+	if o.format == "json" {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// fields prints a single record as a two-column table, or v as JSON.
+func (o *output) fields(v any, pairs [][2]string) error {
+	rows := make([][]string, len(pairs))
+	for i, p := range pairs {
+		rows[i] = []string{p[0], p[1]}
+	}
+	return o.print(v, []string{"FIELD", "VALUE"}, rows)
+}
+
+// documents prints free-form documents with a column per field, the given
+// ones first and the rest alphabetically.
+func (o *output) documents(docs []map[string]any, first ...string) error {
+	seen := map[string]bool{}
+	var rest []string
+	for _, doc := range docs {
+		for k := range doc {
+			if !seen[k] {
+				seen[k] = true
+				rest = append(rest, k)
+			}
+		}
+	}
+	var columns []string
+	for _, k := range first {
+		if seen[k] {
+			columns = append(columns, k)
+			delete(seen, k)
+		}
+	}
+	sort.Strings(rest)
+	for _, k := range rest {
+		if seen[k] {
+			columns = append(columns, k)
+		}
+	}
+
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = strings.ToUpper(c)
+	}
+	rows := make([][]string, len(docs))
+	for i, doc := range docs {
+		row := make([]string, len(columns))
+		for j, c := range columns {
+			if v, ok := doc[c]; ok {
+				row[j] = cell(v)
+			}
+		}
+		rows[i] = row
+	}
+	return o.print(docs, header, rows)
+}
+
+// cell formats a value for a table, on one line and at most 60 runes.
+func cell(v any) string {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return timestamp(v)
+	case primitive.DateTime:
+		return timestamp(v.Time())
+	case primitive.ObjectID:
+		return v.Hex()
+	case string:
+		s = v
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			s = fmt.Sprint(v)
+		} else {
+			s = string(b)
+		}
+	}
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > 60 {
+		s = string(r[:59]) + "…"
+	}
+	return s
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"backend/accounts"
+	"backend/repository"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// userView is an account as operators see it: everything but credentials.
+type userView struct {
+	ID           string     `json:"id"`
+	Email        string     `json:"email"`
+	Username     string     `json:"username,omitempty"`
+	LoginMethods []string   `json:"loginMethods"`
+	FullName     string     `json:"fullName,omitempty"`
+	Phone        string     `json:"phone,omitempty"`
+	Bio          string     `json:"bio,omitempty"`
+	Website      string     `json:"website,omitempty"`
+	Avatar       string     `json:"avatar,omitempty"`
+	Country      string     `json:"country,omitempty"`
+	Categories   []string   `json:"categories,omitempty"`
+	NewsSources  []string   `json:"newsSources,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+	DisabledAt   *time.Time `json:"disabledAt,omitempty"`
+}
+
+func viewUser(a *accounts.Account) userView {
+	methods := make([]string, 0, len(a.Logins))
+	for _, p := range a.Providers() {
+		methods = append(methods, string(p))
+	}
+	return userView{
+		ID:           a.ID.Hex(),
+		Email:        a.Email,
+		Username:     a.Username,
+		LoginMethods: methods,
+		FullName:     a.FullName,
+		Phone:        a.Phone,
+		Bio:          a.Bio,
+		Website:      a.Website,
+		Avatar:       a.Avatar,
+		Country:      a.Country,
+		Categories:   a.Categories,
+		NewsSources:  a.NewsSources,
+		CreatedAt:    a.CreatedAt,
+		UpdatedAt:    a.UpdatedAt,
+		DisabledAt:   a.DisabledAt,
+	}
+}
+
+func (u userView) disabled() string {
+	if u.DisabledAt == nil {
+		return ""
+	}
+	return timestamp(*u.DisabledAt)
+}
+
+func usersList(e *env, args []string) error {
+	fs, out := flags("users list")
+	query := fs.String("q", "", "only accounts whose email or username contains this, ignoring case")
+	limit := fs.Int("limit", 50, "at most this many accounts, newest first; 0 for all")
+	if _, err := parse(fs, args, 0, "no arguments"); err != nil {
+		return err
+	}
+	ctx, cancel := timeout(time.Minute)
+	defer cancel()
+	list, err := e.repos.Users.List(ctx, *query, *limit)
+	if err != nil {
+		return err
+	}
+	views := make([]userView, len(list))
+	rows := make([][]string, len(list))
+	for i, a := range list {
+		views[i] = viewUser(a)
+		rows[i] = []string{a.Email, a.Username, strings.Join(views[i].LoginMethods, ","), timestamp(a.CreatedAt), views[i].disabled()}
+	}
+	return out.print(views, []string{"EMAIL", "USERNAME", "LOGINS", "CREATED", "DISABLED"}, rows)
+}
+
+func usersShow(e *env, args []string) error {
+	fs, out := flags("users show")
+	pos, err := parse(fs, args, 1, "the account's email")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := timeout(30 * time.Second)
+	defer cancel()
+	a, err := findUser(ctx, e, accounts.NormalizeEmail(pos[0]))
+	if err != nil {
+		return err
+	}
+	v := viewUser(a)
+	return out.fields(v, [][2]string{
+		{"id", v.ID},
+		{"email", v.Email},
+		{"username", v.Username},
+		{"loginMethods", strings.Join(v.LoginMethods, ",")},
+		{"fullName", v.FullName},
+		{"phone", v.Phone},
+		{"bio", cell(v.Bio)},
+		{"website", v.Website},
+		{"avatar", v.Avatar},
+		{"country", v.Country},
+		{"categories", strings.Join(v.Categories, ",")},
+		{"newsSources", strings.Join(v.NewsSources, ",")},
+		{"createdAt", timestamp(v.CreatedAt)},
+		{"updatedAt", timestamp(v.UpdatedAt)},
+		{"disabledAt", v.disabled()},
+	})
+}
+
+// usersDisable locks the account out and signs it out everywhere; an access
+// token already issued lapses within its TTL because its session is gone.
+func usersDisable(e *env, args []string) error {
+	return setDisabled(e, "users disable", args, true)
+}
+
+func usersEnable(e *env, args []string) error {
+	return setDisabled(e, "users enable", args, false)
+}
+
+func setDisabled(e *env, name string, args []string, disabled bool) error {
+	fs, out := flags(name)
+	pos, err := parse(fs, args, 1, "the account's email")
+	if err != nil {
+		return err
+	}
+	email := accounts.NormalizeEmail(pos[0])
+	ctx, cancel := timeout(30 * time.Second)
+	defer cancel()
+	if err := e.repos.Users.SetDisabled(ctx, email, disabled); err != nil {
+		return notFound(err, email)
+	}
+	if disabled {
+		if err := e.repos.Sessions.RevokeAll(ctx, email); err != nil {
+			return fmt.Errorf("account disabled but revoking its sessions failed: %w", err)
+		}
+	}
+	result := map[string]any{"email": email, "disabled": disabled}
+	return out.fields(result, [][2]string{{"email", email}, {"disabled", fmt.Sprint(disabled)}})
+}
+
+// usersDelete removes the account with its sessions, bookmarks, reading
+// history and outstanding codes. It cannot be undone, hence -yes.
+func usersDelete(e *env, args []string) error {
+	fs, out := flags("users delete")
+	yes := fs.Bool("yes", false, "confirm the account and its data should be deleted")
+	pos, err := parse(fs, args, 1, "the account's email")
+	if err != nil {
+		return err
+	}
+	email := accounts.NormalizeEmail(pos[0])
+	if !*yes {
+		return fmt.Errorf("this permanently deletes %s and its data; run again with -yes", email)
+	}
+	ctx, cancel := timeout(time.Minute)
+	defer cancel()
+	deleted, err := e.repos.DeleteUser(ctx, email)
+	if err != nil {
+		return notFound(err, email)
+	}
+	codes, _, err := e.otps.Flush(ctx, email)
+	if err != nil {
+		return fmt.Errorf("account deleted but flushing its codes failed: %w", err)
+	}
+	result := struct {
+		Email string `json:"email"`
+		repository.Deleted
+		Codes int `json:"codes"`
+	}{email, deleted, codes}
+	return out.fields(result, [][2]string{
+		{"email", email},
+		{"sessions", fmt.Sprint(deleted.Sessions)},
+		{"bookmarks", fmt.Sprint(deleted.Bookmarks)},
+		{"viewedNews", fmt.Sprint(deleted.ViewedNews)},
+		{"codes", fmt.Sprint(codes)},
+	})
+}
+
+func findUser(ctx context.Context, e *env, email string) (*accounts.Account, error) {
+	a, err := e.repos.Users.FindByEmail(ctx, email)
+	return a, notFound(err, email)
+}
+
+// notFound names the email when err is accounts.ErrNotFound.
+func notFound(err error, email string) error {
+	if errors.Is(err, accounts.ErrNotFound) {
+		return fmt.Errorf("no account with email %s", email)
+	}
+	return err
+}
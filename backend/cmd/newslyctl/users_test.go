@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/accounts"
+	"backend/otp"
+	"backend/repository"
+)
+
+// newTestEnv returns an env over in-memory repositories holding one
+// account, ada@example.com, signed in on two devices with a bookmark and an
+// outstanding code. Command output goes to the returned buffer.
+func newTestEnv(t *testing.T) (*env, *bytes.Buffer) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+	e := &env{repos: repository.NewMemory(), otps: otp.NewMemoryStore()}
+	if err := e.repos.Users.Create(ctx, &accounts.Account{
+		Email:     "ada@example.com",
+		Username:  "ada",
+		Logins:    []accounts.Login{{Provider: accounts.ProviderPassword, PasswordHash: "hash", LinkedAt: now}},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"phone", "laptop"} {
+		if err := e.repos.Sessions.Create(ctx, repository.Session{
+			ID: id, Email: "ada@example.com", RefreshHash: id,
+			CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := e.repos.Bookmarks.Add(ctx, "ada@example.com", repository.Article{"url": "https://news.example.com/a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.otps.Put(ctx, otp.Record{
+		Purpose: otp.PurposePasswordReset, Email: "ada@example.com", CodeHash: "code",
+		CreatedAt: now, ExpiresAt: now.Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	old := stdout
+	stdout = &buf
+	t.Cleanup(func() { stdout = old })
+	return e, &buf
+}
+
+func TestUsersDisable(t *testing.T) {
+	e, _ := newTestEnv(t)
+	ctx := context.Background()
+
+	if err := usersDisable(e, []string{" Ada@Example.com "}); err != nil {
+		t.Fatal(err)
+	}
+	a, err := e.repos.Users.FindByEmail(ctx, "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Disabled() {
+		t.Fatal("account is not disabled")
+	}
+	for _, id := range []string{"phone", "laptop"} {
+		if active, err := e.repos.Sessions.Active(ctx, id, "ada@example.com"); err != nil || active {
+			t.Fatalf("session %s still active (%v) after disable", id, err)
+		}
+	}
+
+	if err := usersEnable(e, []string{"ada@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := e.repos.Users.FindByEmail(ctx, "ada@example.com"); a.Disabled() {
+		t.Fatal("account still disabled after enable")
+	}
+
+	err = usersDisable(e, []string{"nobody@example.com"})
+	if err == nil || !strings.Contains(err.Error(), "no account with email nobody@example.com") {
+		t.Fatalf("disabling an unknown account returned %v", err)
+	}
+}
+
+func TestUsersDelete(t *testing.T) {
+	e, out := newTestEnv(t)
+	ctx := context.Background()
+
+	err := usersDelete(e, []string{"ada@example.com"})
+	if err == nil || !strings.Contains(err.Error(), "-yes") {
+		t.Fatalf("delete without -yes returned %v", err)
+	}
+	if _, err := e.repos.Users.FindByEmail(ctx, "ada@example.com"); err != nil {
+		t.Fatalf("account gone after a refused delete: %v", err)
+	}
+	if active, _ := e.repos.Sessions.Active(ctx, "phone", "ada@example.com"); !active {
+		t.Fatal("refused delete touched the sessions")
+	}
+
+	if err := usersDelete(e, []string{"-yes", "Ada@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Email     string `json:"email"`
+		Sessions  int    `json:"sessions"`
+		Bookmarks int    `json:"bookmarks"`
+		Codes     int    `json:"codes"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("output %q: %v", out, err)
+	}
+	if result.Email != "ada@example.com" || result.Sessions != 2 || result.Bookmarks != 1 || result.Codes != 1 {
+		t.Fatalf("deleted %+v, want 2 sessions, 1 bookmark and 1 code", result)
+	}
+	if _, err := e.repos.Users.FindByEmail(ctx, "ada@example.com"); !errors.Is(err, accounts.ErrNotFound) {
+		t.Fatalf("account still there: %v", err)
+	}
+	if rec, _ := e.otps.Get(ctx, otp.PurposePasswordReset, "ada@example.com"); rec != nil {
+		t.Fatal("reset code survived the delete")
+	}
+
+	err = usersDelete(e, []string{"-yes", "ada@example.com"})
+	if err == nil || !strings.Contains(err.Error(), "no account") {
+		t.Fatalf("deleting again returned %v", err)
+	}
+}
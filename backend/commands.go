@@ -0,0 +1,117 @@
+package main
+
+import (
+	"backend/accounts"
+	"backend/config"
+	"backend/db"
+	"backend/migrations"
+	"backend/repository"
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+)
+
+// runCommand runs a one-shot maintenance command instead of the server.
+func runCommand(cfg *config.Config, name string, args []string) {
+	switch name {
+	case "migrate-accounts":
+		migrateAccounts(args)
+	case "sync-indexes":
+		syncIndexes(args)
+	case "migrate":
+		migrate(args)
+	default:
+		log.Fatalf("Unknown command %q (available: migrate, migrate-accounts, sync-indexes)", name)
+	}
+}
+
+// migrateAccounts merges the legacy users and google-signup-users
+// collections into accounts and prints a JSON report of what it did.
+func migrateAccounts(args []string) {
+	fs := flag.NewFlagSet("migrate-accounts", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would be merged without writing anything")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	report, err := accounts.MergeLegacy(ctx, db.MongoDatabase, *dryRun)
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	}
+	if err != nil {
+		log.Fatal("Account migration failed: ", err)
+	}
+	log.Printf("Created %d accounts (%d merged from both collections, %d already migrated), %d conflicts",
+		report.Created, report.Merged, report.AlreadyMigrated, len(report.Conflicts))
+}
+
+// syncIndexes creates and updates the indexes declared in
+// repository.Indexes and prints a JSON report of what changed.
+func syncIndexes(args []string) {
+	fs := flag.NewFlagSet("sync-indexes", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing anything")
+	prune := fs.Bool("prune", false, "also drop indexes that are not declared")
+	fs.Parse(args)
+
+	// Building an index over a large collection can take a while
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	report, err := repository.SyncIndexes(ctx, db.MongoDatabase, *dryRun, *prune)
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	}
+	if err != nil {
+		log.Fatal("Index sync failed: ", err)
+	}
+	log.Printf("Created %d indexes, rebuilt %d, dropped %d; found %d undeclared",
+		len(report.Created), len(report.Rebuilt), len(report.Dropped), len(report.Extra))
+}
+
+// migrate runs `migrate up [-dry-run] [-to ID]` or `migrate status`.
+func migrate(args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: migrate up [-dry-run] [-to ID] | migrate status")
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	switch args[0] {
+	case "status":
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		statuses, err := migrations.List(ctx, db.MongoDatabase)
+		if err != nil {
+			log.Fatal("Failed to read migration status: ", err)
+		}
+		enc.Encode(statuses)
+		pending := 0
+		for _, s := range statuses {
+			if s.Applied == nil {
+				pending++
+			}
+		}
+		log.Printf("%d migrations, %d pending", len(statuses), pending)
+	case "up":
+		fs := flag.NewFlagSet("migrate up", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "report what each pending migration would do without writing anything")
+		target := fs.String("to", "", "stop after this migration ID")
+		fs.Parse(args[1:])
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+		results, err := migrations.Up(ctx, db.MongoDatabase, *target, *dryRun)
+		enc.Encode(results)
+		if err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+		log.Printf("Ran %d migrations", len(results))
+	default:
+		log.Fatalf("Unknown migrate subcommand %q (available: up, status)", args[0])
+	}
+}
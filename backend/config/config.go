@@ -0,0 +1,633 @@
+// Package config loads the server's settings from command-line flags, the
+// environment and an optional dotenv-style file, in that order of
+// precedence, and validates them before anything starts.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+type Config struct {
+	Server    ServerConfig
+	Mongo     MongoConfig
+	JWT       JWTConfig
+	Google    GoogleConfig
+	OTP       OTPConfig
+	Mail      MailConfig
+	News      NewsConfig
+	NewsAPI   NewsAPIConfig
+	Gemini    GeminiConfig
+	RateLimit RateLimitConfig
+	CORS      CORSConfig
+	Log       LogConfig
+	Tracing   TracingConfig
+}
+
+type LogConfig struct {
+	Level  slog.Level
+	Format string // "text" or "json"
+}
+
+type TracingConfig struct {
+	Exporter     string // "none", "stdout" or "otlp"
+	OTLPEndpoint string
+	ServiceName  string
+	SampleRatio  float64
+}
+
+type ServerConfig struct {
+	Addr              string
+	MaxBodyBytes      int
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// How long in-flight requests get to finish after SIGTERM or SIGINT
+	ShutdownTimeout time.Duration
+}
+
+type MongoConfig struct {
+	URI              string
+	Database         string
+	ConnectTimeout   time.Duration
+	OperationTimeout time.Duration
+	// SyncIndexes reconciles the declared indexes when the server starts
+	SyncIndexes bool
+}
+
+type JWTConfig struct {
+	// Secret signs access tokens and keys the OTP and reset token hashes
+	Secret     string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+type GoogleConfig struct {
+	// ClientIDs are the accepted ID token audiences; Google sign-in is
+	// disabled when empty
+	ClientIDs []string
+	JWKSURL   string
+}
+
+type OTPConfig struct {
+	Length         int
+	TTL            time.Duration
+	MaxAttempts    int
+	ResendCooldown time.Duration
+	EmailLimit     int
+	IPLimit        int
+	LimitWindow    time.Duration
+	ResetTokenTTL  time.Duration
+}
+
+type MailConfig struct {
+	// Backend is "smtp", "log" or "capture"
+	Backend string
+	From    string
+	// Dir is where the log backend writes messages; empty logs them
+	Dir  string
+	SMTP SMTPConfig
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+type NewsConfig struct {
+	// Provider is where articles come from: "newsapi" or "rss"
+	Provider string
+	// FeedsFile lists the RSS and Atom feeds with the category and
+	// country each covers; see news.LoadFeeds
+	FeedsFile string
+	// FeedsRefresh is how long a fetched feed is served before it is
+	// fetched again
+	FeedsRefresh time.Duration
+	FeedsTimeout time.Duration
+}
+
+type NewsAPIConfig struct {
+	Key     string
+	BaseURL string
+	Timeout time.Duration
+}
+
+type GeminiConfig struct {
+	Key     string
+	BaseURL string
+	Model   string
+	Timeout time.Duration
+}
+
+type RateLimitConfig struct {
+	// Backend is "memory" (per instance) or "mongo" (shared by all)
+	Backend string
+	// TrustedProxies may set X-Forwarded-For; requests from anywhere else
+	// are attributed to the connecting address
+	TrustedProxies []netip.Prefix
+	Auth           RatePolicy // sign-up, sign-in, OTP and reset, per IP
+	News           RatePolicy // news provider reads, per user or IP
+	Summary        RatePolicy // Gemini summaries, per user or IP
+	API            RatePolicy // every other route, per user or IP
+}
+
+// RatePolicy allows Burst requests at once, refilled at Limit per Per.
+// A zero Limit turns the policy off.
+type RatePolicy struct {
+	Limit int
+	Per   time.Duration
+	Burst int
+}
+
+// CORSConfig decides which web origins may call the API from a browser.
+// Requests without an Origin header, such as those from the native app,
+// are not affected.
+type CORSConfig struct {
+	// AllowedOrigins are origins such as https://app.example.com; "*"
+	// allows any origin and cannot be combined with credentials
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight answer
+	MaxAge time.Duration
+}
+
+// binding ties one setting to its place in Config. The setting is read from
+// the environment variable Key, the file entry Key, or the flag derived
+// from Key (MONGO_URI becomes -mongo-uri).
+type binding struct {
+	Key     string
+	Default string
+	Usage   string
+	set     func(string) error
+}
+
+func bindings(c *Config) []binding {
+	return []binding{
+		{"LOG_LEVEL", "info", "debug, info, warn or error", levelVar(&c.Log.Level)},
+		{"LOG_FORMAT", "text", "text for people, json for log pipelines", choiceVar(&c.Log.Format, "text", "json")},
+
+		{"TRACING_EXPORTER", "none", "where trace spans go: none, stdout or otlp", choiceVar(&c.Tracing.Exporter, "none", "stdout", "otlp")},
+		{"TRACING_OTLP_ENDPOINT", "", "OTLP/HTTP collector URL, e.g. http://localhost:4318 (default: the OTEL_EXPORTER_OTLP_* variables)", stringVar(&c.Tracing.OTLPEndpoint)},
+		{"TRACING_SERVICE_NAME", "newsly-backend", "service.name reported on spans", stringVar(&c.Tracing.ServiceName)},
+		{"TRACING_SAMPLE_RATIO", "1", "fraction of new traces to record, from 0 to 1", ratioVar(&c.Tracing.SampleRatio)},
+
+		{"SERVER_ADDR", ":8080", "address the HTTP server listens on", stringVar(&c.Server.Addr)},
+		{"SERVER_MAX_BODY_BYTES", "1048576", "largest request body accepted, in bytes (0 for no limit)", intVar(&c.Server.MaxBodyBytes)},
+		{"SERVER_READ_TIMEOUT", "15s", "time allowed to read a whole request", durationVar(&c.Server.ReadTimeout)},
+		{"SERVER_READ_HEADER_TIMEOUT", "5s", "time allowed to read request headers", durationVar(&c.Server.ReadHeaderTimeout)},
+		{"SERVER_WRITE_TIMEOUT", "60s", "time allowed to handle a request and write the response", durationVar(&c.Server.WriteTimeout)},
+		{"SERVER_IDLE_TIMEOUT", "120s", "how long idle keep-alive connections stay open", durationVar(&c.Server.IdleTimeout)},
+		{"SERVER_SHUTDOWN_TIMEOUT", "20s", "time in-flight requests get to finish on shutdown", durationVar(&c.Server.ShutdownTimeout)},
+
+		{"MONGO_URI", "", "MongoDB connection string", stringVar(&c.Mongo.URI)},
+		{"MONGO_DATABASE", "signup-users", "MongoDB database name", stringVar(&c.Mongo.Database)},
+		{"MONGO_CONNECT_TIMEOUT", "10s", "time allowed to connect to MongoDB at startup", durationVar(&c.Mongo.ConnectTimeout)},
+		{"MONGO_OPERATION_TIMEOUT", "5s", "time allowed for each database operation", durationVar(&c.Mongo.OperationTimeout)},
+		{"MONGO_SYNC_INDEXES", "true", "create and update indexes at startup (otherwise run sync-indexes)", boolVar(&c.Mongo.SyncIndexes)},
+
+		{"JWT_SECRET", "", "HMAC key for access tokens and OTP hashes (at least 32 characters)", stringVar(&c.JWT.Secret)},
+		{"JWT_ACCESS_TTL", "15m", "lifetime of access tokens", durationVar(&c.JWT.AccessTTL)},
+		{"JWT_REFRESH_TTL", "720h", "lifetime of refresh tokens and sessions", durationVar(&c.JWT.RefreshTTL)},
+
+		{"GOOGLE_CLIENT_IDS", "", "comma-separated OAuth client IDs accepted as Google ID token audiences", listVar(&c.Google.ClientIDs)},
+		{"GOOGLE_JWKS_URL", "https://www.googleapis.com/oauth2/v3/certs", "where Google's token signing keys are fetched from", stringVar(&c.Google.JWKSURL)},
+
+		{"OTP_LENGTH", "4", "number of digits in emailed codes", intVar(&c.OTP.Length)},
+		{"OTP_TTL", "5m", "how long an emailed code stays valid", durationVar(&c.OTP.TTL)},
+		{"OTP_MAX_ATTEMPTS", "5", "verification tries allowed per code", intVar(&c.OTP.MaxAttempts)},
+		{"OTP_RESEND_COOLDOWN", "1m", "minimum time between codes for one email", durationVar(&c.OTP.ResendCooldown)},
+		{"OTP_EMAIL_LIMIT", "5", "codes sent per email per limit window", intVar(&c.OTP.EmailLimit)},
+		{"OTP_IP_LIMIT", "30", "OTP requests per client IP per limit window", intVar(&c.OTP.IPLimit)},
+		{"OTP_LIMIT_WINDOW", "1h", "window for the OTP email and IP limits", durationVar(&c.OTP.LimitWindow)},
+		{"RESET_TOKEN_TTL", "15m", "lifetime of the token issued after a password reset code is verified", durationVar(&c.OTP.ResetTokenTTL)},
+
+		{"MAIL_BACKEND", "", "smtp, log or capture; smtp when SMTP_HOST is set, otherwise it must be chosen", stringVar(&c.Mail.Backend)},
+		{"MAIL_FROM", "", "sender address (default: SMTP_USERNAME)", stringVar(&c.Mail.From)},
+		{"MAIL_DIR", "", "directory the log backend writes messages to (default: log them)", stringVar(&c.Mail.Dir)},
+		{"SMTP_HOST", "", "SMTP relay host", stringVar(&c.Mail.SMTP.Host)},
+		{"SMTP_PORT", "587", "SMTP relay port", intVar(&c.Mail.SMTP.Port)},
+		{"SMTP_USERNAME", "", "SMTP user", stringVar(&c.Mail.SMTP.Username)},
+		{"SMTP_PASSWORD", "", "SMTP password or app password", stringVar(&c.Mail.SMTP.Password)},
+
+		{"NEWS_PROVIDER", "newsapi", "where articles come from: newsapi or rss", choiceVar(&c.News.Provider, "newsapi", "rss")},
+		{"NEWS_FEEDS_FILE", "", "JSON file listing the RSS and Atom feeds, required with the rss provider", stringVar(&c.News.FeedsFile)},
+		{"NEWS_FEEDS_REFRESH", "10m", "how long a fetched feed is served before it is fetched again", durationVar(&c.News.FeedsRefresh)},
+		{"NEWS_FEEDS_TIMEOUT", "10s", "timeout for fetching a feed", durationVar(&c.News.FeedsTimeout)},
+
+		{"NEWS_API_KEY", "", "newsapi.org API key, required with the newsapi provider", stringVar(&c.NewsAPI.Key)},
+		{"NEWS_API_BASE_URL", "https://newsapi.org", "NewsAPI origin, e.g. a stand-in for tests", urlVar(&c.NewsAPI.BaseURL)},
+		{"NEWS_API_TIMEOUT", "10s", "timeout for NewsAPI requests", durationVar(&c.NewsAPI.Timeout)},
+
+		{"GEMINI_API_KEY", "", "Google Gemini API key", stringVar(&c.Gemini.Key)},
+		{"GEMINI_BASE_URL", "https://generativelanguage.googleapis.com", "Gemini API origin, e.g. a stand-in for tests", urlVar(&c.Gemini.BaseURL)},
+		{"GEMINI_MODEL", "gemini-pro", "Gemini model used for summaries", stringVar(&c.Gemini.Model)},
+		{"GEMINI_TIMEOUT", "30s", "timeout for Gemini requests", durationVar(&c.Gemini.Timeout)},
+
+		{"RATE_LIMIT_BACKEND", "memory", "where request budgets are kept: memory (per instance) or mongo (shared)", choiceVar(&c.RateLimit.Backend, "memory", "mongo")},
+		{"RATE_LIMIT_TRUSTED_PROXIES", "", "comma-separated proxy IPs or CIDRs whose X-Forwarded-For is trusted", prefixListVar(&c.RateLimit.TrustedProxies)},
+		{"RATE_LIMIT_AUTH", "10/1m", "sign-up, sign-in, OTP and password reset requests per client IP, as N/PERIOD[,BURST] or off", rateVar(&c.RateLimit.Auth)},
+		{"RATE_LIMIT_NEWS", "60/1m,20", "news requests per user or IP, as N/PERIOD[,BURST] or off", rateVar(&c.RateLimit.News)},
+		{"RATE_LIMIT_SUMMARY", "10/1m,5", "summary requests per user or IP, as N/PERIOD[,BURST] or off", rateVar(&c.RateLimit.Summary)},
+		{"RATE_LIMIT_API", "300/1m,100", "requests to other routes per user or IP, as N/PERIOD[,BURST] or off", rateVar(&c.RateLimit.API)},
+
+		{"CORS_ALLOWED_ORIGINS", "http://localhost:8081,http://localhost:19006", "comma-separated origins browsers may call the API from, or * for any", originListVar(&c.CORS.AllowedOrigins)},
+		{"CORS_ALLOWED_METHODS", "GET,POST", "comma-separated methods allowed in cross-origin requests", methodListVar(&c.CORS.AllowedMethods)},
+		{"CORS_ALLOWED_HEADERS", "Authorization,Content-Type,X-Request-ID", "comma-separated request headers allowed in cross-origin requests", headerListVar(&c.CORS.AllowedHeaders)},
+		{"CORS_ALLOW_CREDENTIALS", "false", "let cross-origin requests carry cookies and HTTP auth", boolVar(&c.CORS.AllowCredentials)},
+		{"CORS_MAX_AGE", "10m", "how long browsers may cache a preflight answer", durationVar(&c.CORS.MaxAge)},
+	}
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// Load reads the configuration. args are the command-line arguments without
+// the program name; whatever follows the flags is returned as rest. The
+// result still has to pass Validate (or ValidateMongo for database tools).
+func Load(args []string) (cfg *Config, rest []string, err error) {
+	cfg = &Config{}
+	binds := bindings(cfg)
+
+	fs := flag.NewFlagSet("newsly", flag.ContinueOnError)
+	file := fs.String("config", ".env", "dotenv-style settings file; optional unless set explicitly")
+	flagValues := make(map[string]*string, len(binds))
+	for _, b := range binds {
+		flagValues[b.Key] = fs.String(flagName(b.Key), "", fmt.Sprintf("%s (env %s)", b.Usage, b.Key))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	fileValues, err := godotenv.Read(*file)
+	if err != nil {
+		if explicit["config"] || !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, fmt.Errorf("reading config file %s: %w", *file, err)
+		}
+		fileValues = map[string]string{}
+	}
+
+	var problems []string
+	for _, b := range binds {
+		value := b.Default
+		if v, ok := fileValues[b.Key]; ok {
+			value = v
+		}
+		if v, ok := os.LookupEnv(b.Key); ok {
+			value = v
+		}
+		if explicit[flagName(b.Key)] {
+			value = *flagValues[b.Key]
+		}
+		if err := b.set(strings.TrimSpace(value)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", b.Key, err))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, nil, &Error{Problems: problems}
+	}
+	return cfg, fs.Args(), nil
+}
+
+// Error lists every problem found, so they can all be fixed in one go.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func missing(key, hint string) string {
+	return fmt.Sprintf("%s is required: set it in the environment, in .env or with -%s (%s)", key, flagName(key), hint)
+}
+
+// ValidateMongo checks what tools that only touch the database need.
+func (c *Config) ValidateMongo() error {
+	var problems []string
+	if c.Mongo.URI == "" {
+		problems = append(problems, missing("MONGO_URI", "e.g. mongodb://localhost:27017"))
+	}
+	if c.Mongo.Database == "" {
+		problems = append(problems, missing("MONGO_DATABASE", "the database holding accounts and news"))
+	}
+	if len(problems) > 0 {
+		return &Error{Problems: problems}
+	}
+	return nil
+}
+
+// Validate checks that every secret and setting the server needs is present
+// and sensible.
+func (c *Config) Validate() error {
+	var problems []string
+	if err := c.ValidateMongo(); err != nil {
+		problems = append(problems, err.(*Error).Problems...)
+	}
+	switch {
+	case c.JWT.Secret == "":
+		problems = append(problems, missing("JWT_SECRET", "generate one with `openssl rand -base64 48`"))
+	case len(c.JWT.Secret) < 32:
+		problems = append(problems, "JWT_SECRET must be at least 32 characters; generate one with `openssl rand -base64 48`")
+	}
+	switch {
+	case c.News.Provider == "newsapi" && c.NewsAPI.Key == "":
+		problems = append(problems, missing("NEWS_API_KEY", "get one at https://newsapi.org/register, or set NEWS_PROVIDER=rss"))
+	case c.News.Provider == "rss" && c.News.FeedsFile == "":
+		problems = append(problems, missing("NEWS_FEEDS_FILE", "required when NEWS_PROVIDER is rss; see feeds.example.json"))
+	}
+	if c.Gemini.Key == "" {
+		problems = append(problems, missing("GEMINI_API_KEY", "create one at https://aistudio.google.com/app/apikey"))
+	}
+	if c.OTP.Length < 4 || c.OTP.Length > 10 {
+		problems = append(problems, "OTP_LENGTH must be between 4 and 10")
+	}
+	// At 0 these would reject every code or every request
+	for _, setting := range []struct {
+		key string
+		n   int
+	}{
+		{"OTP_MAX_ATTEMPTS", c.OTP.MaxAttempts},
+		{"OTP_EMAIL_LIMIT", c.OTP.EmailLimit},
+		{"OTP_IP_LIMIT", c.OTP.IPLimit},
+	} {
+		if setting.n < 1 {
+			problems = append(problems, setting.key+" must be at least 1")
+		}
+	}
+	// A summary waits on the news provider and then Gemini within one
+	// response; feeds are fetched in parallel
+	newsTimeout, newsKey := c.NewsAPI.Timeout, "NEWS_API_TIMEOUT"
+	if c.News.Provider == "rss" {
+		newsTimeout, newsKey = c.News.FeedsTimeout, "NEWS_FEEDS_TIMEOUT"
+	}
+	if w := c.Server.WriteTimeout; w > 0 && w <= c.Gemini.Timeout+newsTimeout {
+		problems = append(problems, "SERVER_WRITE_TIMEOUT must be longer than GEMINI_TIMEOUT plus "+newsKey+", or summaries are cut off")
+	}
+	if c.CORS.AllowCredentials && slices.Contains(c.CORS.AllowedOrigins, "*") {
+		problems = append(problems, "CORS_ALLOWED_ORIGINS cannot be * when CORS_ALLOW_CREDENTIALS is true; list the origins")
+	}
+	if c.JWT.AccessTTL >= c.JWT.RefreshTTL {
+		problems = append(problems, "JWT_ACCESS_TTL must be shorter than JWT_REFRESH_TTL")
+	}
+	switch c.MailBackend() {
+	case "smtp":
+		if c.Mail.SMTP.Host == "" {
+			problems = append(problems, missing("SMTP_HOST", "required when MAIL_BACKEND is smtp"))
+		}
+		if c.Mail.From == "" && c.Mail.SMTP.Username == "" {
+			problems = append(problems, missing("MAIL_FROM", "or set SMTP_USERNAME to send as that user"))
+		}
+	case "log", "capture":
+	case "":
+		// Falling back to the log backend would put sign-in codes in the
+		// server logs of a deploy that forgot its relay
+		problems = append(problems, missing("MAIL_BACKEND", "smtp with SMTP_HOST to send mail, or log to print it during development"))
+	default:
+		problems = append(problems, fmt.Sprintf("MAIL_BACKEND %q is not one of smtp, log or capture", c.Mail.Backend))
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return &Error{Problems: problems}
+	}
+	return nil
+}
+
+// MailBackend resolves the default backend: SMTP when a relay is
+// configured, and "" when none was chosen.
+func (c *Config) MailBackend() string {
+	if c.Mail.Backend != "" {
+		return c.Mail.Backend
+	}
+	if c.Mail.SMTP.Host != "" {
+		return "smtp"
+	}
+	return ""
+}
+
+func stringVar(dst *string) func(string) error {
+	return func(v string) error {
+		*dst = v
+		return nil
+	}
+}
+
+func intVar(dst *int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%q is not a non-negative whole number", v)
+		}
+		*dst = n
+		return nil
+	}
+}
+
+func boolVar(dst *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", v)
+		}
+		*dst = b
+		return nil
+	}
+}
+
+func durationVar(dst *time.Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("%q is not a positive duration such as 30s or 5m", v)
+		}
+		*dst = d
+		return nil
+	}
+}
+
+// urlVar accepts an absolute http(s) URL and drops any trailing slash so
+// paths can be appended to it.
+func urlVar(dst *string) func(string) error {
+	return func(v string) error {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%q is not an absolute http or https URL", v)
+		}
+		*dst = strings.TrimRight(v, "/")
+		return nil
+	}
+}
+
+func listVar(dst *[]string) func(string) error {
+	return func(v string) error {
+		*dst = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*dst = append(*dst, item)
+			}
+		}
+		return nil
+	}
+}
+
+func levelVar(dst *slog.Level) func(string) error {
+	return func(v string) error {
+		if err := dst.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("%q is not one of debug, info, warn or error", v)
+		}
+		return nil
+	}
+}
+
+func ratioVar(dst *float64) func(string) error {
+	return func(v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return fmt.Errorf("%q is not a number from 0 to 1", v)
+		}
+		*dst = f
+		return nil
+	}
+}
+
+// prefixListVar reads comma-separated IPs and CIDRs; a bare IP becomes a
+// single-address prefix.
+func prefixListVar(dst *[]netip.Prefix) func(string) error {
+	return func(v string) error {
+		*dst = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			if addr, err := netip.ParseAddr(item); err == nil {
+				*dst = append(*dst, netip.PrefixFrom(addr, addr.BitLen()))
+				continue
+			}
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return fmt.Errorf("%q is not an IP address or CIDR", item)
+			}
+			*dst = append(*dst, prefix.Masked())
+		}
+		return nil
+	}
+}
+
+// rateVar reads "N/PERIOD" such as 60/1m, with an optional ",BURST"; the
+// burst defaults to N. "off" disables the policy.
+func rateVar(dst *RatePolicy) func(string) error {
+	return func(v string) error {
+		if strings.EqualFold(v, "off") {
+			*dst = RatePolicy{}
+			return nil
+		}
+		bad := fmt.Errorf("%q is not N/PERIOD[,BURST] such as 60/1m or 60/1m,20, or off", v)
+		rate, burst, hasBurst := strings.Cut(v, ",")
+		n, per, ok := strings.Cut(rate, "/")
+		if !ok {
+			return bad
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil || limit <= 0 {
+			return bad
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(per))
+		if err != nil || d <= 0 {
+			return bad
+		}
+		p := RatePolicy{Limit: limit, Per: d, Burst: limit}
+		if hasBurst {
+			if p.Burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil || p.Burst <= 0 {
+				return bad
+			}
+		}
+		*dst = p
+		return nil
+	}
+}
+
+func choiceVar(dst *string, choices ...string) func(string) error {
+	return func(v string) error {
+		v = strings.ToLower(v)
+		if !slices.Contains(choices, v) {
+			return fmt.Errorf("%q is not one of %s", v, strings.Join(choices, ", "))
+		}
+		*dst = v
+		return nil
+	}
+}
+
+// originListVar reads comma-separated origins, scheme and host with an
+// optional port, and lower-cases them the way browsers send them.
+func originListVar(dst *[]string) func(string) error {
+	return func(v string) error {
+		*dst = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			if item == "*" {
+				*dst = append(*dst, item)
+				continue
+			}
+			u, err := url.Parse(strings.TrimRight(item, "/"))
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+				return fmt.Errorf("%q is not an origin such as https://app.example.com", item)
+			}
+			*dst = append(*dst, strings.ToLower(u.Scheme+"://"+u.Host))
+		}
+		return nil
+	}
+}
+
+// methodListVar reads comma-separated HTTP methods in upper case.
+func methodListVar(dst *[]string) func(string) error {
+	return func(v string) error {
+		var methods []string
+		listVar(&methods)(v)
+		for i, m := range methods {
+			methods[i] = strings.ToUpper(m)
+			if strings.Trim(methods[i], "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+				return fmt.Errorf("%q is not an HTTP method", m)
+			}
+		}
+		*dst = methods
+		return nil
+	}
+}
+
+// headerListVar reads comma-separated header names in canonical form.
+func headerListVar(dst *[]string) func(string) error {
+	return func(v string) error {
+		var headers []string
+		listVar(&headers)(v)
+		for i, h := range headers {
+			if strings.ContainsAny(h, " \t:") {
+				return fmt.Errorf("%q is not a header name", h)
+			}
+			headers[i] = http.CanonicalHeaderKey(h)
+		}
+		*dst = headers
+		return nil
+	}
+}
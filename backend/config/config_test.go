@@ -0,0 +1,80 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// load runs Load with the given flags and an empty settings file, so a
+// developer's .env stays out of the test.
+func load(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "test.env")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := Load(append([]string{"-config", file}, args...))
+	return cfg, err
+}
+
+func TestLoadRejectsNonPositiveDurations(t *testing.T) {
+	for _, key := range []string{"OTP_TTL", "OTP_RESEND_COOLDOWN", "OTP_LIMIT_WINDOW", "RESET_TOKEN_TTL"} {
+		for _, v := range []string{"0s", "-1m"} {
+			_, err := load(t, "-"+flagName(key), v)
+			if err == nil || !strings.Contains(err.Error(), key+": ") || !strings.Contains(err.Error(), "positive duration") {
+				t.Errorf("%s=%s: Load() = %v", key, v, err)
+			}
+		}
+	}
+}
+
+func TestValidateOTPLimits(t *testing.T) {
+	cfg, err := load(t, "-otp-max-attempts", "0", "-otp-ip-limit", "0", "-otp-email-limit", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() accepted limits of 0")
+	}
+	for _, want := range []string{"OTP_MAX_ATTEMPTS must be at least 1", "OTP_IP_LIMIT must be at least 1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "OTP_EMAIL_LIMIT") {
+		t.Errorf("Validate() rejected OTP_EMAIL_LIMIT=1: %v", err)
+	}
+}
+
+func TestMailBackend(t *testing.T) {
+	cfg, err := load(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "MAIL_BACKEND is required") {
+		t.Fatalf("Validate() without a mail backend = %v", err)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-smtp-host", "smtp.example.com"}, "smtp"},
+		{[]string{"-mail-backend", "log"}, "log"},
+		{[]string{"-mail-backend", "capture", "-smtp-host", "smtp.example.com"}, "capture"},
+	} {
+		cfg, err := load(t, tt.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg.MailBackend(); got != tt.want {
+			t.Errorf("%v: MailBackend() = %q, want %q", tt.args, got, tt.want)
+		}
+		if err := cfg.Validate(); err != nil && strings.Contains(err.Error(), "MAIL_BACKEND") {
+			t.Errorf("%v: Validate() = %v", tt.args, err)
+		}
+	}
+}
@@ -3,6 +3,11 @@ package db
 import (
 	"context"
 	"log"
+	"log/slog"
+
+	"backend/config"
+	"backend/metrics"
+	"backend/tracing"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -11,20 +16,26 @@ import (
 var MongoClient *mongo.Client
 var MongoDatabase *mongo.Database
 
-func ConnectMongo(uri string) {
-	clientOptions := options.Client().ApplyURI(uri)
+func ConnectMongo(cfg config.MongoConfig) {
+	clientOptions := options.Client().
+		ApplyURI(cfg.URI).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetPoolMonitor(metrics.MongoPoolMonitor()).
+		SetMonitor(tracing.MongoMonitor())
 
-	client, err := mongo.Connect(context.Background(), clientOptions)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	err = client.Ping(context.Background(), nil)
+	err = client.Ping(ctx, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	MongoClient = client
-	MongoDatabase = client.Database("signup-users")
-	log.Println("Connected to MongoDB!")
+	MongoDatabase = client.Database(cfg.Database)
+	slog.Info("connected to MongoDB", "database", cfg.Database)
 }
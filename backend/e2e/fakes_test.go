@@ -0,0 +1,291 @@
+package e2e
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeNewsAPI stands in for newsapi.org. It serves the same articles from
+// /v2/top-headlines and /v2/everything, a fixed source list, and records
+// every query it got.
+type fakeNewsAPI struct {
+	*httptest.Server
+	key string
+
+	mu       sync.Mutex
+	articles []map[string]any
+	requests []*http.Request
+}
+
+func newFakeNewsAPI(t *testing.T, key string) *fakeNewsAPI {
+	f := &fakeNewsAPI{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v2/top-headlines", f.serveArticles)
+	mux.HandleFunc("GET /v2/everything", f.serveArticles)
+	mux.HandleFunc("GET /v2/top-headlines/sources", f.serveSources)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// SetArticles replaces what both endpoints return.
+func (f *fakeNewsAPI) SetArticles(articles ...map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.articles = articles
+}
+
+// Requests returns the requests received so far.
+func (f *fakeNewsAPI) Requests() []*http.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*http.Request(nil), f.requests...)
+}
+
+func (f *fakeNewsAPI) serveArticles(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r)
+	articles := f.articles
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("X-Api-Key") != f.key {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"status": "error", "code": "apiKeyInvalid"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":       "ok",
+		"totalResults": len(articles),
+		"articles":     articles,
+	})
+}
+
+func (f *fakeNewsAPI) serveSources(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r)
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+		"sources": []map[string]any{
+			{"id": "bbc-news", "name": "BBC News", "url": "https://www.bbc.co.uk/news", "category": "general", "language": "en", "country": "gb"},
+		},
+	})
+}
+
+// fakeGemini stands in for the Gemini generateContent endpoint. It answers
+// every well-formed request with a fixed summary and keeps the prompts.
+type fakeGemini struct {
+	*httptest.Server
+	key     string
+	summary string
+
+	mu      sync.Mutex
+	prompts []string
+}
+
+func newFakeGemini(t *testing.T, key, summary string) *fakeGemini {
+	f := &fakeGemini{key: key, summary: summary}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1beta/models/{model}", f.generateContent)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Prompts returns the text of every prompt received so far.
+func (f *fakeGemini) Prompts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.prompts...)
+}
+
+func (f *fakeGemini) generateContent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("x-goog-api-key") != f.key {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": 403, "status": "PERMISSION_DENIED"}})
+		return
+	}
+	var req struct {
+		Contents []struct {
+			Role  string `json:"role"`
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"contents"`
+	}
+	if !strings.HasSuffix(r.PathValue("model"), ":generateContent") || json.NewDecoder(r.Body).Decode(&req) != nil ||
+		len(req.Contents) == 0 || len(req.Contents[0].Parts) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": 400, "status": "INVALID_ARGUMENT"}})
+		return
+	}
+	f.mu.Lock()
+	f.prompts = append(f.prompts, req.Contents[0].Parts[0].Text)
+	f.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]any{
+		"candidates": []any{map[string]any{
+			"content": map[string]any{
+				"role":  "model",
+				"parts": []any{map[string]any{"text": f.summary}},
+			},
+		}},
+	})
+}
+
+// fakeFeeds serves an RSS feed at /rss and an Atom feed at /atom, each with
+// an ETag, and counts the full and not-modified answers it gave.
+type fakeFeeds struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	served      int
+	notModified int
+}
+
+const fakeRSS = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:media="http://search.yahoo.com/mrss/">
+<channel>
+  <title>Sports Desk</title>
+  <item>
+    <title>Caf` + "\xe9" + ` wins the cup</title>
+    <link>/sport/cup-final</link>
+    <description>&lt;p&gt;The final went to penalties&amp;nbsp;again.&lt;/p&gt;</description>
+    <dc:creator>Jo Reporter</dc:creator>
+    <pubDate>Mon, 06 May 2024 18:30:00 +0000</pubDate>
+    <media:content url="https://img.example.com/cup.jpg" medium="image"/>
+  </item>
+</channel>
+</rss>`
+
+const fakeAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Tech Notes</title>
+  <entry>
+    <title type="html">Go 1.25 &amp;amp; friends</title>
+    <link rel="alternate" href="https://tech.example.com/go-125"/>
+    <summary type="html">&lt;img src="https://tech.example.com/gopher.png"&gt; A release roundup.</summary>
+    <author><name>Sam Writer</name></author>
+    <published>2024-05-07T09:00:00Z</published>
+  </entry>
+</feed>`
+
+func newFakeFeeds(t *testing.T) *fakeFeeds {
+	f := &fakeFeeds{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rss", f.serve("application/rss+xml", `"rss-1"`, fakeRSS))
+	mux.HandleFunc("GET /atom", f.serve("application/atom+xml", `"atom-1"`, fakeAtom))
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Counts returns how many full and not-modified answers were given.
+func (f *fakeFeeds) Counts() (served, notModified int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.served, f.notModified
+}
+
+func (f *fakeFeeds) serve(contentType, etag, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			f.notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		f.served++
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}
+}
+
+// googleClientID is the OAuth client ID the harness accepts ID tokens for.
+const googleClientID = "app.apps.googleusercontent.com"
+
+// fakeGoogleKeys stands in for Google's JWKS endpoint, serving one RSA key,
+// and signs ID tokens with it. It counts how often the keys were fetched.
+type fakeGoogleKeys struct {
+	*httptest.Server
+	kid string
+	key *rsa.PrivateKey
+
+	mu      sync.Mutex
+	fetches int
+}
+
+func newFakeGoogleKeys(t *testing.T) *fakeGoogleKeys {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeGoogleKeys{kid: "key-1", key: key}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.fetches++
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": f.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Fetches returns how many times the keys were fetched.
+func (f *fakeGoogleKeys) Fetches() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fetches
+}
+
+// Claims returns valid ID token claims for the nth Google account.
+func (f *fakeGoogleKeys) Claims(n int) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            "https://accounts.google.com",
+		"aud":            googleClientID,
+		"sub":            fmt.Sprintf("google-%d", n),
+		"email":          fmt.Sprintf("user%d@example.com", n),
+		"email_verified": true,
+		"name":           fmt.Sprintf("user%d", n),
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// Sign returns an RS256 ID token for claims, signed by key under kid.
+func (f *fakeGoogleKeys) Sign(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
@@ -0,0 +1,869 @@
+package e2e
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/accounts"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSignupViaOTP(t *testing.T) {
+	h := newHarness(t)
+
+	h.expect(h.do("POST", "/request-otp", "", map[string]string{
+		"username": "ada", "email": "ada@example.com", "password": "correct horse",
+	}), http.StatusOK)
+	code := h.lastCode("ada@example.com")
+
+	wrong := "0000000000"[:len(code)]
+	if wrong == code {
+		wrong = "1111111111"[:len(code)]
+	}
+	res := h.expect(h.do("POST", "/verify-otp", "", map[string]string{"email": "ada@example.com", "otp": wrong}), http.StatusUnauthorized)
+	if res.ErrorCode() != "OTP_INVALID" {
+		t.Fatalf("wrong code answered %q", res.ErrorCode())
+	}
+
+	res = h.expect(h.do("POST", "/verify-otp", "", map[string]string{"email": "ada@example.com", "otp": code}), http.StatusOK)
+	token := res.String("token")
+	if token == "" || res.String("refreshToken") == "" {
+		t.Fatalf("verify-otp did not sign in: %v", res.Body)
+	}
+	if msg, _ := h.Mail.Last("ada@example.com"); !strings.Contains(strings.ToLower(msg.Subject), "welcome") {
+		t.Errorf("last mail is %q, want the welcome mail", msg.Subject)
+	}
+
+	// The code is single use and the account now exists
+	h.expect(h.do("POST", "/verify-otp", "", map[string]string{"email": "ada@example.com", "otp": code}), http.StatusNotFound)
+	res = h.expect(h.do("POST", "/request-otp", "", map[string]string{
+		"username": "someone-else", "email": "ada@example.com", "password": "pw",
+	}), http.StatusConflict)
+	if res.ErrorCode() != "EMAIL_TAKEN" {
+		t.Fatalf("second signup answered %q", res.ErrorCode())
+	}
+
+	res = h.expect(h.do("GET", "/get-user-details", token, nil), http.StatusOK)
+	if res.String("username") != "ada" || res.String("email") != "ada@example.com" {
+		t.Fatalf("user details = %v", res.Body)
+	}
+}
+
+// TestEmailCase checks that an address is one account however it is typed.
+func TestEmailCase(t *testing.T) {
+	h := newHarness(t, "-otp-email-limit", "1", "-otp-resend-cooldown", "1ns")
+	h.expect(h.do("POST", "/request-otp", "", map[string]string{
+		"username": "ada", "email": " Ada@Example.com", "password": "engine",
+	}), http.StatusOK)
+	res := h.expect(h.do("POST", "/verify-otp", "", map[string]string{
+		"email": "ADA@example.COM", "otp": h.lastCode("ada@example.com"),
+	}), http.StatusOK)
+	if res.String("email") != "ada@example.com" {
+		t.Fatalf("signed up as %q", res.String("email"))
+	}
+	token := h.signIn("Ada@example.com", "engine")
+	h.expect(h.do("GET", "/get-user-details?email=ADA@EXAMPLE.COM", token, nil), http.StatusOK)
+
+	res = h.expect(h.do("POST", "/request-otp", "", map[string]string{
+		"username": "ada2", "email": "ada@EXAMPLE.com", "password": "engine",
+	}), http.StatusConflict)
+	if res.ErrorCode() != "EMAIL_TAKEN" {
+		t.Fatalf("second signup answered %q", res.ErrorCode())
+	}
+
+	// Spellings of one address share its OTP budget
+	h.expect(h.do("POST", "/request-otp", "", map[string]string{"username": "bob", "email": "bob@example.com", "password": "pw"}), http.StatusOK)
+	h.expect(h.do("POST", "/request-otp", "", map[string]string{"username": "bob", "email": "BOB@example.com", "password": "pw"}), http.StatusTooManyRequests)
+}
+
+func TestSignInRefreshAndLogout(t *testing.T) {
+	h := newHarness(t)
+	h.signUp("grace", "grace@example.com", "hopper")
+
+	res := h.expect(h.do("POST", "/signin", "", map[string]string{"email": "grace@example.com", "password": "nope"}), http.StatusUnauthorized)
+	if res.ErrorCode() != "INVALID_CREDENTIALS" {
+		t.Fatalf("bad password answered %q", res.ErrorCode())
+	}
+	h.expect(h.do("POST", "/signin", "", map[string]string{"email": "nobody@example.com", "password": "x"}), http.StatusNotFound)
+
+	res = h.expect(h.do("POST", "/signin", "", map[string]string{"email": "grace@example.com", "password": "hopper"}), http.StatusOK)
+	refresh := res.String("refreshToken")
+
+	rotated := h.expect(h.do("POST", "/auth/refresh", "", map[string]string{"refreshToken": refresh}), http.StatusOK)
+	h.expect(h.do("GET", "/get-user-details", rotated.String("token"), nil), http.StatusOK)
+
+	// Replaying the old refresh token is treated as theft and ends the session
+	res = h.expect(h.do("POST", "/auth/refresh", "", map[string]string{"refreshToken": refresh}), http.StatusUnauthorized)
+	if res.ErrorCode() != "INVALID_REFRESH_TOKEN" {
+		t.Fatalf("replayed refresh answered %q", res.ErrorCode())
+	}
+	res = h.expect(h.do("GET", "/get-user-details", rotated.String("token"), nil), http.StatusUnauthorized)
+	if res.ErrorCode() != "SESSION_REVOKED" {
+		t.Fatalf("revoked session answered %q", res.ErrorCode())
+	}
+
+	token := h.signIn("grace@example.com", "hopper")
+	h.expect(h.do("POST", "/auth/logout", token, nil), http.StatusOK)
+	h.expect(h.do("GET", "/get-user-details", token, nil), http.StatusUnauthorized)
+	h.expect(h.do("GET", "/get-user-details", "", nil), http.StatusUnauthorized)
+}
+
+func TestPasswordReset(t *testing.T) {
+	h := newHarness(t)
+	oldToken, _ := h.signUp("linus", "linus@example.com", "old password")
+
+	h.expect(h.do("POST", "/request-password-reset-otp", "", map[string]string{"email": "linus@example.com"}), http.StatusOK)
+	res := h.expect(h.do("POST", "/verify-password-reset-otp", "", map[string]string{
+		"email": "linus@example.com", "otp": h.lastCode("linus@example.com"),
+	}), http.StatusOK)
+	resetToken := res.String("resetToken")
+	if resetToken == "" {
+		t.Fatalf("no reset token in %v", res.Body)
+	}
+
+	reset := map[string]string{"email": "linus@example.com", "resetToken": resetToken, "newPassword": "new password"}
+	h.expect(h.do("POST", "/reset-password", "", reset), http.StatusOK)
+	res = h.expect(h.do("POST", "/reset-password", "", reset), http.StatusUnauthorized)
+	if res.ErrorCode() != "RESET_TOKEN_INVALID" {
+		t.Fatalf("reused reset token answered %q", res.ErrorCode())
+	}
+
+	// Every session from before the reset is gone
+	h.expect(h.do("GET", "/get-user-details", oldToken, nil), http.StatusUnauthorized)
+	h.expect(h.do("POST", "/signin", "", map[string]string{"email": "linus@example.com", "password": "old password"}), http.StatusUnauthorized)
+	h.signIn("linus@example.com", "new password")
+
+	res = h.expect(h.do("POST", "/request-password-reset-otp", "", map[string]string{"email": "nobody@example.com"}), http.StatusNotFound)
+	if res.ErrorCode() != "USER_NOT_FOUND" {
+		t.Fatalf("unknown email answered %q", res.ErrorCode())
+	}
+}
+
+// TestChangePasswordWithProfile changes the password and profile together.
+func TestChangePasswordWithProfile(t *testing.T) {
+	h := newHarness(t)
+	oldToken, _ := h.signUp("linus", "linus@example.com", "old password")
+
+	// An access token alone is not enough to change the password
+	res := h.expect(h.do("POST", "/update-user-details", oldToken, map[string]string{
+		"bio": "Kernel hacker", "password": "new password",
+	}), http.StatusBadRequest)
+	if fieldErrors(t, res)["currentPassword"] != "required" {
+		t.Fatalf("missing current password answered %v", res.Body)
+	}
+	res = h.expect(h.do("POST", "/update-user-details", oldToken, map[string]string{
+		"bio": "Kernel hacker", "password": "new password", "currentPassword": "guess",
+	}), http.StatusUnauthorized)
+	if res.ErrorCode() != "INVALID_CREDENTIALS" {
+		t.Fatalf("wrong current password answered %q", res.ErrorCode())
+	}
+	h.signIn("linus@example.com", "old password")
+
+	res = h.expect(h.do("POST", "/update-user-details", oldToken, map[string]string{
+		"bio": "Kernel hacker", "password": "new password", "currentPassword": "old password",
+	}), http.StatusOK)
+	if res.String("token") == "" {
+		t.Fatalf("no fresh session in %v", res.Body)
+	}
+	h.expect(h.do("GET", "/get-user-details", oldToken, nil), http.StatusUnauthorized)
+	h.expect(h.do("POST", "/signin", "", map[string]string{"email": "linus@example.com", "password": "old password"}), http.StatusUnauthorized)
+	token := h.signIn("linus@example.com", "new password")
+	res = h.expect(h.do("GET", "/get-user-details?email=linus@example.com", token, nil), http.StatusOK)
+	if res.String("bio") != "Kernel hacker" {
+		t.Fatalf("details = %v", res.Body)
+	}
+}
+
+// TestRename checks that a new username reaches the caller's token and the
+// tokens every signed-in device refreshes into.
+func TestRename(t *testing.T) {
+	h := newHarness(t)
+	token, refresh := h.signUp("grace", "grace@example.com", "compiler")
+	h.signIn("grace@example.com", "compiler")
+
+	res := h.expect(h.do("POST", "/update-user-details", token, map[string]string{"username": "admiral"}), http.StatusOK)
+	if res.String("username") != "admiral" || tokenUsername(t, res.String("token")) != "admiral" {
+		t.Fatalf("rename answered %v", res.Body)
+	}
+	// The old token still works until it expires, and so does the session
+	h.expect(h.do("GET", "/get-user-details", token, nil), http.StatusOK)
+	res = h.expect(h.do("POST", "/auth/refresh", "", map[string]string{"refreshToken": refresh}), http.StatusOK)
+	if got := tokenUsername(t, res.String("token")); got != "admiral" {
+		t.Fatalf("refreshed token carries username %q", got)
+	}
+}
+
+// tokenUsername reads the username claim of an access token.
+func tokenUsername(t *testing.T, token string) string {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		t.Fatalf("parsing %q: %v", token, err)
+	}
+	username, _ := claims["username"].(string)
+	return username
+}
+
+// TestDisabledAndDeletedAccount checks how the API treats an account after
+// the repository writes newslyctl makes (cmd/newslyctl tests the commands
+// themselves): a disabled one is kept out until re-enabled, a deleted one
+// is gone along with its data.
+func TestDisabledAndDeletedAccount(t *testing.T) {
+	h := newHarness(t)
+	token, _ := h.signUp("ada", "ada@example.com", "engine")
+	h.expect(h.do("POST", "/bookmarks/add", token, map[string]any{
+		"user": "ada@example.com", "article": map[string]any{"url": "https://news.example.com/a", "title": "A"},
+	}), http.StatusOK)
+
+	ctx := context.Background()
+	if err := h.Repos.Users.SetDisabled(ctx, "ada@example.com", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Repos.Sessions.RevokeAll(ctx, "ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(h.do("GET", "/get-user-details", token, nil), http.StatusUnauthorized)
+	res := h.expect(h.do("POST", "/signin", "", map[string]string{"email": "ada@example.com", "password": "engine"}), http.StatusForbidden)
+	if res.ErrorCode() != "ACCOUNT_DISABLED" {
+		t.Fatalf("disabled account answered %q", res.ErrorCode())
+	}
+	// A wrong password does not reveal that the account is disabled
+	h.expect(h.do("POST", "/signin", "", map[string]string{"email": "ada@example.com", "password": "x"}), http.StatusUnauthorized)
+
+	if err := h.Repos.Users.SetDisabled(ctx, "ada@example.com", false); err != nil {
+		t.Fatal(err)
+	}
+	h.signIn("ada@example.com", "engine")
+
+	deleted, err := h.Repos.DeleteUser(ctx, "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Bookmarks != 1 || deleted.Sessions != 2 {
+		t.Fatalf("deleted %+v, want 1 bookmark and 2 sessions", deleted)
+	}
+	h.expect(h.do("POST", "/signin", "", map[string]string{"email": "ada@example.com", "password": "engine"}), http.StatusNotFound)
+	if _, err := h.Repos.DeleteUser(ctx, "ada@example.com"); !errors.Is(err, accounts.ErrNotFound) {
+		t.Fatalf("deleting again returned %v", err)
+	}
+}
+
+// TestGoogleSignIn checks ID tokens against a stand-in for Google's key
+// server.
+func TestGoogleSignIn(t *testing.T) {
+	keys := newFakeGoogleKeys(t)
+	h := newHarness(t, "-google-client-ids", googleClientID, "-google-jwks-url", keys.URL)
+	claims := keys.Claims
+	signIn := func(token string) response {
+		return h.do("POST", "/google-signin", "", map[string]string{"idToken": token})
+	}
+
+	// Sign-ins that arrive together share one fetch of the keys
+	tokens := make([]string, 5)
+	for i := range tokens {
+		tokens[i] = keys.Sign(t, keys.key, keys.kid, claims(i))
+	}
+	statuses := make([]int, len(tokens))
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = signIn(token).Status
+		}()
+	}
+	wg.Wait()
+	for i, status := range statuses {
+		if status != http.StatusOK {
+			t.Fatalf("sign-in %d answered %d", i, status)
+		}
+	}
+	res := h.expect(signIn(keys.Sign(t, keys.key, keys.kid, claims(0))), http.StatusOK)
+	if res.String("token") == "" || res.String("email") != "user0@example.com" {
+		t.Fatalf("sign-in answered %v", res.Body)
+	}
+
+	stranger, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(key string, value any) jwt.MapClaims {
+		c := claims(9)
+		c[key] = value
+		return c
+	}
+	for name, token := range map[string]string{
+		"bad signature":    keys.Sign(t, stranger, keys.kid, claims(9)),
+		"wrong audience":   keys.Sign(t, keys.key, keys.kid, with("aud", "someone-else.apps.googleusercontent.com")),
+		"wrong issuer":     keys.Sign(t, keys.key, keys.kid, with("iss", "https://evil.example.com")),
+		"expired":          keys.Sign(t, keys.key, keys.kid, with("exp", time.Now().Add(-time.Hour).Unix())),
+		"unknown key":      keys.Sign(t, keys.key, "key-2", claims(9)),
+		"unverified email": keys.Sign(t, keys.key, keys.kid, with("email_verified", false)),
+	} {
+		res := h.expect(signIn(token), http.StatusUnauthorized)
+		if res.ErrorCode() != "GOOGLE_TOKEN_INVALID" {
+			t.Errorf("%s answered %q", name, res.ErrorCode())
+		}
+	}
+	// An unknown key only refetches once the minimum interval has passed
+	if n := keys.Fetches(); n != 1 {
+		t.Errorf("keys fetched %d times, want 1", n)
+	}
+}
+
+// TestGoogleSignUp checks the name a Google account is created with: the
+// profile name as Google has it, or one the user picked.
+func TestGoogleSignUp(t *testing.T) {
+	keys := newFakeGoogleKeys(t)
+	h := newHarness(t, "-google-client-ids", googleClientID, "-google-jwks-url", keys.URL)
+	token := func(n int, name string) string {
+		claims := keys.Claims(n)
+		claims["name"] = name
+		return keys.Sign(t, keys.key, keys.kid, claims)
+	}
+
+	// The app sends the Google profile name back as the name
+	res := h.expect(h.do("POST", "/google-signup", "", map[string]string{"idToken": token(1, "Jane Doe"), "name": "Jane Doe"}), http.StatusOK)
+	if res.String("username") != "Jane Doe" {
+		t.Fatalf("signed up as %v", res.Body)
+	}
+	res = h.expect(h.do("POST", "/google-signup", "", map[string]string{"idToken": token(2, "Sam Lee")}), http.StatusOK)
+	if res.String("username") != "Sam Lee" {
+		t.Fatalf("signed up as %v", res.Body)
+	}
+
+	res = h.expect(h.do("POST", "/google-signup", "", map[string]string{"idToken": token(3, "Kim Park"), "name": "kim park!"}), http.StatusBadRequest)
+	if fieldErrors(t, res)["name"] != "username" {
+		t.Fatalf("a picked name with a space answered %v", res.Body)
+	}
+	h.expect(h.do("POST", "/google-signup", "", map[string]string{"idToken": token(3, "Kim Park"), "name": "kim.park"}), http.StatusOK)
+}
+
+func TestRateLimits(t *testing.T) {
+	t.Run("per IP", func(t *testing.T) {
+		h := newHarness(t, "-rate-limit-auth", "2/1m")
+		bad := map[string]string{"email": "nobody@example.com", "password": "x"}
+		h.expect(h.do("POST", "/signin", "", bad), http.StatusNotFound)
+		h.expect(h.do("POST", "/signin", "", bad), http.StatusNotFound)
+		res := h.expect(h.do("POST", "/signin", "", bad), http.StatusTooManyRequests)
+		if res.ErrorCode() != "RATE_LIMITED" {
+			t.Fatalf("limited request answered %q", res.ErrorCode())
+		}
+		if after := res.Header.Get("Retry-After"); after != "30" {
+			t.Errorf("Retry-After = %q, want 30 for one token at 2 a minute", after)
+		}
+		// Other policies have their own buckets, and probes are never limited
+		h.expect(h.do("GET", "/explore/topics", "", nil), http.StatusOK)
+		h.expect(h.do("GET", "/healthz", "", nil), http.StatusOK)
+	})
+
+	t.Run("forwarded for", func(t *testing.T) {
+		from := func(ip string) http.Header { return http.Header{"X-Forwarded-For": {ip + ", 127.0.0.1"}} }
+		bad := map[string]string{"email": "nobody@example.com", "password": "x"}
+
+		// Without trusted proxies the header is ignored
+		h := newHarness(t, "-rate-limit-auth", "1/1m")
+		h.expect(h.doWithHeader("POST", "/signin", "", bad, from("203.0.113.1")), http.StatusNotFound)
+		h.expect(h.doWithHeader("POST", "/signin", "", bad, from("203.0.113.2")), http.StatusTooManyRequests)
+
+		// Behind a trusted proxy each forwarded client has its own budget
+		h = newHarness(t, "-rate-limit-auth", "1/1m", "-rate-limit-trusted-proxies", "127.0.0.0/8, ::1")
+		h.expect(h.doWithHeader("POST", "/signin", "", bad, from("203.0.113.1")), http.StatusNotFound)
+		h.expect(h.doWithHeader("POST", "/signin", "", bad, from("203.0.113.2")), http.StatusNotFound)
+		h.expect(h.doWithHeader("POST", "/signin", "", bad, from("203.0.113.1")), http.StatusTooManyRequests)
+	})
+
+	t.Run("per user", func(t *testing.T) {
+		h := newHarness(t, "-rate-limit-news", "1/1m")
+		h.NewsAPI.SetArticles(article(1, time.Hour))
+		alice, _ := h.signUp("alice", "alice@example.com", "secret")
+		bob, _ := h.signUp("bob", "bob@example.com", "secret")
+
+		h.expect(h.do("GET", "/news", "", nil), http.StatusOK)
+		h.expect(h.do("GET", "/news", "", nil), http.StatusTooManyRequests)
+		// Signed-in users are counted apart from their address
+		h.expect(h.do("GET", "/news", alice, nil), http.StatusOK)
+		h.expect(h.do("GET", "/news", bob, nil), http.StatusOK)
+		h.expect(h.do("GET", "/news", alice, nil), http.StatusTooManyRequests)
+	})
+}
+
+func TestOTPProtections(t *testing.T) {
+	request := func(h *harness, email string) response {
+		return h.do("POST", "/request-otp", "", map[string]string{"username": strings.Split(email, "@")[0], "email": email, "password": "pw"})
+	}
+	retryAfter := func(t *testing.T, res response, max int) {
+		t.Helper()
+		if res.ErrorCode() != "RATE_LIMITED" {
+			t.Fatalf("limited request answered %q", res.ErrorCode())
+		}
+		if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err != nil || secs < 1 || secs > max {
+			t.Fatalf("Retry-After = %q, want 1 to %d seconds", res.Header.Get("Retry-After"), max)
+		}
+	}
+
+	t.Run("code length and attempts", func(t *testing.T) {
+		h := newHarness(t, "-otp-length", "6", "-otp-max-attempts", "3")
+		h.expect(request(h, "ada@example.com"), http.StatusOK)
+		code := h.lastCode("ada@example.com")
+		if len(code) != 6 {
+			t.Fatalf("code %q is not 6 digits", code)
+		}
+		wrong := fmt.Sprintf("%06d", (mustAtoi(t, code)+1)%1000000)
+		verify := func(otp string) response {
+			return h.do("POST", "/verify-otp", "", map[string]string{"email": "ada@example.com", "otp": otp})
+		}
+		h.expect(verify(wrong), http.StatusUnauthorized)
+		h.expect(verify(wrong), http.StatusUnauthorized)
+		res := h.expect(verify(wrong), http.StatusTooManyRequests)
+		if res.ErrorCode() != "OTP_TOO_MANY_ATTEMPTS" {
+			t.Fatalf("last attempt answered %q", res.ErrorCode())
+		}
+		// The code is gone, so guessing right afterwards is no use
+		h.expect(verify(code), http.StatusNotFound)
+	})
+
+	t.Run("resend cooldown", func(t *testing.T) {
+		h := newHarness(t)
+		h.expect(request(h, "ada@example.com"), http.StatusOK)
+		retryAfter(t, h.expect(request(h, "ada@example.com"), http.StatusTooManyRequests), 60)
+
+		h.signUp("grace", "grace@example.com", "hopper")
+		reset := map[string]string{"email": "grace@example.com"}
+		h.expect(h.do("POST", "/request-password-reset-otp", "", reset), http.StatusOK)
+		retryAfter(t, h.expect(h.do("POST", "/request-password-reset-otp", "", reset), http.StatusTooManyRequests), 60)
+	})
+
+	t.Run("per email", func(t *testing.T) {
+		h := newHarness(t, "-otp-resend-cooldown", "1ns", "-otp-email-limit", "2")
+		h.expect(request(h, "ada@example.com"), http.StatusOK)
+		h.expect(request(h, "ada@example.com"), http.StatusOK)
+		retryAfter(t, h.expect(request(h, "ada@example.com"), http.StatusTooManyRequests), 3600)
+		h.expect(request(h, "grace@example.com"), http.StatusOK)
+	})
+
+	t.Run("per IP", func(t *testing.T) {
+		h := newHarness(t, "-otp-ip-limit", "2")
+		h.expect(request(h, "ada@example.com"), http.StatusOK)
+		h.expect(request(h, "grace@example.com"), http.StatusOK)
+		retryAfter(t, h.expect(request(h, "linus@example.com"), http.StatusTooManyRequests), 3600)
+	})
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	t.Helper()
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// article builds a NewsAPI article published age ago.
+func article(n int, age time.Duration) map[string]any {
+	return map[string]any{
+		"source":      map[string]any{"id": nil, "name": fmt.Sprintf("Source %d", n)},
+		"title":       fmt.Sprintf("Headline %d", n),
+		"description": fmt.Sprintf("Description of story %d", n),
+		"url":         fmt.Sprintf("https://news.example.com/story-%d", n),
+		"urlToImage":  fmt.Sprintf("https://news.example.com/story-%d.jpg", n),
+		"publishedAt": time.Now().Add(-age).UTC().Format(time.RFC3339),
+		"content":     fmt.Sprintf("Full text of story %d", n),
+	}
+}
+
+func TestFetchNews(t *testing.T) {
+	h := newHarness(t)
+	var articles []map[string]any
+	for i := 1; i <= 7; i++ {
+		articles = append(articles, article(i, 2*time.Hour))
+	}
+	noImage := article(8, time.Minute)
+	delete(noImage, "urlToImage")
+	h.NewsAPI.SetArticles(append(articles, noImage)...)
+
+	res := h.expect(h.do("GET", "/news?country=gb&category=technology", "", nil), http.StatusOK)
+	if got := len(res.List("trending")); got != 5 {
+		t.Errorf("got %d trending articles, want 5", got)
+	}
+	if got := len(res.List("latest")); got != 2 {
+		t.Errorf("got %d latest articles, want 2 (the one without an image is dropped)", got)
+	}
+	first, _ := res.List("trending")[0].(map[string]any)
+	if first["title"] != "Headline 1" || first["country"] != "gb" || first["publishedAgo"] != "2h ago" {
+		t.Errorf("first trending article = %v", first)
+	}
+
+	res = h.expect(h.do("GET", "/news?type=everything&q=golang&language=en", "", nil), http.StatusOK)
+	if got := len(res.List("articles")); got != 8 {
+		t.Errorf("everything returned %d articles, want 8", got)
+	}
+
+	requests := h.NewsAPI.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("NewsAPI got %d requests, want 2", len(requests))
+	}
+	headlines, everything := requests[0].URL.Query(), requests[1].URL.Query()
+	if requests[0].URL.Path != "/v2/top-headlines" || headlines.Get("country") != "gb" || headlines.Get("category") != "technology" {
+		t.Errorf("top-headlines request was %s", requests[0].URL)
+	}
+	if requests[1].URL.Path != "/v2/everything" || everything.Get("q") != "golang" || everything.Get("language") != "en" {
+		t.Errorf("everything request was %s", requests[1].URL)
+	}
+	for _, r := range requests {
+		if r.URL.Query().Has("apiKey") {
+			t.Errorf("API key leaked into %s", r.URL)
+		}
+	}
+
+	res = h.expect(h.do("GET", "/news/article?url="+url.QueryEscape("https://news.example.com/story-3"), "", nil), http.StatusOK)
+	if res.String("title") != "Headline 3" {
+		t.Errorf("article lookup = %v", res.Body)
+	}
+	res = h.expect(h.do("GET", "/news/article?url="+url.QueryEscape("https://news.example.com/missing"), "", nil), http.StatusNotFound)
+	if res.ErrorCode() != "ARTICLE_NOT_FOUND" {
+		t.Errorf("missing article answered %q", res.ErrorCode())
+	}
+}
+
+// TestNewsQueries checks that what clients search for reaches NewsAPI
+// intact, however it is spelled.
+func TestNewsQueries(t *testing.T) {
+	h := newHarness(t)
+	h.NewsAPI.SetArticles(article(1, time.Hour))
+
+	q := "go & rust=fast #1"
+	h.expect(h.do("GET", "/news?type=everything&q="+url.QueryEscape(q)+"&domains=a.example.com,b.example.com&from=2024-05-01", "", nil), http.StatusOK)
+	res := h.expect(h.do("GET", "/news/sources?country=gb", "", nil), http.StatusOK)
+	source, _ := res.List("sources")[0].(map[string]any)
+	if source["id"] != "bbc-news" || source["country"] != "gb" {
+		t.Errorf("sources = %v", res.Body)
+	}
+
+	requests := h.NewsAPI.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("NewsAPI got %d requests, want 2", len(requests))
+	}
+	everything := requests[0].URL.Query()
+	if everything.Get("q") != q || everything.Get("domains") != "a.example.com,b.example.com" || everything.Get("from") != "2024-05-01T00:00:00" {
+		t.Errorf("everything request was %s", requests[0].URL)
+	}
+	if requests[1].URL.Path != "/v2/top-headlines/sources" || requests[1].URL.Query().Get("country") != "gb" {
+		t.Errorf("sources request was %s", requests[1].URL)
+	}
+
+	res = h.expect(h.do("GET", "/news?type=everything&from=yesterday", "", nil), http.StatusBadRequest)
+	if got := fieldErrors(t, res); got["from"] != "date" {
+		t.Errorf("bad date answered %v", got)
+	}
+}
+
+func TestNewsUpstreamFailure(t *testing.T) {
+	h := newHarness(t, "-news-api-key", "revoked-key")
+	res := h.expect(h.do("GET", "/news", "", nil), http.StatusBadGateway)
+	if res.ErrorCode() != "UPSTREAM_ERROR" {
+		t.Fatalf("upstream failure answered %q", res.ErrorCode())
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	h := newHarness(t)
+	h.NewsAPI.SetArticles(article(1, time.Hour))
+
+	res := h.expect(h.do("POST", "/news/summary", "", map[string]string{"content": "Some article text."}), http.StatusOK)
+	if res.String("summary") != fakeSummary {
+		t.Fatalf("summary = %q", res.String("summary"))
+	}
+
+	// Given only a URL, the article is looked up on NewsAPI first
+	res = h.expect(h.do("POST", "/news/summary", "", map[string]string{"url": "https://news.example.com/story-1"}), http.StatusOK)
+	if res.String("summary") != fakeSummary {
+		t.Fatalf("summary = %q", res.String("summary"))
+	}
+	prompts := h.Gemini.Prompts()
+	if len(prompts) != 2 || !strings.Contains(prompts[0], "Some article text.") || !strings.Contains(prompts[1], "Description of story 1") {
+		t.Fatalf("Gemini prompts = %q", prompts)
+	}
+
+	res = h.expect(h.do("POST", "/news/summary", "", map[string]string{"url": "https://news.example.com/unknown"}), http.StatusNotFound)
+	if res.ErrorCode() != "ARTICLE_NOT_FOUND" {
+		t.Fatalf("unknown article answered %q", res.ErrorCode())
+	}
+	h.expect(h.do("POST", "/news/summary", "", map[string]string{}), http.StatusBadRequest)
+}
+
+func TestBookmarks(t *testing.T) {
+	h := newHarness(t)
+	token, _ := h.signUp("tim", "tim@example.com", "pw")
+	other, _ := h.signUp("other", "other@example.com", "pw")
+	story := article(1, time.Hour)
+
+	add := map[string]any{"article": story}
+	res := h.expect(h.do("POST", "/bookmarks/add", token, add), http.StatusOK)
+	if res.String("message") != "Bookmark added" {
+		t.Fatalf("add = %v", res.Body)
+	}
+	res = h.expect(h.do("POST", "/bookmarks/add", token, add), http.StatusOK)
+	if res.String("message") != "Already bookmarked" {
+		t.Fatalf("second add = %v", res.Body)
+	}
+	h.expect(h.do("POST", "/bookmarks/add", token, map[string]any{"article": article(2, time.Hour)}), http.StatusOK)
+
+	res = h.expect(h.do("GET", "/bookmarks/list", token, nil), http.StatusOK)
+	if got := len(res.List("bookmarks")); got != 2 {
+		t.Fatalf("got %d bookmarks, want 2", got)
+	}
+	res = h.expect(h.do("GET", "/bookmarks/list", other, nil), http.StatusOK)
+	if got := len(res.List("bookmarks")); got != 0 {
+		t.Fatalf("another user sees %d bookmarks", got)
+	}
+	// A client may not act on someone else's bookmarks
+	h.expect(h.do("GET", "/bookmarks/list?user=tim@example.com", other, nil), http.StatusForbidden)
+
+	remove := map[string]string{"articleId": story["url"].(string)}
+	h.expect(h.do("POST", "/bookmarks/remove", token, remove), http.StatusOK)
+	res = h.expect(h.do("POST", "/bookmarks/remove", token, remove), http.StatusNotFound)
+	if res.ErrorCode() != "NOT_FOUND" {
+		t.Fatalf("second remove answered %q", res.ErrorCode())
+	}
+	res = h.expect(h.do("GET", "/bookmarks/list", token, nil), http.StatusOK)
+	if got := len(res.List("bookmarks")); got != 1 {
+		t.Fatalf("got %d bookmarks after removing one, want 1", got)
+	}
+
+	h.expect(h.do("POST", "/bookmarks/add", "", add), http.StatusUnauthorized)
+}
+
+// fieldErrors returns the rule each field failed in a VALIDATION_FAILED
+// answer.
+func fieldErrors(t *testing.T, res response) map[string]string {
+	t.Helper()
+	if res.ErrorCode() != "VALIDATION_FAILED" {
+		t.Fatalf("got %q, want VALIDATION_FAILED", res.ErrorCode())
+	}
+	e, _ := res.Body["error"].(map[string]any)
+	details, _ := e["details"].(map[string]any)
+	fields, _ := details["fields"].([]any)
+	rules := make(map[string]string)
+	for _, f := range fields {
+		f, _ := f.(map[string]any)
+		field, _ := f["field"].(string)
+		rules[field], _ = f["rule"].(string)
+	}
+	return rules
+}
+
+func TestValidation(t *testing.T) {
+	h := newHarness(t)
+
+	// Every bad field is reported at once
+	res := h.expect(h.do("POST", "/request-otp", "", map[string]string{
+		"username": "a b", "email": "not-an-email",
+	}), http.StatusBadRequest)
+	got := fieldErrors(t, res)
+	want := map[string]string{"username": "username", "email": "email", "password": "required"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("fields %v, want %v", got, want)
+	}
+
+	res = h.expect(h.do("POST", "/signin", "", map[string]any{"email": "ada@example.com", "password": "x", "remember": true}), http.StatusBadRequest)
+	if got := fieldErrors(t, res); got["remember"] != "unknown" {
+		t.Fatalf("unknown field answered %v", got)
+	}
+	res = h.expect(h.do("POST", "/signin", "", map[string]any{"email": "ada@example.com", "password": 42}), http.StatusBadRequest)
+	if got := fieldErrors(t, res); got["password"] != "type" {
+		t.Fatalf("wrong type answered %v", got)
+	}
+
+	token, _ := h.signUp("ada", "ada@example.com", "engine")
+	res = h.expect(h.do("POST", "/update-user-details", token, map[string]any{
+		"website":    "javascript:alert(1)",
+		"avatar":     "https://cdn.example.com/ada.png",
+		"country":    "Atlantis",
+		"categories": []string{"Science", "Gossip"},
+		"bio":        strings.Repeat("x", 501),
+	}), http.StatusBadRequest)
+	got = fieldErrors(t, res)
+	want = map[string]string{"website": "url", "country": "country", "categories[1]": "oneOf", "bio": "maxLen"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("fields %v, want %v", got, want)
+	}
+	h.expect(h.do("POST", "/update-user-details", token, map[string]any{
+		"website": "https://ada.example.com", "country": "gb", "categories": []string{"science", "Art"},
+	}), http.StatusOK)
+
+	res = h.expect(h.do("GET", "/news?country=Narnia&category=sports", "", nil), http.StatusBadRequest)
+	if got := fieldErrors(t, res); got["country"] != "country" {
+		t.Fatalf("bad country answered %v", got)
+	}
+
+	// Saved articles are identified by their url, so it cannot be left out
+	for _, path := range []string{"/bookmarks/add", "/viewed-news/add"} {
+		res = h.expect(h.do("POST", path, token, map[string]any{
+			"user": "ada@example.com", "article": map[string]any{"title": "No link"},
+		}), http.StatusBadRequest)
+		if got := fieldErrors(t, res); got["article.url"] != "required" {
+			t.Fatalf("%s without a url answered %v", path, got)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h := newHarness(t, "-cors-allowed-origins", "https://app.example.com")
+	preflight := func(origin, method, headers string) response {
+		return h.doWithHeader("OPTIONS", "/bookmarks/add", "", nil, http.Header{
+			"Origin":                         {origin},
+			"Access-Control-Request-Method":  {method},
+			"Access-Control-Request-Headers": {headers},
+		})
+	}
+
+	res := h.expect(preflight("https://app.example.com", "POST", "content-type,authorization"), http.StatusNoContent)
+	if got := res.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Fatalf("preflight allowed origin %q", got)
+	}
+	if got := res.Header.Get("Access-Control-Max-Age"); got != "600" {
+		t.Fatalf("preflight max age %q", got)
+	}
+	h.expect(preflight("https://evil.example.com", "POST", "content-type"), http.StatusForbidden)
+	h.expect(preflight("https://app.example.com", "DELETE", ""), http.StatusForbidden)
+	h.expect(preflight("https://app.example.com", "POST", "x-debug"), http.StatusForbidden)
+
+	// The policy covers every route, not just the one that used to set it
+	res = h.expect(h.doWithHeader("POST", "/news/summary", "", map[string]string{"content": "Text."}, http.Header{
+		"Origin": {"https://app.example.com"},
+	}), http.StatusOK)
+	if got := res.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Fatalf("summary allowed origin %q", got)
+	}
+	res = h.expect(h.doWithHeader("GET", "/explore/topics", "", nil, http.Header{"Origin": {"https://evil.example.com"}}), http.StatusForbidden)
+	if res.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("disallowed origin got an Access-Control-Allow-Origin header")
+	}
+	// Clients that are not browsers send no Origin and are unaffected
+	h.expect(h.do("GET", "/explore/topics", "", nil), http.StatusOK)
+}
+
+// TestFeedProvider serves news from RSS and Atom feeds instead of NewsAPI.
+func TestFeedProvider(t *testing.T) {
+	feeds := newFakeFeeds(t)
+	file := filepath.Join(t.TempDir(), "feeds.json")
+	list := `[
+		{"url": "` + feeds.URL + `/rss", "category": "sports", "country": "US", "language": "en"},
+		{"url": "` + feeds.URL + `/atom", "category": "technology", "language": "en", "source": "Tech Notes Daily"}
+	]`
+	if err := os.WriteFile(file, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Refetching on every request exercises the conditional GETs
+	h := newHarness(t, "-news-provider", "rss", "-news-feeds-file", file, "-news-feeds-refresh", "1ns")
+
+	res := h.expect(h.do("GET", "/news", "", nil), http.StatusOK)
+	trending := res.List("trending")
+	if len(trending) != 1 {
+		t.Fatalf("trending = %v", res.Body)
+	}
+	item, _ := trending[0].(map[string]any)
+	if item["title"] != "Café wins the cup" || item["newsCompany"] != "Sports Desk" || item["image"] != "https://img.example.com/cup.jpg" {
+		t.Errorf("trending item = %v", item)
+	}
+
+	res = h.expect(h.do("GET", "/news?type=everything&q=release", "", nil), http.StatusOK)
+	articles := res.List("articles")
+	if len(articles) != 1 {
+		t.Fatalf("articles = %v", res.Body)
+	}
+	a, _ := articles[0].(map[string]any)
+	source, _ := a["source"].(map[string]any)
+	if a["title"] != "Go 1.25 & friends" || a["author"] != "Sam Writer" || a["url"] != "https://tech.example.com/go-125" ||
+		a["urlToImage"] != "https://tech.example.com/gopher.png" || a["publishedAt"] != "2024-05-07T09:00:00Z" ||
+		source["id"] != "tech-notes-daily" {
+		t.Errorf("article = %v", a)
+	}
+	// The RSS feed was fetched again for the search and had not changed
+	if served, notModified := feeds.Counts(); served != 2 || notModified != 1 {
+		t.Errorf("feeds served %d and answered 304 %d times, want 2 and 1", served, notModified)
+	}
+
+	res = h.expect(h.do("GET", "/news/sources?category=sports", "", nil), http.StatusOK)
+	s, _ := res.List("sources")[0].(map[string]any)
+	if s["id"] != "sports-desk" || s["country"] != "us" {
+		t.Errorf("sources = %v", res.Body)
+	}
+
+	h.expect(h.do("POST", "/news/summary", "", map[string]string{"url": "https://tech.example.com/go-125"}), http.StatusOK)
+	if prompts := h.Gemini.Prompts(); len(prompts) != 1 || !strings.Contains(prompts[0], "A release roundup.") {
+		t.Fatalf("Gemini prompts = %q", prompts)
+	}
+}
+
+// TestTracing checks that the server joins the caller's trace and carries
+// it on to NewsAPI, without changing what the request returns.
+func TestTracing(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	h := newHarness(t)
+	h.NewsAPI.SetArticles(article(1, time.Hour))
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	res := h.expect(h.doWithHeader("GET", "/news", "", nil, http.Header{
+		"Traceparent": {"00-" + traceID + "-" + parentID + "-01"},
+	}), http.StatusOK)
+	if len(res.List("trending")) != 1 {
+		t.Fatalf("traced request answered %v", res.Body)
+	}
+
+	var server, client sdktrace.ReadOnlySpan
+	for _, s := range spans.Ended() {
+		switch s.Name() {
+		case "GET /news":
+			server = s
+		case "newsapi GET /v2/top-headlines":
+			client = s
+		}
+	}
+	if server == nil || client == nil {
+		t.Fatalf("no server or NewsAPI span among %d spans", len(spans.Ended()))
+	}
+	if server.SpanContext().TraceID().String() != traceID || server.Parent().SpanID().String() != parentID {
+		t.Errorf("server span is not part of the caller's trace: %v, parent %v", server.SpanContext(), server.Parent())
+	}
+	if client.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("NewsAPI span's parent is %v, want the server span", client.Parent().SpanID())
+	}
+	sent := h.NewsAPI.Requests()[0].Header.Get("Traceparent")
+	if !strings.HasPrefix(sent, "00-"+traceID+"-"+client.SpanContext().SpanID().String()) {
+		t.Errorf("NewsAPI got traceparent %q", sent)
+	}
+
+	// Requests without a traceparent start their own trace
+	h.expect(h.do("GET", "/healthz", "", nil), http.StatusOK)
+	last := spans.Ended()[len(spans.Ended())-1]
+	if last.Name() != "GET /healthz" || last.Parent().IsValid() || last.SpanContext().TraceID().String() == traceID {
+		t.Errorf("untraced request produced span %q in trace %v", last.Name(), last.SpanContext().TraceID())
+	}
+}
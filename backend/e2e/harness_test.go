@@ -0,0 +1,224 @@
+// Package e2e drives the complete HTTP API the way the app does: the real
+// router and handlers run against in-memory repositories and OTP store,
+// with httptest stand-ins for NewsAPI and Gemini and a capture mailer in
+// place of SMTP.
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"backend/config"
+	"backend/handlers"
+	"backend/logging"
+	"backend/mailer"
+	"backend/news"
+	"backend/otp"
+	"backend/ratelimit"
+	"backend/repository"
+)
+
+const (
+	newsAPIKey  = "test-news-key"
+	geminiKey   = "test-gemini-key"
+	fakeSummary = "A short summary of the article."
+)
+
+func TestMain(m *testing.M) {
+	// Request logs are noise here; E2E_LOG=debug brings them back
+	level := slog.LevelError + 1
+	if v := os.Getenv("E2E_LOG"); v != "" {
+		level.UnmarshalText([]byte(v))
+	}
+	slog.SetDefault(logging.New(os.Stderr, level, "text"))
+	os.Exit(m.Run())
+}
+
+// harness is one isolated backend with its fakes.
+type harness struct {
+	t       *testing.T
+	Server  *httptest.Server
+	Config  *config.Config
+	Repos   *repository.Repositories
+	OTPs    *otp.MemoryStore
+	Mail    *mailer.CaptureMailer
+	NewsAPI *fakeNewsAPI
+	Gemini  *fakeGemini
+}
+
+// newHarness starts a backend. Extra args are config flags such as
+// "-otp-length", "8" and override the harness defaults.
+func newHarness(t *testing.T, args ...string) *harness {
+	t.Helper()
+	h := &harness{
+		t:       t,
+		Repos:   repository.NewMemory(),
+		OTPs:    otp.NewMemoryStore(),
+		Mail:    mailer.NewCaptureMailer(),
+		NewsAPI: newFakeNewsAPI(t, newsAPIKey),
+		Gemini:  newFakeGemini(t, geminiKey, fakeSummary),
+	}
+
+	// An empty settings file keeps a developer's .env out of the tests
+	file := filepath.Join(t.TempDir(), "e2e.env")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := config.Load(append([]string{
+		"-config", file,
+		"-jwt-secret", "e2e-secret-that-is-at-least-32-bytes",
+		"-mongo-uri", "mongodb://unused",
+		"-news-api-key", newsAPIKey,
+		"-news-api-base-url", h.NewsAPI.URL,
+		"-gemini-api-key", geminiKey,
+		"-gemini-base-url", h.Gemini.URL,
+		"-mail-backend", "capture",
+		// Flows sign in many times from one address; TestRateLimits
+		// covers the limits
+		"-rate-limit-auth", "off",
+	}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	h.Config = cfg
+
+	provider, err := news.FromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Server = httptest.NewServer(handlers.NewRouter(handlers.New(cfg, h.Repos, h.OTPs, ratelimit.NewMemoryStore(), h.Mail, provider)))
+	t.Cleanup(h.Server.Close)
+	return h
+}
+
+// response is a decoded API answer.
+type response struct {
+	Status int
+	Header http.Header
+	Body   map[string]any
+}
+
+// ErrorCode returns the envelope's error code, or "" for a success.
+func (r response) ErrorCode() string {
+	e, _ := r.Body["error"].(map[string]any)
+	code, _ := e["code"].(string)
+	return code
+}
+
+// String returns a top-level string field of the body.
+func (r response) String(key string) string {
+	s, _ := r.Body[key].(string)
+	return s
+}
+
+// List returns a top-level array field of the body.
+func (r response) List(key string) []any {
+	l, _ := r.Body[key].([]any)
+	return l
+}
+
+// do sends a request with an optional bearer token and JSON body.
+func (h *harness) do(method, path, token string, body any) response {
+	h.t.Helper()
+	return h.doWithHeader(method, path, token, body, nil)
+}
+
+// doWithHeader is do with extra request headers.
+func (h *harness) doWithHeader(method, path, token string, body any, header http.Header) response {
+	h.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, h.Server.URL+path, reader)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := h.Server.Client().Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	out := response{Status: res.StatusCode, Header: res.Header}
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if len(raw) > 0 && json.Unmarshal(raw, &out.Body) != nil {
+		out.Body = map[string]any{"raw": string(raw)}
+	}
+	return out
+}
+
+// expect fails the test unless the response has the given status.
+func (h *harness) expect(res response, status int) response {
+	h.t.Helper()
+	if res.Status != status {
+		h.t.Fatalf("got status %d, want %d; body %v", res.Status, status, res.Body)
+	}
+	return res
+}
+
+var otpPattern = regexp.MustCompile(`\b\d{4,10}\b`)
+
+// lastCode pulls the one-time code out of the last mail sent to email.
+func (h *harness) lastCode(email string) string {
+	h.t.Helper()
+	msg, ok := h.Mail.Last(email)
+	if !ok {
+		h.t.Fatalf("no mail sent to %s", email)
+	}
+	for _, code := range otpPattern.FindAllString(msg.Text, -1) {
+		if len(code) == h.Config.OTP.Length {
+			return code
+		}
+	}
+	h.t.Fatalf("no code in mail %q: %s", msg.Subject, msg.Text)
+	return ""
+}
+
+// signUp registers an account through the OTP flow and returns the
+// access and refresh tokens it was signed in with.
+func (h *harness) signUp(username, email, password string) (string, string) {
+	h.t.Helper()
+	h.expect(h.do("POST", "/request-otp", "", map[string]string{
+		"username": username, "email": email, "password": password,
+	}), http.StatusOK)
+	res := h.expect(h.do("POST", "/verify-otp", "", map[string]string{
+		"email": email, "otp": h.lastCode(email),
+	}), http.StatusOK)
+	return res.String("token"), res.String("refreshToken")
+}
+
+// signIn signs in with a password and returns the access token.
+func (h *harness) signIn(email, password string) string {
+	h.t.Helper()
+	res := h.expect(h.do("POST", "/signin", "", map[string]string{
+		"email": email, "password": password,
+	}), http.StatusOK)
+	return res.String("token")
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"backend/accounts"
+	"backend/apierr"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AuthUser is the identity carried by a validated access token.
+type AuthUser struct {
+	Email     string
+	Username  string
+	SessionID string
+}
+
+type authUserKey struct{}
+
+// UserFromContext returns the identity stored by RequireAuth.
+func UserFromContext(ctx context.Context) (AuthUser, bool) {
+	user, ok := ctx.Value(authUserKey{}).(AuthUser)
+	return user, ok
+}
+
+// parseJWT validates a token minted by generateJWT and returns its identity.
+func (h *Handlers) parseJWT(tokenString string) (AuthUser, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return h.jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return AuthUser{}, err
+	}
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return AuthUser{}, errors.New("token has no email claim")
+	}
+	sessionID, _ := claims["sid"].(string)
+	if sessionID == "" {
+		return AuthUser{}, errors.New("token has no session claim")
+	}
+	username, _ := claims["username"].(string)
+	return AuthUser{Email: email, Username: username, SessionID: sessionID}, nil
+}
+
+// RequireAuth rejects requests without a valid bearer token or whose session
+// has been revoked, and stores the authenticated identity in the request
+// context for the wrapped handler.
+func (h *Handlers) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		tokenString, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || strings.TrimSpace(tokenString) == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="newsly"`)
+			apierr.Write(w, http.StatusUnauthorized, apierr.Unauthenticated, "Missing bearer token")
+			return
+		}
+		user, err := h.parseJWT(strings.TrimSpace(tokenString))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="newsly", error="invalid_token"`)
+			apierr.Write(w, http.StatusUnauthorized, apierr.InvalidToken, "Invalid or expired token")
+			return
+		}
+		ctx, cancel := h.dbContext(r)
+		active, err := h.repos.Sessions.Active(ctx, user.SessionID, user.Email)
+		cancel()
+		if err != nil {
+			apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to verify session")
+			return
+		}
+		if !active {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="newsly", error="invalid_token"`)
+			apierr.Write(w, http.StatusUnauthorized, apierr.SessionRevoked, "Session has been revoked")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authUserKey{}, user)))
+	})
+}
+
+// authorizedEmail resolves the email a user-scoped request acts on. The
+// client may still send its own email for compatibility, but it must match
+// the authenticated identity.
+func authorizedEmail(w http.ResponseWriter, r *http.Request, claimed string) (string, bool) {
+	user, ok := UserFromContext(r.Context())
+	if !ok {
+		apierr.Write(w, http.StatusUnauthorized, apierr.Unauthenticated, "Authentication required")
+		return "", false
+	}
+	if claimed != "" && accounts.NormalizeEmail(claimed) != user.Email {
+		apierr.Write(w, http.StatusForbidden, apierr.Forbidden, "You may only access your own data")
+		return "", false
+	}
+	return user.Email, true
+}
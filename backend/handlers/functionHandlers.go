@@ -1,76 +1,33 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
-	"backend/db"
-
-	"math/rand"
-	"sync"
+	"backend/accounts"
+	"backend/apierr"
+	"backend/logging"
+	"backend/mailer"
+	"backend/metrics"
+	"backend/news"
+	"backend/otp"
+	"backend/repository"
+	"backend/validate"
 
 	"github.com/golang-jwt/jwt/v5"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
-	gopkgmail "gopkg.in/gomail.v2"
 )
 
-// In-memory OTP store: email -> {otp, timestamp, username, password}
-var otpStore = struct {
-	sync.RWMutex
-	m map[string]struct {
-		OTP       string
-		CreatedAt time.Time
-		Username  string
-		Password  string
-	}
-}{m: make(map[string]struct {
-	OTP       string
-	CreatedAt time.Time
-	Username  string
-	Password  string
-})}
-
-// In-memory OTP store for password reset: email -> {otp, timestamp}
-var resetOtpStore = struct {
-	sync.RWMutex
-	m map[string]struct {
-		OTP       string
-		CreatedAt time.Time
-	}
-}{m: make(map[string]struct {
-	OTP       string
-	CreatedAt time.Time
-})}
-
-var jwtSecret = []byte("your_secret_key") // Use env var in production
-
-func generateOTP() string {
-	return fmt.Sprintf("%04d", rand.Intn(10000))
-}
-
-func sendOTPEmail(to, otp string) error {
-	m := gopkgmail.NewMessage()
-	m.SetHeader("From", "ayushnegi369@gmail.com")
-	m.SetHeader("To", to)
-	m.SetHeader("Subject", "Your Newsly Signup OTP")
-	m.SetBody("text/plain", fmt.Sprintf("Hello!\n\nYour OTP for Newsly signup is: %s\n\nThis OTP is valid for 5 minutes. Please do not share it with anyone.\n\nThank you for using Newsly!", otp))
-
-	// Use your Gmail App Password here
-	d := gopkgmail.NewDialer("smtp.gmail.com", 587, "ayushnegi369@gmail.com", "rgxb xelt kpwd tcia")
-
-	return d.DialAndSend(m)
-}
-
 func hashPassword(password string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	return string(hash), err
@@ -80,14 +37,18 @@ func checkPasswordHash(password, hash string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
 }
 
-func generateJWT(email, username string) (string, error) {
+// generateJWT mints a short-lived access token bound to a session.
+func (h *Handlers) generateJWT(email, username, sessionID string) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"email":    email,
 		"username": username,
-		"exp":      time.Now().Add(72 * time.Hour).Unix(),
+		"sid":      sessionID,
+		"iat":      now.Unix(),
+		"exp":      now.Add(h.cfg.JWT.AccessTTL).Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return token.SignedString(h.jwtSecret)
 }
 
 type PostData struct {
@@ -95,97 +56,121 @@ type PostData struct {
 	Email string `json:"email"`
 }
 
-func userExistsInAnyCollection(ctx context.Context, username, email string) (bool, string) {
-	usersCol := db.MongoDatabase.Collection("users")
-	googleCol := db.MongoDatabase.Collection("google-signup-users")
-
-	// Only check username if provided
-	if username != "" {
-		if err := usersCol.FindOne(ctx, bson.M{"username": username}).Err(); err == nil {
-			return true, "Username already exists"
-		}
-		if err := googleCol.FindOne(ctx, bson.M{"username": username}).Err(); err == nil {
-			return true, "Username already exists"
-		}
+// accountTaken writes a 409 when the username or email already belongs to an
+// account and reports whether the caller should stop.
+func (h *Handlers) accountTaken(ctx context.Context, w http.ResponseWriter, username, email string) bool {
+	exists, field, err := h.repos.Users.Conflict(ctx, username, email)
+	if err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to check existing accounts")
+		return true
 	}
-	// Check email in users
-	if email != "" {
-		if err := usersCol.FindOne(ctx, bson.M{"email": email}).Err(); err == nil {
-			return true, "Email already exists"
-		}
-		if err := googleCol.FindOne(ctx, bson.M{"email": email}).Err(); err == nil {
-			return true, "Email already exists"
+	if exists {
+		if field == "username" {
+			apierr.Write(w, http.StatusConflict, apierr.UsernameTaken, "Username already exists")
+		} else {
+			apierr.Write(w, http.StatusConflict, apierr.EmailTaken, "Email already exists")
 		}
+		return true
 	}
-	return false, ""
+	return false
 }
 
-func PostManualSignUpHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
+// writeDuplicate answers 409 when a unique index rejected an account write
+// and reports whether it did. The pre-checks in accountTaken give friendlier
+// errors, but only the index closes the race between two signups.
+func writeDuplicate(w http.ResponseWriter, err error) bool {
+	var dup *repository.DuplicateError
+	if !errors.As(err, &dup) {
+		return false
+	}
+	switch dup.Field {
+	case "username":
+		apierr.Write(w, http.StatusConflict, apierr.UsernameTaken, "Username already exists")
+	case "login":
+		apierr.Write(w, http.StatusConflict, apierr.GoogleAccountLinked, "This Google account is linked to another user")
+	default:
+		apierr.Write(w, http.StatusConflict, apierr.AccountExists, "User already exists")
+	}
+	return true
+}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusBadRequest)
-		return
-	}
-	defer r.Body.Close()
+// Limits on request fields, beyond SERVER_MAX_BODY_BYTES for the whole body
+const (
+	maxEmailLen          = 254
+	maxURLLen            = 2048
+	maxOTPLen            = 16
+	maxSummaryContentLen = 20000
+)
 
+// topicCategories are the categories a profile can follow, as offered by
+// the app's topic picker.
+var topicCategories = []string{
+	"National", "International", "Sport", "Lifestyle", "Business", "Health",
+	"Fashion", "Technology", "Science", "Art", "Politics",
+}
+
+// signupRules checks the fields every password signup sends.
+func signupRules(username, email, password string) *validate.Validator {
+	v := validate.New()
+	v.Check("username", username, validate.Required, validate.Username)
+	v.Check("email", email, validate.Required, validate.Email, validate.MaxLen(maxEmailLen))
+	checkPassword(v, "password", password)
+	return v
+}
+
+// checkPassword requires a password bcrypt can hash whole; it ignores
+// anything past 72 bytes.
+func checkPassword(v *validate.Validator, field, password string) {
+	v.Check(field, password, validate.Required)
+	v.Assert(len(password) <= 72, field, "maxBytes", "must be at most 72 bytes")
+}
+
+// writeAccountDisabled refuses to sign in an account an operator disabled.
+func writeAccountDisabled(w http.ResponseWriter) {
+	apierr.Write(w, http.StatusForbidden, apierr.AccountDisabled, "This account has been disabled")
+}
+
+func (h *Handlers) PostManualSignUpHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		Username string `json:"username"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
-	if err := json.Unmarshal(body, &data); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
 		return
 	}
+	data.Email = accounts.NormalizeEmail(data.Email)
 
-	// All fields required for manual signup
-	if data.Username == "" || data.Email == "" || data.Password == "" {
-		http.Error(w, "All fields are required", http.StatusBadRequest)
+	if !checkValid(w, signupRules(data.Username, data.Email, data.Password)) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := h.dbContext(r)
 	defer cancel()
 
-	exists, msg := userExistsInAnyCollection(ctx, data.Username, data.Email)
-	if exists {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(map[string]string{"message": msg})
+	if h.accountTaken(ctx, w, data.Username, data.Email) {
 		return
 	}
 
-	collection := db.MongoDatabase.Collection("users")
 	hashedPassword, err := hashPassword(data.Password)
 	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to hash password")
 		return
 	}
-	doc := bson.M{
-		"username":  data.Username,
-		"email":     data.Email,
-		"password":  hashedPassword,
-		"createdAt": time.Now(),
+	account := &accounts.Account{
+		Username: data.Username,
+		Email:    data.Email,
+		Logins:   []accounts.Login{{Provider: accounts.ProviderPassword, PasswordHash: hashedPassword}},
 	}
 
-	_, err = collection.InsertOne(ctx, doc)
-	if err != nil {
-		if mongo.IsDuplicateKeyError(err) {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusConflict)
-			json.NewEncoder(w).Encode(map[string]string{
-				"message": "User already exists",
-			})
+	if err := h.repos.Users.Create(ctx, account); err != nil {
+		if writeDuplicate(w, err) {
 			return
 		}
-		http.Error(w, "Failed to insert into MongoDB", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to create account")
 		return
 	}
+	h.sendWelcomeEmail(data.Email, data.Username)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -194,623 +179,699 @@ func PostManualSignUpHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func PostGoogleSignUpHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusBadRequest)
-		return
-	}
-	defer r.Body.Close()
-
+func (h *Handlers) PostGoogleSignUpHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
+		IDToken  string `json:"idToken"`
 		Name     string `json:"name"`
-		Email    string `json:"email"`
 		Password string `json:"password,omitempty"`
 	}
-	if err := json.Unmarshal(body, &data); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
 		return
 	}
-
-	// Both email and username (name) are required for Google signup
-	if data.Email == "" || data.Name == "" {
-		http.Error(w, "Email and username are required", http.StatusBadRequest)
-		return
+	if data.Password != "" {
+		v := validate.New()
+		checkPassword(v, "password", data.Password)
+		if !checkValid(w, v) {
+			return
+		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := h.dbContext(r)
 	defer cancel()
 
-	exists, msg := userExistsInAnyCollection(ctx, data.Name, data.Email)
-	if exists {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(map[string]string{"message": msg})
+	// The email always comes from the verified token, never from the body
+	claims, ok := h.verifyGoogleRequest(ctx, w, data.IDToken)
+	if !ok {
 		return
 	}
+	email := claims.Email
+	username := data.Name
+	if username == "" {
+		username = claims.Name
+	}
+	if username == "" {
+		apierr.Write(w, http.StatusBadRequest, apierr.ValidationFailed, "Username is required")
+		return
+	}
+	// A name from Google is taken as it is, spaces and all; one the client
+	// picked follows the username rules
+	if username != claims.Name {
+		v := validate.New()
+		v.Check("name", username, validate.Username)
+		if !checkValid(w, v) {
+			return
+		}
+	}
 
-	collection := db.MongoDatabase.Collection("google-signup-users")
-	doc := bson.M{
-		"username":  data.Name,
-		"email":     data.Email,
-		"createdAt": time.Now(),
+	if h.accountTaken(ctx, w, username, email) {
+		return
+	}
+
+	account := &accounts.Account{
+		Username: username,
+		Email:    email,
+		Logins:   []accounts.Login{{Provider: accounts.ProviderGoogle, Subject: claims.Subject}},
 	}
 	if data.Password != "" {
 		hashedPassword, err := hashPassword(data.Password)
 		if err != nil {
-			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to hash password")
 			return
 		}
-		doc["password"] = hashedPassword
+		account.Logins = append(account.Logins, accounts.Login{Provider: accounts.ProviderPassword, PasswordHash: hashedPassword})
 	}
 
-	_, err = collection.InsertOne(ctx, doc)
-	if err != nil {
-		if mongo.IsDuplicateKeyError(err) {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusConflict)
-			json.NewEncoder(w).Encode(map[string]string{
-				"message": "User already exists",
-			})
+	if err := h.repos.Users.Create(ctx, account); err != nil {
+		if writeDuplicate(w, err) {
 			return
 		}
-		http.Error(w, "Failed to insert into MongoDB", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Data received and stored successfully",
-	})
-}
-
-func PostManualSignInHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to create account")
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
+	h.sendWelcomeEmail(email, username)
+	pair, err := h.startSession(ctx, r, email, username)
 	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to generate token")
 		return
 	}
-	defer r.Body.Close()
+	h.writeSignedIn(w, "Data received and stored successfully", email, username, pair)
+}
 
+func (h *Handlers) PostManualSignInHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
-	if err := json.Unmarshal(body, &data); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
+		return
+	}
+	data.Email = accounts.NormalizeEmail(data.Email)
+	v := validate.New()
+	v.Check("email", data.Email, validate.Required, validate.Email)
+	v.Check("password", data.Password, validate.Required)
+	if !checkValid(w, v) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := h.dbContext(r)
 	defer cancel()
 
-	usersCol := db.MongoDatabase.Collection("users")
-	googleCol := db.MongoDatabase.Collection("google-signup-users")
-
-	// Try to find in manual users (must match password)
-	var user struct {
-		Email    string `bson:"email"`
-		Password string `bson:"password"`
-	}
-	err = usersCol.FindOne(ctx, bson.M{"email": data.Email}).Decode(&user)
-	if err == nil {
-		if checkPasswordHash(data.Password, user.Password) {
-			token, err := generateJWT(data.Email, "") // Optionally fetch username
-			if err != nil {
-				http.Error(w, "Failed to generate token", http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]string{
-				"message":  "Sign in successful (manual user)",
-				"token":    token,
-				"email":    data.Email,
-				"username": "",
-			})
-			return
-		} else {
-			http.Error(w, "Incorrect password", http.StatusUnauthorized)
+	account, err := h.repos.Users.FindByEmail(ctx, data.Email)
+	if err != nil {
+		if errors.Is(err, accounts.ErrNotFound) {
+			apierr.Write(w, http.StatusNotFound, apierr.UserNotFound, "User not found")
 			return
 		}
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to look up user")
+		return
 	}
-
-	// Try to find in Google users (no password check)
-	err = googleCol.FindOne(ctx, bson.M{"email": data.Email}).Decode(&user)
-	if err == nil {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"message": "Sign in successful (google user)"})
+	// Accounts created through Google have no password until one is set
+	passwordHash := account.PasswordHash()
+	if passwordHash == "" {
+		apierr.Write(w, http.StatusUnauthorized, apierr.PasswordLoginDisabled, "This account uses Google sign-in")
 		return
 	}
-
-	http.Error(w, "User not found", http.StatusNotFound)
-}
-
-func PostGoogleSignInHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	if !checkPasswordHash(data.Password, passwordHash) {
+		apierr.Write(w, http.StatusUnauthorized, apierr.InvalidCredentials, "Incorrect password")
 		return
 	}
-
-	body, err := io.ReadAll(r.Body)
+	// Checked after the password so it does not reveal the account's state
+	if account.Disabled() {
+		writeAccountDisabled(w)
+		return
+	}
+	pair, err := h.startSession(ctx, r, account.Email, account.Username)
 	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to generate token")
 		return
 	}
-	defer r.Body.Close()
+	h.writeSignedIn(w, "Sign in successful", account.Email, account.Username, pair)
+}
 
+func (h *Handlers) PostGoogleSignInHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
-		Email string `json:"email"`
-		Name  string `json:"name"`
+		IDToken string `json:"idToken"`
 	}
-	if err := json.Unmarshal(body, &data); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := h.dbContext(r)
 	defer cancel()
 
-	usersCol := db.MongoDatabase.Collection("users")
-	googleCol := db.MongoDatabase.Collection("google-signup-users")
+	claims, ok := h.verifyGoogleRequest(ctx, w, data.IDToken)
+	if !ok {
+		return
+	}
+	email := claims.Email
 
-	var user struct {
-		Email string `bson:"email"`
+	// Prefer the linked Google identity; fall back to the verified email so
+	// an existing password account gets Google linked to it.
+	account, err := h.repos.Users.FindByLogin(ctx, accounts.ProviderGoogle, claims.Subject)
+	if errors.Is(err, accounts.ErrNotFound) {
+		account, err = h.repos.Users.FindByEmail(ctx, email)
 	}
-	err = usersCol.FindOne(ctx, bson.M{"email": data.Email}).Decode(&user)
 	if err == nil {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"message": "Sign in successful (manual user)"})
+		if account.Disabled() {
+			writeAccountDisabled(w)
+			return
+		}
+		if login, linked := account.Login(accounts.ProviderGoogle); !linked {
+			err = h.repos.Users.LinkLogin(ctx, account.Email, accounts.Login{Provider: accounts.ProviderGoogle, Subject: claims.Subject})
+		} else if login.Subject == "" {
+			err = h.repos.Users.SetLoginSubject(ctx, account.Email, accounts.ProviderGoogle, claims.Subject)
+		}
+		if writeDuplicate(w, err) {
+			return
+		}
+		if err != nil {
+			apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to link Google account")
+			return
+		}
+		pair, err := h.startSession(ctx, r, account.Email, account.Username)
+		if err != nil {
+			apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to generate token")
+			return
+		}
+		h.writeSignedIn(w, "Sign in successful", account.Email, account.Username, pair)
 		return
 	}
-
-	err = googleCol.FindOne(ctx, bson.M{"email": data.Email}).Decode(&user)
-	if err == nil {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"message": "Sign in successful (google user)"})
+	if !errors.Is(err, accounts.ErrNotFound) {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to look up user")
 		return
 	}
 
 	// If not found, create a new Google user with username
-	doc := bson.M{
-		"email":     data.Email,
-		"createdAt": time.Now(),
+	account = &accounts.Account{
+		Email:  email,
+		Logins: []accounts.Login{{Provider: accounts.ProviderGoogle, Subject: claims.Subject}},
+	}
+	if claims.Name != "" {
+		if taken, _, err := h.repos.Users.Conflict(ctx, claims.Name, ""); err == nil && !taken {
+			account.Username = claims.Name
+		}
+	}
+	err = h.repos.Users.Create(ctx, account)
+	var dup *repository.DuplicateError
+	if errors.As(err, &dup) && dup.Field == "username" {
+		// Someone took the name since the check; sign up without one
+		account.Username = ""
+		err = h.repos.Users.Create(ctx, account)
 	}
-	if data.Name != "" {
-		doc["username"] = data.Name
+	if writeDuplicate(w, err) {
+		return
 	}
-	_, err = googleCol.InsertOne(ctx, doc)
 	if err != nil {
-		http.Error(w, "Failed to create new Google user", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to create new Google user")
 		return
 	}
+	h.sendWelcomeEmail(email, account.Username)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "New Google user created and signed in successfully"})
-}
-
-func PostRequestOTPHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	pair, err := h.startSession(ctx, r, email, account.Username)
+	if err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to generate token")
 		return
 	}
+	h.writeSignedIn(w, "New Google user created and signed in successfully", email, account.Username, pair)
+}
+
+func (h *Handlers) PostRequestOTPHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		Username string `json:"username"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
 		return
 	}
-	if data.Username == "" || data.Email == "" || data.Password == "" {
-		http.Error(w, "All fields are required", http.StatusBadRequest)
+	data.Email = accounts.NormalizeEmail(data.Email)
+	if !checkValid(w, signupRules(data.Username, data.Email, data.Password)) {
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := h.dbContext(r)
 	defer cancel()
-	// Check if user already exists in either collection
-	exists, msg := userExistsInAnyCollection(ctx, data.Username, data.Email)
-	if exists {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(map[string]string{"message": msg})
+	if h.accountTaken(ctx, w, data.Username, data.Email) {
+		return
+	}
+	if !h.allowOTPRequest(ctx, w, r, otp.PurposeSignup, data.Email) {
+		return
+	}
+	// Generate OTP and keep the pending signup with it
+	code, err := h.generateOTP()
+	if err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to generate OTP")
 		return
 	}
-	// Generate OTP and store in map
-	otp := generateOTP()
 	hashedPassword, err := hashPassword(data.Password)
 	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to hash password")
+		return
+	}
+	pending := otp.Record{Purpose: otp.PurposeSignup, Email: data.Email, Username: data.Username, PasswordHash: hashedPassword}
+	if err := h.saveOTP(ctx, pending, code); err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to store OTP")
 		return
 	}
-	otpStore.Lock()
-	otpStore.m[data.Email] = struct {
-		OTP       string
-		CreatedAt time.Time
-		Username  string
-		Password  string
-	}{OTP: otp, CreatedAt: time.Now(), Username: data.Username, Password: hashedPassword}
-	otpStore.Unlock()
-	// Log OTP (replace with email sending in production)
-	if err := sendOTPEmail(data.Email, otp); err != nil {
-		http.Error(w, "Failed to send OTP email", http.StatusInternalServerError)
+	if err := h.sendOTPEmail(ctx, data.Email, mailer.TemplateSignupOTP, code); err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to send OTP email")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "OTP sent to email"})
 }
 
-func PostVerifyOTPHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
+func (h *Handlers) PostVerifyOTPHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		Email string `json:"email"`
 		OTP   string `json:"otp"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
 		return
 	}
-	otpStore.RLock()
-	entry, ok := otpStore.m[data.Email]
-	otpStore.RUnlock()
-	if !ok {
-		http.Error(w, "No OTP requested for this email", http.StatusNotFound)
+	data.Email = accounts.NormalizeEmail(data.Email)
+	v := validate.New()
+	v.Check("email", data.Email, validate.Required, validate.Email)
+	v.Check("otp", data.OTP, validate.Required, validate.MaxLen(maxOTPLen))
+	if !checkValid(w, v) {
 		return
 	}
-	if entry.OTP != data.OTP {
-		http.Error(w, "Invalid OTP", http.StatusUnauthorized)
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	if !h.allowOTPVerify(ctx, w, r) {
 		return
 	}
-	if time.Since(entry.CreatedAt) > 5*time.Minute {
-		otpStore.Lock()
-		delete(otpStore.m, data.Email)
-		otpStore.Unlock()
-		http.Error(w, "OTP expired", http.StatusUnauthorized)
+	entry, ok := h.checkOTP(ctx, w, otp.PurposeSignup, data.Email, data.OTP)
+	if !ok {
 		return
 	}
 	// Passed: create user in DB
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	collection := db.MongoDatabase.Collection("users")
-	doc := bson.M{
-		"username":  entry.Username,
-		"email":     data.Email,
-		"password":  entry.Password,
-		"createdAt": time.Now(),
-	}
-	_, err := collection.InsertOne(ctx, doc)
-	if err != nil {
-		http.Error(w, "Failed to insert into MongoDB", http.StatusInternalServerError)
+	account := &accounts.Account{
+		Username: entry.Username,
+		Email:    data.Email,
+		Logins:   []accounts.Login{{Provider: accounts.ProviderPassword, PasswordHash: entry.PasswordHash}},
+	}
+	if err := h.repos.Users.Create(ctx, account); err != nil {
+		if writeDuplicate(w, err) {
+			return
+		}
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to create account")
 		return
 	}
-	// Remove OTP from map
-	otpStore.Lock()
-	delete(otpStore.m, data.Email)
-	otpStore.Unlock()
-	token, err := generateJWT(data.Email, entry.Username)
+	h.otps.Delete(ctx, otp.PurposeSignup, data.Email)
+	h.sendWelcomeEmail(data.Email, entry.Username)
+	pair, err := h.startSession(ctx, r, data.Email, entry.Username)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to generate token")
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message":  "User registered successfully",
-		"token":    token,
-		"email":    data.Email,
-		"username": entry.Username,
-	})
+	h.writeSignedIn(w, "User registered successfully", data.Email, entry.Username, pair)
 }
 
-func PostRequestPasswordResetOTPHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
+func (h *Handlers) PostRequestPasswordResetOTPHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		Email string `json:"email"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
 		return
 	}
-	if data.Email == "" {
-		http.Error(w, "Email is required", http.StatusBadRequest)
+	data.Email = accounts.NormalizeEmail(data.Email)
+	v := validate.New()
+	v.Check("email", data.Email, validate.Required, validate.Email)
+	if !checkValid(w, v) {
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := h.dbContext(r)
 	defer cancel()
-	usersCol := db.MongoDatabase.Collection("users")
-	googleCol := db.MongoDatabase.Collection("google-signup-users")
-	var user struct {
-		Email string `bson:"email"`
+	if _, err := h.repos.Users.FindByEmail(ctx, data.Email); err != nil {
+		if errors.Is(err, accounts.ErrNotFound) {
+			apierr.Write(w, http.StatusNotFound, apierr.UserNotFound, "User not found")
+			return
+		}
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to look up user")
+		return
+	}
+	if !h.allowOTPRequest(ctx, w, r, otp.PurposePasswordReset, data.Email) {
+		return
 	}
-	err1 := usersCol.FindOne(ctx, bson.M{"email": data.Email}).Decode(&user)
-	err2 := googleCol.FindOne(ctx, bson.M{"email": data.Email}).Decode(&user)
-	if err1 != nil && err2 != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	code, err := h.generateOTP()
+	if err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to generate OTP")
+		return
+	}
+	if err := h.saveOTP(ctx, otp.Record{Purpose: otp.PurposePasswordReset, Email: data.Email}, code); err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to store OTP")
 		return
 	}
-	otp := generateOTP()
-	resetOtpStore.Lock()
-	resetOtpStore.m[data.Email] = struct {
-		OTP       string
-		CreatedAt time.Time
-	}{OTP: otp, CreatedAt: time.Now()}
-	resetOtpStore.Unlock()
-	if err := sendOTPEmail(data.Email, otp); err != nil {
-		http.Error(w, "Failed to send OTP email", http.StatusInternalServerError)
+	if err := h.sendOTPEmail(ctx, data.Email, mailer.TemplatePasswordReset, code); err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to send OTP email")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "OTP sent to email"})
 }
 
-func PostVerifyPasswordResetOTPHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
+func (h *Handlers) PostVerifyPasswordResetOTPHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		Email string `json:"email"`
 		OTP   string `json:"otp"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
 		return
 	}
-	resetOtpStore.RLock()
-	entry, ok := resetOtpStore.m[data.Email]
-	resetOtpStore.RUnlock()
-	if !ok {
-		http.Error(w, "No OTP requested for this email", http.StatusNotFound)
+	data.Email = accounts.NormalizeEmail(data.Email)
+	v := validate.New()
+	v.Check("email", data.Email, validate.Required, validate.Email)
+	v.Check("otp", data.OTP, validate.Required, validate.MaxLen(maxOTPLen))
+	if !checkValid(w, v) {
 		return
 	}
-	if entry.OTP != data.OTP {
-		http.Error(w, "Invalid OTP", http.StatusUnauthorized)
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	if !h.allowOTPVerify(ctx, w, r) {
 		return
 	}
-	if time.Since(entry.CreatedAt) > 5*time.Minute {
-		resetOtpStore.Lock()
-		delete(resetOtpStore.m, data.Email)
-		resetOtpStore.Unlock()
-		http.Error(w, "OTP expired", http.StatusUnauthorized)
+	if _, ok := h.checkOTP(ctx, w, otp.PurposePasswordReset, data.Email, data.OTP); !ok {
+		return
+	}
+	// The code has done its job; only the reset token can be used from here
+	h.otps.Delete(ctx, otp.PurposePasswordReset, data.Email)
+	resetToken, err := h.issueResetToken(ctx, data.Email)
+	if err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to issue reset token")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "OTP verified, proceed to reset password"})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "OTP verified, proceed to reset password",
+		"resetToken": resetToken,
+		"expiresIn":  int(h.cfg.OTP.ResetTokenTTL.Seconds()),
+	})
 }
 
-func PostResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
+func (h *Handlers) PostResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		Email       string `json:"email"`
+		ResetToken  string `json:"resetToken"`
 		NewPassword string `json:"newPassword"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
 		return
 	}
-	if data.Email == "" || data.NewPassword == "" {
-		http.Error(w, "Email and new password are required", http.StatusBadRequest)
+	data.Email = accounts.NormalizeEmail(data.Email)
+	v := validate.New()
+	v.Check("email", data.Email, validate.Required, validate.Email)
+	v.Check("resetToken", data.ResetToken, validate.Required)
+	checkPassword(v, "newPassword", data.NewPassword)
+	if !checkValid(w, v) {
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := h.dbContext(r)
 	defer cancel()
-	usersCol := db.MongoDatabase.Collection("users")
-	googleCol := db.MongoDatabase.Collection("google-signup-users")
+	if !h.consumeResetToken(ctx, w, data.Email, data.ResetToken) {
+		return
+	}
 	hashedPassword, err := hashPassword(data.NewPassword)
 	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to hash password")
 		return
 	}
-	update := bson.M{"$set": bson.M{"password": hashedPassword}}
-	res1, err1 := usersCol.UpdateOne(ctx, bson.M{"email": data.Email}, update)
-	res2, err2 := googleCol.UpdateOne(ctx, bson.M{"email": data.Email}, update)
-	if (err1 != nil || res1.MatchedCount == 0) && (err2 != nil || res2.MatchedCount == 0) {
-		http.Error(w, "User not found or failed to update password", http.StatusNotFound)
+	// Google-only accounts gain a password login here
+	if err := h.repos.Users.SetPassword(ctx, data.Email, hashedPassword); err != nil {
+		if errors.Is(err, accounts.ErrNotFound) {
+			apierr.Write(w, http.StatusNotFound, apierr.UserNotFound, "User not found")
+			return
+		}
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to update password")
+		return
+	}
+	// Sign out every device that knew the old password
+	if err := h.repos.Sessions.RevokeAll(ctx, data.Email); err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Password reset but failed to revoke sessions")
 		return
 	}
-	resetOtpStore.Lock()
-	delete(resetOtpStore.m, data.Email)
-	resetOtpStore.Unlock()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Password reset successfully"})
 }
 
-func HelloHandler(w http.ResponseWriter, r *http.Request) {
-	// Optional: check method
-	if r.Method != http.MethodGet {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
+func (h *Handlers) HelloHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Hello, World! Your server is working 🚀")
 }
 
-// Handler to fetch user details by email
-func GetUserDetailsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-	email := r.URL.Query().Get("email")
-	if email == "" {
-		http.Error(w, "Email is required", http.StatusBadRequest)
+// Handler to fetch the authenticated user's details
+func (h *Handlers) GetUserDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	email, ok := authorizedEmail(w, r, r.URL.Query().Get("email"))
+	if !ok {
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := h.dbContext(r)
 	defer cancel()
-	usersCol := db.MongoDatabase.Collection("users")
-	googleCol := db.MongoDatabase.Collection("google-signup-users")
-	var user bson.M
-	err1 := usersCol.FindOne(ctx, bson.M{"email": email}).Decode(&user)
-	err2 := googleCol.FindOne(ctx, bson.M{"email": email}).Decode(&user)
-	if err1 != nil && err2 != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	account, err := h.repos.Users.FindByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, accounts.ErrNotFound) {
+			apierr.Write(w, http.StatusNotFound, apierr.UserNotFound, "User not found")
+			return
+		}
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to look up user")
 		return
 	}
-	delete(user, "password") // Don't send password
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(account.View())
 }
 
-// Handler to update user details by email
-func PostUpdateUserDetailsHandler(w http.ResponseWriter, r *http.Request) {
-	// CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	fmt.Println("update-user-details called, method:", r.Method)
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
+// Handler to update the authenticated user's details
+func (h *Handlers) PostUpdateUserDetailsHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
-		Email       string   `json:"email"`
-		Username    string   `json:"username"`
-		Password    string   `json:"password"`
-		FullName    string   `json:"fullName"`
-		Phone       string   `json:"phone"`
-		Bio         string   `json:"bio"`
-		Website     string   `json:"website"`
-		Avatar      string   `json:"avatar"`
-		Country     string   `json:"country"`
-		Categories  []string `json:"categories"`
-		NewsSources []string `json:"newsSources"`
+		Email    string `json:"email"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		// CurrentPassword must accompany a new password
+		CurrentPassword string   `json:"currentPassword"`
+		FullName        string   `json:"fullName"`
+		Phone           string   `json:"phone"`
+		Bio             string   `json:"bio"`
+		Website         string   `json:"website"`
+		Avatar          string   `json:"avatar"`
+		Country         string   `json:"country"`
+		Categories      []string `json:"categories"`
+		NewsSources     []string `json:"newsSources"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &data) {
 		return
 	}
-	if data.Email == "" {
-		http.Error(w, "Email is required", http.StatusBadRequest)
+	email, ok := authorizedEmail(w, r, data.Email)
+	if !ok {
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	usersCol := db.MongoDatabase.Collection("users")
-	googleCol := db.MongoDatabase.Collection("google-signup-users")
-	update := bson.M{"$set": bson.M{}}
-	if data.Username != "" {
-		update["$set"].(bson.M)["username"] = data.Username
+	user, _ := UserFromContext(r.Context())
+	renamed := data.Username != "" && data.Username != user.Username
+	v := validate.New()
+	// Names that came from Google may not follow the rules; they only apply
+	// to a new choice
+	if renamed {
+		v.Check("username", data.Username, validate.Username)
 	}
 	if data.Password != "" {
+		checkPassword(v, "password", data.Password)
+		checkPassword(v, "currentPassword", data.CurrentPassword)
+	}
+	v.Check("fullName", data.FullName, validate.MaxLen(100))
+	v.Check("phone", data.Phone, validate.MaxLen(32))
+	v.Check("bio", data.Bio, validate.MaxLen(500))
+	v.Check("website", data.Website, validate.URL, validate.MaxLen(maxURLLen))
+	v.Check("avatar", data.Avatar, validate.URL, validate.MaxLen(maxURLLen))
+	v.Check("country", data.Country, validate.Country)
+	v.Each("categories", data.Categories, len(topicCategories), validate.OneOf(topicCategories...))
+	v.Each("newsSources", data.NewsSources, 50, validate.MaxLen(100))
+	if !checkValid(w, v) {
+		return
+	}
+	update := repository.ProfileUpdate{
+		Username:    data.Username,
+		FullName:    data.FullName,
+		Phone:       data.Phone,
+		Bio:         data.Bio,
+		Website:     data.Website,
+		Avatar:      data.Avatar,
+		Country:     data.Country,
+		Categories:  data.Categories,
+		NewsSources: data.NewsSources,
+	}
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	// A stolen access token must not be enough to take the account over.
+	// Accounts without a password get one through password reset, which
+	// proves control of the email.
+	if data.Password != "" {
+		account, err := h.repos.Users.FindByEmail(ctx, email)
+		if errors.Is(err, accounts.ErrNotFound) {
+			apierr.Write(w, http.StatusNotFound, apierr.UserNotFound, "User not found")
+			return
+		}
+		if err != nil {
+			apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to look up user")
+			return
+		}
+		if account.PasswordHash() == "" {
+			apierr.Write(w, http.StatusForbidden, apierr.PasswordLoginDisabled, "This account has no password yet; set one with a password reset")
+			return
+		}
+		if !checkPasswordHash(data.CurrentPassword, account.PasswordHash()) {
+			apierr.Write(w, http.StatusUnauthorized, apierr.InvalidCredentials, "Incorrect current password")
+			return
+		}
+		// Hashing before the write means a failure leaves the account untouched
 		hashedPassword, err := hashPassword(data.Password)
 		if err != nil {
-			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to hash password")
 			return
 		}
-		update["$set"].(bson.M)["password"] = hashedPassword
-	}
-	if data.FullName != "" {
-		update["$set"].(bson.M)["fullName"] = data.FullName
-	}
-	if data.Phone != "" {
-		update["$set"].(bson.M)["phone"] = data.Phone
-	}
-	if data.Bio != "" {
-		update["$set"].(bson.M)["bio"] = data.Bio
-	}
-	if data.Website != "" {
-		update["$set"].(bson.M)["website"] = data.Website
+		update.PasswordHash = hashedPassword
 	}
-	if data.Avatar != "" {
-		update["$set"].(bson.M)["avatar"] = data.Avatar
-	}
-	if data.Country != "" {
-		update["$set"].(bson.M)["country"] = data.Country
-	}
-	if data.Categories != nil && len(data.Categories) > 0 {
-		update["$set"].(bson.M)["categories"] = data.Categories
+	if err := h.repos.Users.UpdateProfile(ctx, email, update); err != nil {
+		if writeDuplicate(w, err) {
+			return
+		}
+		if errors.Is(err, accounts.ErrNotFound) {
+			apierr.Write(w, http.StatusNotFound, apierr.UserNotFound, "User not found")
+			return
+		}
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to update user")
+		return
 	}
-	if data.NewsSources != nil && len(data.NewsSources) > 0 {
-		update["$set"].(bson.M)["newsSources"] = data.NewsSources
+	if data.Password != "" {
+		// A password change signs out every session, including this one,
+		// and hands the caller a fresh session to continue with.
+		if err := h.repos.Sessions.RevokeAll(ctx, email); err != nil {
+			apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to revoke sessions")
+			return
+		}
+		username := user.Username
+		if renamed {
+			username = data.Username
+		}
+		pair, err := h.startSession(ctx, r, email, username)
+		if err != nil {
+			apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to generate token")
+			return
+		}
+		h.writeSignedIn(w, "User details updated successfully", email, username, pair)
+		return
 	}
-	res1, err1 := usersCol.UpdateOne(ctx, bson.M{"email": data.Email}, update)
-	res2, err2 := googleCol.UpdateOne(ctx, bson.M{"email": data.Email}, update)
-	if (err1 != nil || res1.MatchedCount == 0) && (err2 != nil || res2.MatchedCount == 0) {
-		http.Error(w, "User not found or failed to update", http.StatusNotFound)
+	if renamed {
+		// Sessions carry the name into every token they are refreshed
+		// into; the caller gets a token with the new one straight away
+		if err := h.repos.Sessions.SetUsername(ctx, email, data.Username); err != nil {
+			apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to update sessions")
+			return
+		}
+		access, err := h.generateJWT(email, data.Username, user.SessionID)
+		if err != nil {
+			apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to generate token")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":   "User details updated successfully",
+			"token":     access,
+			"expiresIn": int(h.cfg.JWT.AccessTTL.Seconds()),
+			"username":  data.Username,
+		})
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "User details updated successfully"})
 }
 
-// Helper to fetch everything articles from NewsAPI
-func fetchEverythingFromNewsAPI(apiKey, q, sources, domains, from, to, language, sortBy string, page int) ([]map[string]interface{}, error) {
-	url := fmt.Sprintf("https://newsapi.org/v2/everything?q=%s&sources=%s&domains=%s&from=%s&to=%s&language=%s&sortBy=%s&page=%d&apiKey=%s",
-		q, sources, domains, from, to, language, sortBy, page, apiKey)
-	resp, err := http.Get(url)
+// errArticleNotFound means a provider has no article at the URL asked for.
+var errArticleNotFound = errors.New("article not found")
+
+// findArticle looks an article up by URL. Providers cannot fetch by URL, so
+// it searches the article's site for the last path segment, which is
+// usually the headline's slug, and picks the exact match.
+func (h *Handlers) findArticle(ctx context.Context, articleURL string) (*news.Article, error) {
+	parsed, err := url.Parse(articleURL)
+	if err != nil {
+		return nil, errArticleNotFound
+	}
+	segments := strings.Split(parsed.Path, "/")
+	articles, err := h.news.Everything(ctx, news.SearchQuery{
+		Query:    segments[len(segments)-1],
+		Domains:  []string{parsed.Hostname()},
+		Language: "en",
+		SortBy:   "publishedAt",
+		Page:     1,
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Failed to fetch everything from NewsAPI: %s", resp.Status)
-	}
-	var apiResp struct {
-		Status       string                   `json:"status"`
-		TotalResults int                      `json:"totalResults"`
-		Articles     []map[string]interface{} `json:"articles"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		return nil, err
+	for i := range articles {
+		if articles[i].URL == articleURL {
+			return &articles[i], nil
+		}
 	}
-	return apiResp.Articles, nil
+	slog.DebugContext(ctx, "article not in provider results", "domain", parsed.Hostname(), "results", len(articles))
+	return nil, errArticleNotFound
 }
 
-// Handler to fetch news from NewsAPI and return trending and latest news
-func GetNewsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+// writeNewsError answers for a provider call that failed.
+func writeNewsError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, news.ErrNotConfigured) {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "News provider not configured")
 		return
 	}
+	slog.ErrorContext(r.Context(), "news provider request failed", logging.Err(err))
+	apierr.Write(w, http.StatusBadGateway, apierr.UpstreamError, "Failed to fetch news")
+}
+
+// checkDate records a failure unless s is empty, a date or an RFC 3339
+// time, and returns the time it names.
+func checkDate(v *validate.Validator, field, s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	v.Assert(err == nil, field, "date", "must be a date such as 2024-05-01 or an RFC 3339 time")
+	return t
+}
 
-	apiKey := os.Getenv("NEWS_API_KEY")
-	if apiKey == "" {
-		http.Error(w, "News API key not set", http.StatusInternalServerError)
-		return
-	}
-
-	typeParam := r.URL.Query().Get("type")
-	if typeParam == "everything" {
-		// Fetch from /v2/everything
-		q := r.URL.Query().Get("q")
-		sources := r.URL.Query().Get("sources")
-		domains := r.URL.Query().Get("domains")
-		from := r.URL.Query().Get("from")
-		to := r.URL.Query().Get("to")
-		language := r.URL.Query().Get("language")
-		sortBy := r.URL.Query().Get("sortBy")
-		page := 1
-		if p := r.URL.Query().Get("page"); p != "" {
-			fmt.Sscanf(p, "%d", &page)
+// Handler to fetch news and return trending and latest news, or with
+// type=everything, search every article
+func (h *Handlers) GetNewsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("type") == "everything" {
+		v := validate.New()
+		search := news.SearchQuery{
+			Query:    query.Get("q"),
+			Language: query.Get("language"),
+			SortBy:   query.Get("sortBy"),
+			From:     checkDate(v, "from", query.Get("from")),
+			To:       checkDate(v, "to", query.Get("to")),
+			Page:     1,
+		}
+		if s := query.Get("sources"); s != "" {
+			search.Sources = strings.Split(s, ",")
 		}
-		articles, err := fetchEverythingFromNewsAPI(apiKey, q, sources, domains, from, to, language, sortBy, page)
+		if d := query.Get("domains"); d != "" {
+			search.Domains = strings.Split(d, ",")
+		}
+		if p := query.Get("page"); p != "" {
+			n, err := strconv.Atoi(p)
+			v.Assert(err == nil && n > 0, "page", "min", "must be a whole number from 1")
+			search.Page = n
+		}
+		v.Check("q", search.Query, validate.MaxLen(500))
+		v.Check("language", search.Language, validate.OneOf(news.Languages...))
+		v.Check("sortBy", search.SortBy, validate.OneOf(news.SortOrders...))
+		if !checkValid(w, v) {
+			return
+		}
+		articles, err := h.news.Everything(r.Context(), search)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeNewsError(w, r, err)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -820,47 +881,26 @@ func GetNewsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	country := r.URL.Query().Get("country")
+	country := query.Get("country")
 	if country == "" {
 		country = "us"
 	}
-	category := r.URL.Query().Get("category")
+	category := query.Get("category")
 	if category == "" {
 		category = "sports"
 	}
-	searchQ := r.URL.Query().Get("q")
-
-	url := fmt.Sprintf("https://newsapi.org/v2/top-headlines?country=%s&category=%s&apiKey=%s", country, category, apiKey)
-	resp, err := http.Get(url)
-	if err != nil {
-		http.Error(w, "Failed to fetch news", http.StatusInternalServerError)
+	searchQ := query.Get("q")
+	v := validate.New()
+	v.Check("country", country, validate.Country)
+	v.Check("category", category, validate.OneOf(news.Categories...))
+	v.Check("q", searchQ, validate.MaxLen(500))
+	if !checkValid(w, v) {
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		http.Error(w, "Failed to fetch news from NewsAPI", http.StatusInternalServerError)
-		return
-	}
-
-	var apiResp struct {
-		Status       string `json:"status"`
-		TotalResults int    `json:"totalResults"`
-		Articles     []struct {
-			Source struct {
-				Name string `json:"name"`
-			} `json:"source"`
-			Author      string `json:"author"`
-			Title       string `json:"title"`
-			Description string `json:"description"`
-			Url         string `json:"url"`
-			UrlToImage  string `json:"urlToImage"`
-			PublishedAt string `json:"publishedAt"`
-			Content     string `json:"content"`
-		} `json:"articles"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		http.Error(w, "Failed to decode news response", http.StatusInternalServerError)
+	articles, err := h.news.TopHeadlines(r.Context(), news.HeadlinesQuery{Country: country, Category: category})
+	if err != nil {
+		writeNewsError(w, r, err)
 		return
 	}
 
@@ -874,15 +914,15 @@ func GetNewsHandler(w http.ResponseWriter, r *http.Request) {
 
 	var filtered []NewsItem
 	now := time.Now().UTC()
-	for _, article := range apiResp.Articles {
-		if article.Title == "" || article.UrlToImage == "" {
+	for _, article := range articles {
+		if article.Title == "" || article.URLToImage == "" {
 			continue // skip articles without title or image
 		}
 		if searchQ != "" && !strings.Contains(strings.ToLower(article.Title), strings.ToLower(searchQ)) {
 			continue // filter by search query
 		}
-		publishedAt, err := time.Parse(time.RFC3339, article.PublishedAt)
-		if err != nil {
+		publishedAt := article.PublishedAt
+		if publishedAt.IsZero() {
 			publishedAt = now
 		}
 		delta := now.Sub(publishedAt)
@@ -893,7 +933,7 @@ func GetNewsHandler(w http.ResponseWriter, r *http.Request) {
 			publishedAgo = fmt.Sprintf("%dm ago", int(delta.Minutes()))
 		}
 		item := NewsItem{
-			Image:        article.UrlToImage,
+			Image:        article.URLToImage,
 			Country:      country,
 			Title:        article.Title,
 			NewsCompany:  article.Source.Name,
@@ -919,119 +959,84 @@ func GetNewsHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// Handler to fetch a single news article by URL
-func GetNewsArticleByURLHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+// GET /news/sources lists the publishers the provider knows, optionally by
+// country, category and language
+func (h *Handlers) GetNewsSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := news.SourcesQuery{Country: query.Get("country"), Category: query.Get("category"), Language: query.Get("language")}
+	v := validate.New()
+	v.Check("country", q.Country, validate.Country)
+	v.Check("category", q.Category, validate.OneOf(news.Categories...))
+	v.Check("language", q.Language, validate.OneOf(news.Languages...))
+	if !checkValid(w, v) {
 		return
 	}
-	apiKey := os.Getenv("NEWS_API_KEY")
-	if apiKey == "" {
-		http.Error(w, "News API key not set", http.StatusInternalServerError)
+	sources, err := h.news.Sources(r.Context(), q)
+	if err != nil {
+		writeNewsError(w, r, err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sources": sources})
+}
+
+// Handler to fetch a single news article by URL
+func (h *Handlers) GetNewsArticleByURLHandler(w http.ResponseWriter, r *http.Request) {
 	urlParam := r.URL.Query().Get("url")
-	if urlParam == "" {
-		http.Error(w, "URL is required", http.StatusBadRequest)
+	v := validate.New()
+	v.Check("url", urlParam, validate.Required, validate.URL, validate.MaxLen(maxURLLen))
+	if !checkValid(w, v) {
 		return
 	}
-	fmt.Println("[DEBUG] /news/article called with url:", urlParam)
-	parsed, err := url.Parse(urlParam)
-	if err != nil {
-		http.Error(w, "Invalid URL", http.StatusBadRequest)
+	article, err := h.findArticle(r.Context(), urlParam)
+	if errors.Is(err, errArticleNotFound) {
+		apierr.Write(w, http.StatusNotFound, apierr.ArticleNotFound, "Article not found")
 		return
 	}
-	domain := parsed.Hostname()
-	segments := parsed.Path
-	q := ""
-	if segments != "" {
-		parts := strings.Split(segments, "/")
-		if len(parts) > 0 {
-			q = parts[len(parts)-1]
-		}
-	}
-	fmt.Printf("[DEBUG] NewsAPI everything search: domain=%s, q=%s\n", domain, q)
-	articles, err := fetchEverythingFromNewsAPI(apiKey, q, "", domain, "", "", "en", "publishedAt", 1)
 	if err != nil {
-		fmt.Println("[ERROR] NewsAPI fetch error:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeNewsError(w, r, err)
 		return
 	}
-	for _, article := range articles {
-		if article["url"] == urlParam {
-			fmt.Println("[DEBUG] Article found and returned.")
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(article)
-			return
-		}
-	}
-	fmt.Println("[DEBUG] Article not found in NewsAPI response.")
-	http.Error(w, "Article not found", http.StatusNotFound)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(article)
 }
 
 // Handler to summarize a news article using Gemini API
-func PostNewsSummaryHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-	apiKey := os.Getenv("GEMINI_API_KEY")
+func (h *Handlers) PostNewsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey := h.cfg.Gemini.Key
 	if apiKey == "" {
-		http.Error(w, "Gemini API key not set", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Gemini API key not set")
 		return
 	}
 	var req struct {
 		Url     string `json:"url"`
 		Content string `json:"content"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	if req.Content == "" && req.Url == "" {
-		http.Error(w, "Either content or url is required", http.StatusBadRequest)
+	v := validate.New()
+	v.Assert(req.Content != "" || req.Url != "", "content", "required", "or url is required")
+	v.Check("url", req.Url, validate.URL, validate.MaxLen(maxURLLen))
+	v.Check("content", req.Content, validate.MaxLen(maxSummaryContentLen))
+	if !checkValid(w, v) {
 		return
 	}
-	fmt.Println("[DEBUG] /news/summary called with url:", req.Url)
 	articleContent := req.Content
-	if articleContent == "" && req.Url != "" {
-		newsApiKey := os.Getenv("NEWS_API_KEY")
-		if newsApiKey == "" {
-			http.Error(w, "News API key not set", http.StatusInternalServerError)
-			return
-		}
-		parsed, err := url.Parse(req.Url)
+	if articleContent == "" {
+		article, err := h.findArticle(r.Context(), req.Url)
 		if err == nil {
-			domain := parsed.Hostname()
-			segments := parsed.Path
-			q := ""
-			if segments != "" {
-				parts := strings.Split(segments, "/")
-				if len(parts) > 0 {
-					q = parts[len(parts)-1]
-				}
-			}
-			fmt.Printf("[DEBUG] NewsAPI everything search for summary: domain=%s, q=%s\n", domain, q)
-			articles, err := fetchEverythingFromNewsAPI(newsApiKey, q, "", domain, "", "", "en", "publishedAt", 1)
-			if err == nil {
-				for _, article := range articles {
-					if article["url"] == req.Url {
-						if desc, ok := article["description"].(string); ok && desc != "" {
-							articleContent = desc
-						} else if content, ok := article["content"].(string); ok && content != "" {
-							articleContent = content
-						}
-						break
-					}
-				}
-			} else {
-				fmt.Println("[ERROR] NewsAPI fetch error for summary:", err)
+			articleContent = article.Description
+			if articleContent == "" {
+				articleContent = article.Content
 			}
+		} else if !errors.Is(err, errArticleNotFound) {
+			slog.ErrorContext(r.Context(), "news provider request failed", logging.Err(err))
 		}
 	}
 	if articleContent == "" {
-		fmt.Println("[DEBUG] Could not fetch article content for summary.")
-		http.Error(w, "Could not fetch article content", http.StatusNotFound)
+		slog.DebugContext(r.Context(), "no article content to summarize")
+		apierr.Write(w, http.StatusNotFound, apierr.ArticleNotFound, "Could not fetch article content")
 		return
 	}
 	// Call Gemini API to summarize
@@ -1039,30 +1044,44 @@ func PostNewsSummaryHandler(w http.ResponseWriter, r *http.Request) {
 		"contents": []map[string]interface{}{
 			{
 				"role":  "user",
-				"parts": []string{"Summarize this news article in 5-6 lines:\n" + articleContent},
+				"parts": []map[string]string{{"text": "Summarize this news article in 5-6 lines:\n" + articleContent}},
 			},
 		},
 	}
 	geminiBody, _ := json.Marshal(geminiReq)
-	geminiResp, err := http.Post("https://generativelanguage.googleapis.com/v1beta/models/gemini-pro:generateContent?key="+apiKey, "application/json", strings.NewReader(string(geminiBody)))
+	start := time.Now()
+	geminiHTTPReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost,
+		h.cfg.Gemini.BaseURL+"/v1beta/models/"+h.cfg.Gemini.Model+":generateContent", bytes.NewReader(geminiBody))
+	if err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to summarize article")
+		return
+	}
+	geminiHTTPReq.Header.Set("Content-Type", "application/json")
+	// Sent as a header so the key stays out of URLs, logs and traces
+	geminiHTTPReq.Header.Set("x-goog-api-key", apiKey)
+	geminiResp, err := h.geminiClient.Do(geminiHTTPReq)
 	if err != nil {
-		fmt.Println("[ERROR] Gemini API call error:", err)
-		http.Error(w, "Failed to call Gemini API", http.StatusInternalServerError)
+		metrics.ObserveOutbound("gemini", "generate_content", metrics.OutcomeNetworkError, start)
+		slog.ErrorContext(r.Context(), "Gemini request failed", logging.Err(err))
+		apierr.Write(w, http.StatusBadGateway, apierr.UpstreamError, "Failed to summarize article")
 		return
 	}
 	defer geminiResp.Body.Close()
 	if geminiResp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(geminiResp.Body)
-		fmt.Printf("[ERROR] Gemini API returned status %d: %s\n", geminiResp.StatusCode, string(body))
-		http.Error(w, string(body), http.StatusInternalServerError)
+		body, _ := io.ReadAll(io.LimitReader(geminiResp.Body, 4096))
+		metrics.ObserveOutbound("gemini", "generate_content", metrics.OutcomeUpstreamError, start)
+		slog.ErrorContext(r.Context(), "Gemini returned an error", "status", geminiResp.StatusCode, "body", string(body))
+		apierr.Write(w, http.StatusBadGateway, apierr.UpstreamError, "Failed to summarize article")
 		return
 	}
 	var geminiResult map[string]interface{}
 	if err := json.NewDecoder(geminiResp.Body).Decode(&geminiResult); err != nil {
-		fmt.Println("[ERROR] Failed to decode Gemini response:", err)
-		http.Error(w, "Failed to decode Gemini response", http.StatusInternalServerError)
+		metrics.ObserveOutbound("gemini", "generate_content", metrics.OutcomeDecodeError, start)
+		slog.ErrorContext(r.Context(), "failed to decode Gemini response", logging.Err(err))
+		apierr.Write(w, http.StatusBadGateway, apierr.UpstreamError, "Failed to summarize article")
 		return
 	}
+	metrics.ObserveOutbound("gemini", "generate_content", metrics.OutcomeSuccess, start)
 	// Extract summary from Gemini response
 	summary := ""
 	if candidates, ok := geminiResult["candidates"].([]interface{}); ok && len(candidates) > 0 {
@@ -1078,89 +1097,70 @@ func PostNewsSummaryHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
-	fmt.Println("[DEBUG] Gemini summary generated.")
+	slog.DebugContext(r.Context(), "summary generated", "chars", len(summary))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"summary": summary})
 }
 
 // --- Explore Handlers ---
-func GetExploreTopicsHandler(w http.ResponseWriter, r *http.Request) {
-	coll := db.MongoDatabase.Collection("topics")
-	cur, err := coll.Find(context.Background(), bson.M{})
+func (h *Handlers) GetExploreTopicsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	topics, err := h.repos.Topics.List(ctx)
 	if err != nil {
-		http.Error(w, "Failed to fetch topics", http.StatusInternalServerError)
-		return
-	}
-	defer cur.Close(context.Background())
-	var topics []bson.M
-	if err := cur.All(context.Background(), &topics); err != nil {
-		http.Error(w, "Failed to decode topics", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to fetch topics")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"topics": topics})
 }
 
-func GetExploreNewsByTopicHandler(w http.ResponseWriter, r *http.Request) {
-	topic := r.URL.Query().Get("topic")
+// GET /explore/topics/{topic}/news, or /explore/news?topic=
+func (h *Handlers) GetExploreNewsByTopicHandler(w http.ResponseWriter, r *http.Request) {
+	topic := r.PathValue("topic")
 	if topic == "" {
-		http.Error(w, "Topic is required", http.StatusBadRequest)
-		return
+		topic = r.URL.Query().Get("topic")
 	}
-	coll := db.MongoDatabase.Collection("news")
-	cur, err := coll.Find(context.Background(), bson.M{"category": topic})
-	if err != nil {
-		http.Error(w, "Failed to fetch news", http.StatusInternalServerError)
+	v := validate.New()
+	v.Check("topic", topic, validate.Required, validate.MaxLen(100))
+	if !checkValid(w, v) {
 		return
 	}
-	defer cur.Close(context.Background())
-	var news []bson.M
-	if err := cur.All(context.Background(), &news); err != nil {
-		http.Error(w, "Failed to decode news", http.StatusInternalServerError)
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	news, err := h.repos.News.ByCategory(ctx, topic)
+	if err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to fetch news")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"news": news})
 }
 
-func GetExploreTrendingHandler(w http.ResponseWriter, r *http.Request) {
-	coll := db.MongoDatabase.Collection("news")
-	cur, err := coll.Find(context.Background(), bson.M{"trending": true})
+func (h *Handlers) GetExploreTrendingHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	trending, err := h.repos.News.Trending(ctx)
 	if err != nil {
-		http.Error(w, "Failed to fetch trending news", http.StatusInternalServerError)
-		return
-	}
-	defer cur.Close(context.Background())
-	var trending []bson.M
-	if err := cur.All(context.Background(), &trending); err != nil {
-		http.Error(w, "Failed to decode trending news", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to fetch trending news")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"trending": trending})
 }
 
-func GetExploreSearchHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetExploreSearchHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query().Get("q")
-	if q == "" {
-		http.Error(w, "Query is required", http.StatusBadRequest)
+	v := validate.New()
+	v.Check("q", q, validate.Required, validate.MaxLen(200))
+	if !checkValid(w, v) {
 		return
 	}
-	coll := db.MongoDatabase.Collection("news")
-	filter := bson.M{"$or": []bson.M{
-		{"title": bson.M{"$regex": q, "$options": "i"}},
-		{"description": bson.M{"$regex": q, "$options": "i"}},
-		{"category": bson.M{"$regex": q, "$options": "i"}},
-	}}
-	cur, err := coll.Find(context.Background(), filter)
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	results, err := h.repos.News.Search(ctx, q)
 	if err != nil {
-		http.Error(w, "Failed to search news", http.StatusInternalServerError)
-		return
-	}
-	defer cur.Close(context.Background())
-	var results []bson.M
-	if err := cur.All(context.Background(), &results); err != nil {
-		http.Error(w, "Failed to decode search results", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to search news")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -1168,183 +1168,137 @@ func GetExploreSearchHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // --- Bookmark Handlers ---
-func PostAddBookmarkHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
+
+// articleRules checks an article saved for a user. Articles are stored as
+// sent, but the url identifies them and is required.
+func articleRules(article repository.Article) *validate.Validator {
+	v := validate.New()
+	v.Assert(article != nil, "article", "required", "is required")
+	url, _ := article["url"].(string)
+	v.Check("article.url", url, validate.Required, validate.URL, validate.MaxLen(maxURLLen))
+	return v
+}
+func (h *Handlers) PostAddBookmarkHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		User    string      `json:"user"`
-		Article interface{} `json:"article"`
+		User    string             `json:"user"`
+		Article repository.Article `json:"article"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	if req.User == "" || req.Article == nil {
-		http.Error(w, "User and article are required", http.StatusBadRequest)
+	if !checkValid(w, articleRules(req.Article)) {
 		return
 	}
-	coll := db.MongoDatabase.Collection("bookmarks")
-	// Prevent duplicate bookmarks (by user and article.url)
-	var articleUrl string
-	if artMap, ok := req.Article.(map[string]interface{}); ok {
-		if urlVal, ok := artMap["url"].(string); ok {
-			articleUrl = urlVal
-		}
-	}
-	if articleUrl != "" {
-		count, err := coll.CountDocuments(context.Background(), bson.M{"user": req.User, "article.url": articleUrl})
-		if err == nil && count > 0 {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]string{"message": "Already bookmarked"})
-			return
-		}
-	}
-	bookmark := bson.M{
-		"user":      req.User,
-		"article":   req.Article,
-		"createdAt": time.Now(),
+	user, ok := authorizedEmail(w, r, req.User)
+	if !ok {
+		return
 	}
-	_, err := coll.InsertOne(context.Background(), bookmark)
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	// Bookmarks are unique per user and article.url
+	added, err := h.repos.Bookmarks.Add(ctx, user, req.Article)
 	if err != nil {
-		http.Error(w, "Failed to add bookmark", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to add bookmark")
 		return
 	}
+	message := "Bookmark added"
+	if !added {
+		message = "Already bookmarked"
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Bookmark added"})
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
 }
 
-func PostRemoveBookmarkHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
+func (h *Handlers) PostRemoveBookmarkHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		User      string `json:"user"`
 		ArticleId string `json:"articleId"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	v := validate.New()
+	v.Check("articleId", req.ArticleId, validate.Required, validate.MaxLen(maxURLLen))
+	if !checkValid(w, v) {
+		return
+	}
+	user, ok := authorizedEmail(w, r, req.User)
+	if !ok {
 		return
 	}
-	if req.User == "" || req.ArticleId == "" {
-		http.Error(w, "User and articleId are required", http.StatusBadRequest)
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	removed, err := h.repos.Bookmarks.Remove(ctx, user, req.ArticleId)
+	if err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to remove bookmark")
 		return
 	}
-	coll := db.MongoDatabase.Collection("bookmarks")
-	res, err := coll.DeleteOne(context.Background(), bson.M{"user": req.User, "article.url": req.ArticleId})
-	if err != nil || res.DeletedCount == 0 {
-		http.Error(w, "Failed to remove bookmark", http.StatusInternalServerError)
+	if !removed {
+		apierr.Write(w, http.StatusNotFound, apierr.NotFound, "Bookmark not found")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Bookmark removed"})
 }
 
-func GetBookmarksListHandler(w http.ResponseWriter, r *http.Request) {
-	user := r.URL.Query().Get("user")
-	if user == "" {
-		http.Error(w, "User is required", http.StatusBadRequest)
+func (h *Handlers) GetBookmarksListHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authorizedEmail(w, r, r.URL.Query().Get("user"))
+	if !ok {
 		return
 	}
-	coll := db.MongoDatabase.Collection("bookmarks")
-	cur, err := coll.Find(context.Background(), bson.M{"user": user})
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	bookmarks, err := h.repos.Bookmarks.List(ctx, user)
 	if err != nil {
-		http.Error(w, "Failed to fetch bookmarks", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to fetch bookmarks")
 		return
 	}
-	defer cur.Close(context.Background())
-	var bookmarks []bson.M
-	if err := cur.All(context.Background(), &bookmarks); err != nil {
-		http.Error(w, "Failed to decode bookmarks", http.StatusInternalServerError)
-		return
-	}
-	// Only return the article field for each bookmark
-	articles := make([]interface{}, 0, len(bookmarks))
-	for _, bm := range bookmarks {
-		if art, ok := bm["article"]; ok {
-			articles = append(articles, art)
-		}
-	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"bookmarks": articles})
+	json.NewEncoder(w).Encode(map[string]interface{}{"bookmarks": bookmarks})
 }
 
 // --- Viewed News Handlers ---
 
 // POST /viewed-news/add
-func PostViewedNewsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
+func (h *Handlers) PostViewedNewsHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		User    string      `json:"user"`
-		Article interface{} `json:"article"`
+		User    string             `json:"user"`
+		Article repository.Article `json:"article"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	if req.User == "" || req.Article == nil {
-		http.Error(w, "User and article required", http.StatusBadRequest)
+	if !checkValid(w, articleRules(req.Article)) {
 		return
 	}
-	coll := db.MongoDatabase.Collection("viewed_news")
-	// Prevent duplicates: only one entry per user+article.url
-	filter := bson.M{"user": req.User}
-	if art, ok := req.Article.(map[string]interface{}); ok {
-		if url, ok := art["url"]; ok {
-			filter["article.url"] = url
-		}
-	}
-	update := bson.M{
-		"$set": bson.M{
-			"user":     req.User,
-			"article":  req.Article,
-			"viewedAt": time.Now(),
-		},
+	user, ok := authorizedEmail(w, r, req.User)
+	if !ok {
+		return
 	}
-	_, err := coll.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
-	if err != nil {
-		http.Error(w, "Failed to save viewed news", http.StatusInternalServerError)
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	// Only one entry per user and article.url
+	if err := h.repos.ViewedNews.Record(ctx, user, req.Article); err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to save viewed news")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Viewed news saved"})
 }
 
-// GET /viewed-news/list?user=email
-func GetViewedNewsListHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-	user := r.URL.Query().Get("user")
-	if user == "" {
-		http.Error(w, "User required", http.StatusBadRequest)
+// GET /viewed-news/list
+func (h *Handlers) GetViewedNewsListHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := authorizedEmail(w, r, r.URL.Query().Get("user"))
+	if !ok {
 		return
 	}
-	coll := db.MongoDatabase.Collection("viewed_news")
-	cur, err := coll.Find(context.Background(), bson.M{"user": user}, options.Find().SetSort(bson.M{"viewedAt": -1}).SetLimit(20))
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	viewed, err := h.repos.ViewedNews.Recent(ctx, user)
 	if err != nil {
-		http.Error(w, "Failed to fetch viewed news", http.StatusInternalServerError)
-		return
-	}
-	defer cur.Close(context.Background())
-	var results []bson.M
-	if err := cur.All(context.Background(), &results); err != nil {
-		http.Error(w, "Failed to decode viewed news", http.StatusInternalServerError)
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to fetch viewed news")
 		return
 	}
-	// Return only the article objects
-	articles := make([]interface{}, 0, len(results))
-	for _, doc := range results {
-		if art, ok := doc["article"]; ok {
-			articles = append(articles, art)
-		}
-	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"viewed": articles})
+	json.NewEncoder(w).Encode(map[string]interface{}{"viewed": viewed})
 }
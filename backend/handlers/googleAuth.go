@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/accounts"
+	"backend/apierr"
+	"backend/validate"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// Used when the key server sends no Cache-Control max-age
+	defaultJWKSCacheTTL = time.Hour
+	// Unknown key IDs trigger a refetch at most this often
+	jwksMinRefreshInterval = time.Minute
+)
+
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+var errGoogleNotConfigured = errors.New("google sign-in is not configured")
+
+// googleClaims are the ID token claims the sign-in handlers rely on.
+type googleClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// jwksCache holds Google's RSA signing keys by key ID and refreshes them when
+// they expire or when a token names a key it has not seen yet. Lookups share
+// a read lock; one refresh runs at a time, outside the lock, and concurrent
+// lookups that need it wait for its result.
+type jwksCache struct {
+	url     string
+	client  *http.Client
+	refresh singleflight.Group
+
+	mu        sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	expiresAt time.Time
+	fetchedAt time.Time
+}
+
+func newJWKSCache(url string) *jwksCache {
+	return &jwksCache{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (c *jwksCache) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	key, fresh, recent := c.cached(kid)
+	if key != nil && fresh {
+		return key, nil
+	}
+	if fresh && recent {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	// The fetch outlives a caller that gives up, since others may be
+	// waiting on it; the client's timeout bounds it
+	result := c.refresh.DoChan("jwks", func() (any, error) {
+		return nil, c.fetch(context.WithoutCancel(ctx))
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+	}
+	if key, _, _ := c.cached(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// cached returns the key for kid if there is one, whether the key set has
+// not expired, and whether it was fetched too recently to fetch again.
+func (c *jwksCache) cached(kid string) (key *rsa.PublicKey, fresh, recent bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := time.Now()
+	return c.keys[kid], now.Before(c.expiresAt), now.Sub(c.fetchedAt) < jwksMinRefreshInterval
+}
+
+func (c *jwksCache) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching JWKS: %s", resp.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decoding JWKS: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || k.Kid == "" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys = keys
+	c.fetchedAt = now
+	c.expiresAt = now.Add(cacheMaxAge(resp.Header.Get("Cache-Control")))
+	return nil
+}
+
+func cacheMaxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(directive), "max-age=")
+		if !ok {
+			continue
+		}
+		if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return defaultJWKSCacheTTL
+}
+
+// googleVerifier checks ID tokens issued to one of our OAuth client IDs.
+type googleVerifier struct {
+	clientIDs []string
+	keys      *jwksCache
+}
+
+// verify checks the token's signature, audience, issuer and expiry and
+// returns its claims. Only tokens for a verified email are accepted.
+func (v *googleVerifier) verify(ctx context.Context, idToken string) (*googleClaims, error) {
+	if len(v.clientIDs) == 0 {
+		return nil, errGoogleNotConfigured
+	}
+	claims := &googleClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no key ID")
+		}
+		return v.keys.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(30*time.Second),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(googleIssuers, claims.Issuer) {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if !slices.ContainsFunc(claims.Audience, func(aud string) bool {
+		return slices.Contains(v.clientIDs, aud)
+	}) {
+		return nil, errors.New("token was not issued for this app")
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errors.New("google account email is not verified")
+	}
+	claims.Email = accounts.NormalizeEmail(claims.Email)
+	return claims, nil
+}
+
+// verifyGoogleRequest verifies the ID token of a Google sign-in or sign-up
+// request and writes the error response when it is not acceptable.
+func (h *Handlers) verifyGoogleRequest(ctx context.Context, w http.ResponseWriter, idToken string) (*googleClaims, bool) {
+	v := validate.New()
+	v.Check("idToken", idToken, validate.Required)
+	if !checkValid(w, v) {
+		return nil, false
+	}
+	claims, err := h.google.verify(ctx, idToken)
+	if err != nil {
+		if errors.Is(err, errGoogleNotConfigured) {
+			apierr.Write(w, http.StatusServiceUnavailable, apierr.GoogleNotConfigured, "Google sign-in is not configured")
+			return nil, false
+		}
+		apierr.Write(w, http.StatusUnauthorized, apierr.GoogleTokenInvalid, "Invalid Google ID token")
+		return nil, false
+	}
+	return claims, true
+}
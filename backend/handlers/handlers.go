@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+
+	"backend/apierr"
+	"backend/config"
+	"backend/mailer"
+	"backend/news"
+	"backend/otp"
+	"backend/ratelimit"
+	"backend/repository"
+	"backend/tracing"
+	"backend/validate"
+)
+
+// Handlers serves the HTTP API. Everything the handlers depend on is handed
+// to New rather than read from the environment.
+type Handlers struct {
+	cfg          *config.Config
+	jwtSecret    []byte
+	repos        *repository.Repositories
+	otps         otp.Store
+	limits       ratelimit.Store
+	mail         mailer.Mailer
+	news         news.Provider
+	google       *googleVerifier
+	geminiClient *http.Client
+
+	// Set once shutdown starts so /readyz takes the instance out of rotation
+	draining atomic.Bool
+}
+
+func New(cfg *config.Config, repos *repository.Repositories, otps otp.Store, limits ratelimit.Store, mail mailer.Mailer, provider news.Provider) *Handlers {
+	return &Handlers{
+		cfg:          cfg,
+		jwtSecret:    []byte(cfg.JWT.Secret),
+		repos:        repos,
+		otps:         otps,
+		limits:       limits,
+		mail:         mail,
+		news:         provider,
+		google:       &googleVerifier{clientIDs: cfg.Google.ClientIDs, keys: newJWKSCache(cfg.Google.JWKSURL)},
+		geminiClient: tracing.HTTPClient("gemini", cfg.Gemini.Timeout),
+	}
+}
+
+// dbContext bounds a request's database work by MONGO_OPERATION_TIMEOUT.
+func (h *Handlers) dbContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), h.cfg.Mongo.OperationTimeout)
+}
+
+// decodeJSON reads the request body into dst, answering 413 when it is over
+// SERVER_MAX_BODY_BYTES and 400 when it is not a single JSON value. Fields
+// dst does not declare, and values of the wrong type, are rejected as
+// validation failures naming the field.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err == nil {
+		// Anything after the value means the client sent something else
+		if dec.Decode(&struct{}{}) != io.EOF {
+			err = errors.New("trailing data")
+		}
+	}
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		apierr.Write(w, http.StatusRequestEntityTooLarge, apierr.BodyTooLarge, fmt.Sprintf("Request body must be at most %d bytes", tooLarge.Limit))
+		return false
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		writeInvalid(w, validate.Errors{{Field: typeErr.Field, Rule: "type", Message: "must be a " + jsonType(typeErr.Type.Kind())}})
+		return false
+	}
+	// encoding/json has no typed error for unknown fields
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		writeInvalid(w, validate.Errors{{Field: strings.Trim(field, `"`), Rule: "unknown", Message: "is not a known field"}})
+		return false
+	}
+	apierr.Write(w, http.StatusBadRequest, apierr.InvalidJSON, "Invalid JSON")
+	return false
+}
+
+// jsonType names a Go kind the way a client sees it in JSON.
+func jsonType(k reflect.Kind) string {
+	switch k {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return "number"
+	}
+}
+
+// checkValid answers 400 with every failed field when v recorded any, and
+// reports whether the request may go on.
+func checkValid(w http.ResponseWriter, v *validate.Validator) bool {
+	err := v.Err()
+	if err == nil {
+		return true
+	}
+	writeInvalid(w, err.(validate.Errors))
+	return false
+}
+
+// writeInvalid answers 400 VALIDATION_FAILED listing the fields at fault.
+func writeInvalid(w http.ResponseWriter, errs validate.Errors) {
+	err := apierr.New(http.StatusBadRequest, apierr.ValidationFailed, "Some fields are invalid: "+errs.Error())
+	apierr.WriteError(w, err.WithDetails(map[string]any{"fields": errs}))
+}
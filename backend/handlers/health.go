@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// readyzPingTimeout bounds the database ping so a wedged database fails the
+// probe instead of hanging it.
+const readyzPingTimeout = 2 * time.Second
+
+// StartDraining makes /readyz fail so load balancers stop sending traffic
+// while in-flight requests finish.
+func (h *Handlers) StartDraining() {
+	h.draining.Store(true)
+}
+
+// GET /healthz reports that the process is up and serving.
+func (h *Handlers) GetHealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// GET /readyz reports whether this instance should receive traffic: its
+// config is loaded, MongoDB answers a ping and it is not shutting down.
+func (h *Handlers) GetReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"config": "ok", "mongo": "ok"}
+	ready := true
+	if h.cfg == nil {
+		checks["config"] = "not loaded"
+		ready = false
+	}
+	if h.repos.Ping != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), readyzPingTimeout)
+		err := h.repos.Ping(ctx)
+		cancel()
+		if err != nil {
+			checks["mongo"] = "ping failed"
+			ready = false
+		}
+	}
+	status := "ok"
+	if h.draining.Load() {
+		status = "draining"
+		ready = false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ready {
+		if status == "ok" {
+			status = "unavailable"
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": checks})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/config"
+	"backend/repository"
+)
+
+func readyz(t *testing.T, h *Handlers) (int, map[string]any, http.Header) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.GetReadyzHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("readyz body %q: %v", rec.Body, err)
+	}
+	return rec.Code, body, rec.Header()
+}
+
+func TestReadyz(t *testing.T) {
+	repos := repository.NewMemory()
+	h := &Handlers{cfg: &config.Config{}, repos: repos}
+
+	if status, body, _ := readyz(t, h); status != http.StatusOK || body["status"] != "ok" {
+		t.Fatalf("readyz = %d %v", status, body)
+	}
+
+	repos.Ping = func(context.Context) error { return errors.New("no primary") }
+	status, body, _ := readyz(t, h)
+	checks, _ := body["checks"].(map[string]any)
+	if status != http.StatusServiceUnavailable || body["status"] != "unavailable" || checks["mongo"] != "ping failed" {
+		t.Fatalf("readyz with a failing database = %d %v", status, body)
+	}
+	repos.Ping = nil
+
+	h.StartDraining()
+	status, body, header := readyz(t, h)
+	if status != http.StatusServiceUnavailable || body["status"] != "draining" || header.Get("Cache-Control") != "no-store" {
+		t.Fatalf("readyz while draining = %d %v %v", status, body, header)
+	}
+
+	// Liveness is unaffected: the process is still serving
+	rec := httptest.NewRecorder()
+	h.GetHealthzHandler(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("healthz while draining = %d", rec.Code)
+	}
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"backend/logging"
+	"backend/mailer"
+)
+
+func (h *Handlers) sendOTPEmail(ctx context.Context, to string, template mailer.Template, code string) error {
+	return mailer.SendTemplate(ctx, h.mail, to, template, mailer.OTPData{Code: code, ValidMinutes: int(h.cfg.OTP.TTL.Minutes())})
+}
+
+// sendWelcomeEmail greets a new account in the background; signup has
+// already succeeded, so a delivery failure is only logged.
+func (h *Handlers) sendWelcomeEmail(to, username string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := mailer.SendTemplate(ctx, h.mail, to, mailer.TemplateWelcome, mailer.WelcomeData{Username: username}); err != nil {
+			slog.Error("failed to send welcome email", logging.Err(err))
+		}
+	}()
+}
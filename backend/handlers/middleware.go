@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/apierr"
+	"backend/config"
+	"backend/logging"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Request IDs from a proxy are kept when they look like IDs, so the proxy's
+// logs and ours can be joined; anything else is replaced.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Middleware wraps a handler with behaviour shared across routes.
+type Middleware func(http.Handler) http.Handler
+
+// Chain applies middleware so that the first one listed runs first.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// statusRecorder remembers the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Recover turns a panicking handler into a 500 instead of a dropped
+// connection, and logs the stack.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// The server deliberately aborts with this to cut a response short
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			slog.ErrorContext(r.Context(), "panic serving request",
+				"method", r.Method, "path", r.URL.Path, "panic", err, "stack", string(debug.Stack()))
+			apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestID gives every request an ID, taken from X-Request-ID when the
+// caller sent a usable one. It is stored in the context for logging and
+// echoed in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LogRequests writes an access log line per request once it has been
+// served. The query string is left out because it can carry emails and
+// article URLs.
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int("bytes", rec.bytes),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote", clientIP(r)),
+		)
+	})
+}
+
+// CORS applies cfg to browser requests, which carry an Origin header.
+// Requests from origins not on the list are refused with 403 rather than
+// served without CORS headers, and preflight requests are answered here,
+// before routing, rate limits or auth.
+func CORS(cfg config.CORSConfig) Middleware {
+	anyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	allowed := func(origin string) bool {
+		return anyOrigin || slices.Contains(cfg.AllowedOrigins, strings.ToLower(origin))
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			// Caches must not hand one origin's answer to another
+			w.Header().Add("Vary", "Origin")
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !allowed(origin) {
+				apierr.Write(w, http.StatusForbidden, apierr.Forbidden, "Origin not allowed")
+				return
+			}
+			if anyOrigin && !cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				w.Header().Set("Access-Control-Expose-Headers", "Retry-After, "+requestIDHeader)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !slices.Contains(cfg.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) {
+				apierr.Write(w, http.StatusForbidden, apierr.Forbidden, "Method not allowed for cross-origin requests")
+				return
+			}
+			for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				if h = strings.TrimSpace(h); h != "" && !slices.Contains(cfg.AllowedHeaders, http.CanonicalHeaderKey(h)) {
+					apierr.Write(w, http.StatusForbidden, apierr.Forbidden, "Header "+h+" not allowed for cross-origin requests")
+					return
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// LimitBody caps request bodies at n bytes; decoding a larger body fails.
+// A limit of 0 or less disables the cap.
+func LimitBody(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		if n <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"backend/logging"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+	}))
+	serve := func(id string) string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/", nil)
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("X-Request-ID"); got != seen {
+			t.Fatalf("response has request ID %q, handler saw %q", got, seen)
+		}
+		return seen
+	}
+
+	if got := serve("lb-4f2a:77"); got != "lb-4f2a:77" {
+		t.Errorf("caller's request ID became %q", got)
+	}
+	generated := regexp.MustCompile(`^[0-9a-f]{24}$`)
+	ids := map[string]bool{}
+	for _, sent := range []string{"", "not an id!", strings.Repeat("a", 129)} {
+		got := serve(sent)
+		if !generated.MatchString(got) || ids[got] {
+			t.Errorf("request with ID %q got %q, want a fresh one", sent, got)
+		}
+		ids[got] = true
+	}
+}
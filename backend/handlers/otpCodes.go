@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/apierr"
+	"backend/otp"
+)
+
+// generateOTP returns a uniformly random numeric code from crypto/rand.
+func (h *Handlers) generateOTP() (string, error) {
+	length := h.cfg.OTP.Length
+	var b strings.Builder
+	for range length {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(byte('0' + n.Int64()))
+	}
+	return b.String(), nil
+}
+
+// saveOTP stores a new code for email, replacing any earlier one.
+func (h *Handlers) saveOTP(ctx context.Context, rec otp.Record, code string) error {
+	now := time.Now()
+	rec.CodeHash = otp.HashCode(h.jwtSecret, rec.Purpose, rec.Email, code)
+	rec.Attempts = 0
+	rec.CreatedAt = now
+	rec.ExpiresAt = now.Add(h.cfg.OTP.TTL)
+	return h.otps.Put(ctx, rec)
+}
+
+// issueResetToken replaces a verified password reset code with a single-use
+// token that authorises one call to /reset-password.
+func (h *Handlers) issueResetToken(ctx context.Context, email string) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	err = h.otps.Put(ctx, otp.Record{
+		Purpose:   otp.PurposeResetToken,
+		Email:     email,
+		CodeHash:  otp.HashCode(h.jwtSecret, otp.PurposeResetToken, email, token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(h.cfg.OTP.ResetTokenTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeResetToken spends a reset token, writing the error response when it
+// is unknown, already used or expired.
+func (h *Handlers) consumeResetToken(ctx context.Context, w http.ResponseWriter, email, token string) bool {
+	_, err := h.otps.Consume(ctx, otp.PurposeResetToken, email, otp.HashCode(h.jwtSecret, otp.PurposeResetToken, email, token))
+	switch {
+	case errors.Is(err, otp.ErrNotFound):
+		apierr.Write(w, http.StatusUnauthorized, apierr.ResetTokenInvalid, "Invalid or already used reset token")
+		return false
+	case errors.Is(err, otp.ErrExpired):
+		apierr.Write(w, http.StatusUnauthorized, apierr.ResetTokenExpired, "Reset token expired, please verify a new OTP")
+		return false
+	case err != nil:
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to check reset token")
+		return false
+	}
+	return true
+}
+
+// checkOTP returns the record when code is the outstanding code for email,
+// and otherwise writes the error response. Every call uses up one attempt.
+func (h *Handlers) checkOTP(ctx context.Context, w http.ResponseWriter, purpose otp.Purpose, email, code string) (*otp.Record, bool) {
+	rec, err := h.otps.Attempt(ctx, purpose, email, h.cfg.OTP.MaxAttempts)
+	switch {
+	case errors.Is(err, otp.ErrNotFound):
+		apierr.Write(w, http.StatusNotFound, apierr.OTPNotFound, "No OTP requested for this email")
+		return nil, false
+	case errors.Is(err, otp.ErrExpired):
+		apierr.Write(w, http.StatusUnauthorized, apierr.OTPExpired, "OTP expired")
+		return nil, false
+	case errors.Is(err, otp.ErrTooManyAttempts):
+		apierr.Write(w, http.StatusTooManyRequests, apierr.OTPTooManyAttempts, "Too many incorrect attempts, please request a new OTP")
+		return nil, false
+	case err != nil:
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to look up OTP")
+		return nil, false
+	}
+	if !rec.Matches(h.jwtSecret, code) {
+		if rec.Attempts >= h.cfg.OTP.MaxAttempts {
+			h.otps.Delete(ctx, purpose, email)
+			apierr.Write(w, http.StatusTooManyRequests, apierr.OTPTooManyAttempts, "Too many incorrect attempts, please request a new OTP")
+			return nil, false
+		}
+		apierr.Write(w, http.StatusUnauthorized, apierr.OTPInvalid, "Invalid OTP")
+		return nil, false
+	}
+	return rec, true
+}
+
+// allowOTPRequest enforces the resend cooldown and the per-email and per-IP
+// limits before a new code is sent, writing a 429 when one is exceeded.
+func (h *Handlers) allowOTPRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, purpose otp.Purpose, email string) bool {
+	policy := h.cfg.OTP
+	if !h.allowOTPHit(ctx, w, "ip:"+clientIP(r), policy.IPLimit, "Too many OTP requests from this network, please try again later") {
+		return false
+	}
+	rec, err := h.otps.Get(ctx, purpose, email)
+	if err == nil {
+		if wait := policy.ResendCooldown - time.Since(rec.CreatedAt); wait > 0 {
+			writeTooManyRequests(w, wait, "Please wait before requesting another OTP")
+			return false
+		}
+	} else if !errors.Is(err, otp.ErrNotFound) && !errors.Is(err, otp.ErrExpired) {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to look up OTP")
+		return false
+	}
+	return h.allowOTPHit(ctx, w, otp.EmailCounterKey(purpose, email), policy.EmailLimit, "Too many OTPs requested for this email, please try again later")
+}
+
+// allowOTPVerify limits how many codes one IP can try across all emails.
+func (h *Handlers) allowOTPVerify(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	policy := h.cfg.OTP
+	// Each code allows MaxAttempts tries, so scale the request budget to match
+	return h.allowOTPHit(ctx, w, "verify-ip:"+clientIP(r), policy.IPLimit*policy.MaxAttempts, "Too many OTP attempts from this network, please try again later")
+}
+
+func (h *Handlers) allowOTPHit(ctx context.Context, w http.ResponseWriter, key string, limit int, message string) bool {
+	count, resetAt, err := h.otps.Hit(ctx, key, h.cfg.OTP.LimitWindow)
+	if err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to check OTP limits")
+		return false
+	}
+	if count > limit {
+		writeTooManyRequests(w, time.Until(resetAt), message)
+		return false
+	}
+	return true
+}
+
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	secs := int(retryAfter.Round(time.Second).Seconds())
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	apierr.Write(w, http.StatusTooManyRequests, apierr.RateLimited, message)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"backend/config"
+	"backend/logging"
+	"backend/metrics"
+	"backend/ratelimit"
+)
+
+type clientIPKey struct{}
+
+// ClientIP works out which address a request came from and stores it for
+// clientIP. X-Forwarded-For is only believed when the connecting peer is a
+// trusted proxy; it is then read from the right, skipping further trusted
+// proxies, so a client cannot pick its own address by sending the header.
+func ClientIP(trusted []netip.Prefix) Middleware {
+	isTrusted := func(addr netip.Addr) bool {
+		addr = addr.Unmap()
+		for _, p := range trusted {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := peerIP(r)
+			if addr, err := netip.ParseAddr(ip); err == nil && isTrusted(addr) {
+				hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+					if err != nil {
+						break
+					}
+					ip = hop.Unmap().String()
+					if !isTrusted(hop) {
+						break
+					}
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+		})
+	}
+}
+
+// clientIP is the address ClientIP settled on, or the connecting peer's for
+// requests that did not pass through it.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// peerIP is the address of the connecting peer.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimit meters requests under the named policy, answering 429 with
+// Retry-After once a client's bucket is empty. Buckets are per client IP,
+// or per user when perUser is set and the request carries a valid access
+// token. A store failure lets the request through rather than take the API
+// down with it.
+func (h *Handlers) rateLimit(name string, p config.RatePolicy, perUser bool) Middleware {
+	if p.Limit == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	policy := ratelimit.Policy{Name: name, Rate: float64(p.Limit) / p.Per.Seconds(), Burst: p.Burst}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "ip:" + clientIP(r)
+			if perUser {
+				if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+					// Checking the session is left to RequireAuth; a signed
+					// token is enough to tell users apart
+					if user, err := h.parseJWT(strings.TrimSpace(token)); err == nil {
+						key = "user:" + user.Email
+					}
+				}
+			}
+			ctx, cancel := h.dbContext(r)
+			res, err := h.limits.Take(ctx, key, policy)
+			cancel()
+			if err != nil {
+				slog.WarnContext(r.Context(), "rate limit check failed, allowing request", "policy", name, logging.Err(err))
+			} else if !res.Allowed {
+				metrics.ObserveRateLimited(name)
+				writeTooManyRequests(w, res.RetryAfter, "Too many requests, please try again later")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/apierr"
+	"backend/metrics"
+	"backend/tracing"
+)
+
+// NewRouter registers every route on a fresh mux and wraps it in the shared
+// middleware. Routes are matched on method as well as path, so handlers no
+// longer check r.Method themselves; a wrong method gets a 405 from the mux.
+func NewRouter(h *Handlers) http.Handler {
+	mux := http.NewServeMux()
+	auth := func(f http.HandlerFunc) http.Handler { return h.RequireAuth(f) }
+	// Limits run before auth so floods are turned away before the session
+	// lookup; see config.RateLimitConfig for the policies.
+	limits := h.cfg.RateLimit
+	authLimit := h.rateLimit("auth", limits.Auth, false)
+	newsLimit := h.rateLimit("news", limits.News, true)
+	summaryLimit := h.rateLimit("summary", limits.Summary, true)
+	apiLimit := h.rateLimit("api", limits.API, true)
+
+	// Probes and metrics are never limited
+	mux.HandleFunc("GET /{$}", h.HelloHandler)
+	mux.HandleFunc("GET /healthz", h.GetHealthzHandler)
+	mux.HandleFunc("GET /readyz", h.GetReadyzHandler)
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	// Sign-up, sign-in and password reset
+	mux.Handle("POST /signup", authLimit(http.HandlerFunc(h.PostManualSignUpHandler)))
+	mux.Handle("POST /google-signup", authLimit(http.HandlerFunc(h.PostGoogleSignUpHandler)))
+	mux.Handle("POST /signin", authLimit(http.HandlerFunc(h.PostManualSignInHandler)))
+	mux.Handle("POST /google-signin", authLimit(http.HandlerFunc(h.PostGoogleSignInHandler)))
+	mux.Handle("POST /request-otp", authLimit(http.HandlerFunc(h.PostRequestOTPHandler)))
+	mux.Handle("POST /verify-otp", authLimit(http.HandlerFunc(h.PostVerifyOTPHandler)))
+	mux.Handle("POST /request-password-reset-otp", authLimit(http.HandlerFunc(h.PostRequestPasswordResetOTPHandler)))
+	mux.Handle("POST /verify-password-reset-otp", authLimit(http.HandlerFunc(h.PostVerifyPasswordResetOTPHandler)))
+	mux.Handle("POST /reset-password", authLimit(http.HandlerFunc(h.PostResetPasswordHandler)))
+
+	// Sessions
+	mux.Handle("POST /auth/refresh", authLimit(http.HandlerFunc(h.PostRefreshTokenHandler)))
+	mux.Handle("POST /auth/logout", apiLimit(auth(h.PostLogoutHandler)))
+	mux.Handle("POST /auth/logout-all", apiLimit(auth(h.PostLogoutAllHandler)))
+
+	// Profile
+	mux.Handle("GET /get-user-details", apiLimit(auth(h.GetUserDetailsHandler)))
+	mux.Handle("POST /update-user-details", apiLimit(auth(h.PostUpdateUserDetailsHandler)))
+
+	// News; each request spends provider or Gemini quota
+	mux.Handle("GET /news", newsLimit(http.HandlerFunc(h.GetNewsHandler)))
+	mux.Handle("GET /news/article", newsLimit(http.HandlerFunc(h.GetNewsArticleByURLHandler)))
+	mux.Handle("GET /news/sources", newsLimit(http.HandlerFunc(h.GetNewsSourcesHandler)))
+	mux.Handle("POST /news/summary", summaryLimit(http.HandlerFunc(h.PostNewsSummaryHandler)))
+
+	// Explore
+	mux.Handle("GET /explore/topics", apiLimit(http.HandlerFunc(h.GetExploreTopicsHandler)))
+	mux.Handle("GET /explore/topics/{topic}/news", apiLimit(http.HandlerFunc(h.GetExploreNewsByTopicHandler)))
+	mux.Handle("GET /explore/news", apiLimit(http.HandlerFunc(h.GetExploreNewsByTopicHandler)))
+	mux.Handle("GET /explore/trending", apiLimit(http.HandlerFunc(h.GetExploreTrendingHandler)))
+	mux.Handle("GET /explore/search", apiLimit(http.HandlerFunc(h.GetExploreSearchHandler)))
+
+	// Bookmarks
+	mux.Handle("POST /bookmarks/add", apiLimit(auth(h.PostAddBookmarkHandler)))
+	mux.Handle("POST /bookmarks/remove", apiLimit(auth(h.PostRemoveBookmarkHandler)))
+	mux.Handle("GET /bookmarks/list", apiLimit(auth(h.GetBookmarksListHandler)))
+
+	// Viewed news
+	mux.Handle("POST /viewed-news/add", apiLimit(auth(h.PostViewedNewsHandler)))
+	mux.Handle("GET /viewed-news/list", apiLimit(auth(h.GetViewedNewsListHandler)))
+
+	return Chain(routeErrors(mux),
+		RequestID,
+		ClientIP(h.cfg.RateLimit.TrustedProxies),
+		tracing.Middleware(func(r *http.Request) string {
+			if _, pattern := mux.Handler(r); pattern != "" {
+				return pattern
+			}
+			return r.Method + " unmatched"
+		}),
+		LogRequests,
+		Recover,
+		instrument(mux),
+		CORS(h.cfg.CORS),
+		LimitBody(int64(h.cfg.Server.MaxBodyBytes)),
+	)
+}
+
+// routeErrors answers requests that match no route with the JSON error
+// envelope rather than the mux's plain-text 404 and 405 pages.
+func routeErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		// Let the mux decide between 404 and 405 and work out the Allow header
+		probe := &probeWriter{header: http.Header{}}
+		mux.ServeHTTP(probe, r)
+		if probe.status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", probe.header.Get("Allow"))
+			apierr.Write(w, http.StatusMethodNotAllowed, apierr.MethodNotAllowed, "Method not allowed")
+			return
+		}
+		apierr.Write(w, http.StatusNotFound, apierr.RouteNotFound, "No such endpoint")
+	})
+}
+
+// probeWriter records the status and headers of a response and drops its body.
+type probeWriter struct {
+	header http.Header
+	status int
+}
+
+func (p *probeWriter) Header() http.Header         { return p.header }
+func (p *probeWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (p *probeWriter) WriteHeader(status int)      { p.status = status }
+
+// instrument records request metrics under the route pattern that will
+// serve the request; requests matching no route share one label.
+func instrument(mux *http.ServeMux) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, route := mux.Handler(r)
+			if route == "" {
+				route = "unmatched"
+			} else if _, path, ok := strings.Cut(route, " "); ok {
+				route = path // the method is its own label
+			}
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			metrics.ObserveHTTP(route, r.Method, rec.status, time.Since(start))
+		})
+	}
+}
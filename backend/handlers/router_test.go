@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/metrics"
+)
+
+// routeCounts returns newsly_http_requests_total by route label.
+func routeCounts(t *testing.T) map[string]float64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]float64{}
+	for _, f := range families {
+		if f.GetName() != "newsly_http_requests_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "route" {
+					counts[l.GetValue()] += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return counts
+}
+
+func TestInstrumentLabelsByPattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /explore/topics/{topic}/news", func(http.ResponseWriter, *http.Request) {})
+	handler := instrument(mux)(mux)
+
+	before := routeCounts(t)
+	for _, path := range []string{"/explore/topics/sport/news", "/explore/topics/science/news", "/no/such/page"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	after := routeCounts(t)
+
+	if got := after["/explore/topics/{topic}/news"] - before["/explore/topics/{topic}/news"]; got != 2 {
+		t.Errorf("topic pattern counted %v requests, want 2", got)
+	}
+	if got := after["unmatched"] - before["unmatched"]; got != 1 {
+		t.Errorf("unmatched counted %v requests, want 1", got)
+	}
+	for route := range after {
+		if route == "/explore/topics/sport/news" || route == "/explore/topics/science/news" {
+			t.Errorf("request path %q was used as a route label", route)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/apierr"
+	"backend/repository"
+	"backend/validate"
+)
+
+var errSessionInvalid = errors.New("session is invalid, expired or revoked")
+
+type tokenPair struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// startSession creates a session for a freshly authenticated user and
+// returns its first access/refresh token pair.
+func (h *Handlers) startSession(ctx context.Context, r *http.Request, email, username string) (tokenPair, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	now := time.Now()
+	s := repository.Session{
+		ID:          id,
+		Email:       email,
+		Username:    username,
+		RefreshHash: hashToken(secret),
+		UserAgent:   r.UserAgent(),
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(h.cfg.JWT.RefreshTTL),
+	}
+	if err := h.repos.Sessions.Create(ctx, s); err != nil {
+		return tokenPair{}, err
+	}
+	access, err := h.generateJWT(email, username, id)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	return tokenPair{AccessToken: access, RefreshToken: id + "." + secret}, nil
+}
+
+// rotateSession exchanges a refresh token for a new pair. A token that does
+// not match the session's current hash is treated as reuse and revokes it.
+func (h *Handlers) rotateSession(ctx context.Context, refreshToken string) (tokenPair, error) {
+	id, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || id == "" || secret == "" {
+		return tokenPair{}, errSessionInvalid
+	}
+	s, err := h.repos.Sessions.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			return tokenPair{}, errSessionInvalid
+		}
+		return tokenPair{}, err
+	}
+	if s.RevokedAt != nil || time.Now().After(s.ExpiresAt) {
+		return tokenPair{}, errSessionInvalid
+	}
+	oldHash := hashToken(secret)
+	if subtle.ConstantTimeCompare([]byte(oldHash), []byte(s.RefreshHash)) != 1 {
+		h.repos.Sessions.Revoke(ctx, id)
+		return tokenPair{}, errSessionInvalid
+	}
+
+	newSecret, err := randomToken(32)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	// Swap on the old hash so two concurrent refreshes cannot both win
+	rotated, err := h.repos.Sessions.Rotate(ctx, id, oldHash, hashToken(newSecret))
+	if err != nil {
+		return tokenPair{}, err
+	}
+	if !rotated {
+		h.repos.Sessions.Revoke(ctx, id)
+		return tokenPair{}, errSessionInvalid
+	}
+	access, err := h.generateJWT(s.Email, s.Username, id)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	return tokenPair{AccessToken: access, RefreshToken: id + "." + newSecret}, nil
+}
+
+// writeSignedIn sends the common response for every successful sign-in.
+func (h *Handlers) writeSignedIn(w http.ResponseWriter, message, email, username string, pair tokenPair) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      message,
+		"token":        pair.AccessToken,
+		"refreshToken": pair.RefreshToken,
+		"expiresIn":    int(h.cfg.JWT.AccessTTL.Seconds()),
+		"email":        email,
+		"username":     username,
+	})
+}
+
+// POST /auth/refresh
+func (h *Handlers) PostRefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if !decodeJSON(w, r, &data) {
+		return
+	}
+	v := validate.New()
+	v.Check("refreshToken", data.RefreshToken, validate.Required)
+	if !checkValid(w, v) {
+		return
+	}
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	pair, err := h.rotateSession(ctx, data.RefreshToken)
+	if err != nil {
+		if errors.Is(err, errSessionInvalid) {
+			apierr.Write(w, http.StatusUnauthorized, apierr.InvalidRefreshToken, "Invalid or expired refresh token")
+			return
+		}
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to refresh session")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":        pair.AccessToken,
+		"refreshToken": pair.RefreshToken,
+		"expiresIn":    int(h.cfg.JWT.AccessTTL.Seconds()),
+	})
+}
+
+// POST /auth/logout
+func (h *Handlers) PostLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := UserFromContext(r.Context())
+	if !ok {
+		apierr.Write(w, http.StatusUnauthorized, apierr.Unauthenticated, "Authentication required")
+		return
+	}
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	if err := h.repos.Sessions.Revoke(ctx, user.SessionID); err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to log out")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out"})
+}
+
+// POST /auth/logout-all
+func (h *Handlers) PostLogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := UserFromContext(r.Context())
+	if !ok {
+		apierr.Write(w, http.StatusUnauthorized, apierr.Unauthenticated, "Authentication required")
+		return
+	}
+	ctx, cancel := h.dbContext(r)
+	defer cancel()
+	if err := h.repos.Sessions.RevokeAll(ctx, user.Email); err != nil {
+		apierr.Write(w, http.StatusInternalServerError, apierr.Internal, "Failed to log out")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out from all devices"})
+}
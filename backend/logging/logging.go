@@ -0,0 +1,73 @@
+// Package logging sets up the structured logger and carries the request ID
+// through contexts so every line logged for a request can be correlated.
+package logging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the request's ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID stored by WithRequestID, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// New returns a logger writing text or JSON at the given level. Records
+// logged with a request context get request_id, trace_id and span_id
+// attributes.
+func New(w io.Writer, level slog.Level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(contextHandler{h})
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Err is the attribute for an error. Errors from net/http carry the request
+// URL, which for NewsAPI and Gemini includes the API key, so only the
+// operation and underlying cause are kept.
+func Err(err error) slog.Attr {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return slog.String("error", urlErr.Op+": "+urlErr.Err.Error())
+	}
+	return slog.Any("error", err)
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// CaptureMailer keeps sent messages in memory for tests to inspect.
+type CaptureMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewCaptureMailer() *CaptureMailer {
+	return &CaptureMailer{}
+}
+
+func (c *CaptureMailer) Send(ctx context.Context, msg Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of everything sent so far.
+func (c *CaptureMailer) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Message(nil), c.messages...)
+}
+
+// Last returns the most recent message sent to the address.
+func (c *CaptureMailer) Last(to string) (Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.messages) - 1; i >= 0; i-- {
+		if c.messages[i].To == to {
+			return c.messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+// Reset forgets all captured messages.
+func (c *CaptureMailer) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = nil
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// LogMailer is the development backend. With a directory it writes each
+// message there as a file; without one it logs the plain-text body so codes
+// can be read from the server output.
+type LogMailer struct {
+	dir    string
+	logger *slog.Logger
+}
+
+// NewLogMailer writes messages to dir, or logs them when dir is empty. A nil
+// logger uses slog's default.
+func NewLogMailer(dir string, logger *slog.Logger) *LogMailer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &LogMailer{dir: dir, logger: logger}
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9@._-]+`)
+
+func (l *LogMailer) Send(ctx context.Context, msg Message) error {
+	if l.dir == "" {
+		l.logger.InfoContext(ctx, "mail", "to", msg.To, "subject", msg.Subject, "text", msg.Text)
+		return nil
+	}
+	if err := os.MkdirAll(l.dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), unsafeFileChars.ReplaceAllString(msg.To, "_"))
+	path := filepath.Join(l.dir, name)
+	body := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n\n--- HTML ---\n%s\n", msg.To, msg.Subject, msg.Text, msg.HTML)
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		return err
+	}
+	l.logger.InfoContext(ctx, "mail written", "to", msg.To, "subject", msg.Subject, "path", path)
+	return nil
+}
@@ -0,0 +1,53 @@
+// Package mailer sends transactional email rendered from named templates
+// through a pluggable backend.
+package mailer
+
+import (
+	"context"
+	"fmt"
+
+	"backend/config"
+)
+
+// Message is a rendered email with plain-text and HTML bodies.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SendTemplate renders the named template for one recipient and sends it.
+func SendTemplate(ctx context.Context, m Mailer, to string, name Template, data any) error {
+	msg, err := Render(name, to, data)
+	if err != nil {
+		return err
+	}
+	return m.Send(ctx, msg)
+}
+
+// FromConfig builds the backend selected by cfg.MailBackend: SMTP, the log
+// backend writing to MAIL_DIR, or an in-memory capture.
+func FromConfig(cfg *config.Config) (Mailer, error) {
+	switch backend := cfg.MailBackend(); backend {
+	case "smtp":
+		return NewSMTPMailer(SMTPConfig{
+			Host:     cfg.Mail.SMTP.Host,
+			Port:     cfg.Mail.SMTP.Port,
+			Username: cfg.Mail.SMTP.Username,
+			Password: cfg.Mail.SMTP.Password,
+			From:     cfg.Mail.From,
+		})
+	case "log":
+		return NewLogMailer(cfg.Mail.Dir, nil), nil
+	case "capture":
+		return NewCaptureMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_BACKEND %q (want smtp, log or capture)", backend)
+	}
+}
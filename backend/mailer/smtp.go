@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+
+	gopkgmail "gopkg.in/gomail.v2"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is the sender address; it defaults to Username
+	From string
+}
+
+// SMTPMailer sends through an SMTP relay using STARTTLS where offered.
+type SMTPMailer struct {
+	cfg    SMTPConfig
+	dialer *gopkgmail.Dialer
+}
+
+func NewSMTPMailer(cfg SMTPConfig) (*SMTPMailer, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("SMTP host is required")
+	}
+	if cfg.From == "" {
+		cfg.From = cfg.Username
+	}
+	if cfg.From == "" {
+		return nil, errors.New("SMTP sender address is required")
+	}
+	return &SMTPMailer{cfg: cfg, dialer: gopkgmail.NewDialer(cfg.Host, cfg.Port, cfg.Username, cfg.Password)}, nil
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m := gopkgmail.NewMessage()
+	m.SetHeader("From", s.cfg.From)
+	m.SetHeader("To", msg.To)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.Text)
+	if msg.HTML != "" {
+		m.AddAlternative("text/html", msg.HTML)
+	}
+	return s.dialer.DialAndSend(m)
+}
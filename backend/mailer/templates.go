@@ -0,0 +1,85 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Template names a message kind. Each has <name>.txt, which defines the
+// "subject" and "body" templates, and <name>.html, which defines "content"
+// for the shared HTML layout.
+type Template string
+
+const (
+	TemplateSignupOTP     Template = "signup_otp"
+	TemplatePasswordReset Template = "password_reset"
+	TemplateWelcome       Template = "welcome"
+	TemplateDigest        Template = "digest"
+)
+
+// OTPData renders TemplateSignupOTP and TemplatePasswordReset.
+type OTPData struct {
+	Code         string
+	ValidMinutes int
+}
+
+// WelcomeData renders TemplateWelcome.
+type WelcomeData struct {
+	Username string
+}
+
+// DigestData renders TemplateDigest.
+type DigestData struct {
+	Username string
+	Articles []DigestArticle
+}
+
+type DigestArticle struct {
+	Title  string
+	URL    string
+	Source string
+}
+
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	textTemplates = map[Template]*texttemplate.Template{}
+	htmlTemplates = map[Template]*htmltemplate.Template{}
+)
+
+func init() {
+	layout := htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/layout.html"))
+	for _, name := range []Template{TemplateSignupOTP, TemplatePasswordReset, TemplateWelcome, TemplateDigest} {
+		textTemplates[name] = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/"+string(name)+".txt"))
+		htmlTemplates[name] = htmltemplate.Must(htmltemplate.Must(layout.Clone()).ParseFS(templateFS, "templates/"+string(name)+".html"))
+	}
+}
+
+// Render builds the message for one recipient from a named template.
+func Render(name Template, to string, data any) (Message, error) {
+	text, ok := textTemplates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown mail template %q", name)
+	}
+	var subject, body, html bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("rendering %s subject: %w", name, err)
+	}
+	if err := text.ExecuteTemplate(&body, "body", data); err != nil {
+		return Message{}, fmt.Errorf("rendering %s text body: %w", name, err)
+	}
+	if err := htmlTemplates[name].ExecuteTemplate(&html, "layout", data); err != nil {
+		return Message{}, fmt.Errorf("rendering %s html body: %w", name, err)
+	}
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(body.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSendOTPTemplates(t *testing.T) {
+	tests := []struct {
+		name    Template
+		subject string
+	}{
+		{TemplateSignupOTP, "Your Newsly Signup OTP"},
+		{TemplatePasswordReset, "Reset your Newsly password"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.name), func(t *testing.T) {
+			capture := NewCaptureMailer()
+			err := SendTemplate(context.Background(), capture, "ada@example.com", tt.name, OTPData{Code: "482913", ValidMinutes: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, ok := capture.Last("ada@example.com")
+			if !ok {
+				t.Fatalf("nothing sent to ada@example.com; sent %v", capture.Messages())
+			}
+			if msg.To != "ada@example.com" || msg.Subject != tt.subject {
+				t.Errorf("sent %q to %q, want %q", msg.Subject, msg.To, tt.subject)
+			}
+			for body, text := range map[string]string{"text": msg.Text, "html": msg.HTML} {
+				if !strings.Contains(text, "482913") || !strings.Contains(text, "10 minutes") {
+					t.Errorf("%s body lacks the code or its lifetime:\n%s", body, text)
+				}
+			}
+			if !strings.HasPrefix(msg.HTML, "<!DOCTYPE html>") {
+				t.Errorf("html body is not in the layout:\n%s", msg.HTML)
+			}
+		})
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	msg, err := Render(TemplateWelcome, "ada@example.com", WelcomeData{Username: "<b>ada</b>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(msg.HTML, "<b>ada</b>") || !strings.Contains(msg.HTML, "&lt;b&gt;ada&lt;/b&gt;") {
+		t.Errorf("username not escaped in html body:\n%s", msg.HTML)
+	}
+	if !strings.Contains(msg.Text, "Hi <b>ada</b>,") {
+		t.Errorf("text body = %q", msg.Text)
+	}
+}
+
+func TestRenderUnknownTemplate(t *testing.T) {
+	if _, err := Render("nope", "ada@example.com", nil); err == nil {
+		t.Fatal("rendered an unknown template")
+	}
+}
@@ -1,65 +1,164 @@
 package main
 
 import (
+	"backend/config"
 	"backend/db"
 	"backend/handlers"
-	"fmt"
+	"backend/logging"
+	"backend/mailer"
+	"backend/metrics"
+	"backend/migrations"
+	"backend/news"
+	"backend/otp"
+	"backend/ratelimit"
+	"backend/repository"
+	"backend/tracing"
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
 	"net/http"
-
 	"os"
-
-	"github.com/joho/godotenv"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
+	// Settings come from flags, the environment and .env; see config.Load
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format))
+
+	// e.g. `go run . migrate-accounts -dry-run`
+	if len(args) > 0 {
+		if err := cfg.ValidateMongo(); err != nil {
+			log.Fatal(err)
+		}
+		db.ConnectMongo(cfg.Mongo)
+		runCommand(cfg, args[0], args[1:])
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, os.Stdout)
+	if err != nil {
+		log.Fatal("Failed to set up tracing: ", err)
+	}
+
+	// 1. Connect to MongoDB and make sure the indexes the queries and
+	// uniqueness rules depend on exist
+	db.ConnectMongo(cfg.Mongo)
+	if cfg.Mongo.SyncIndexes {
+		syncIndexesAtStartup()
+	}
+	warnPendingMigrations()
+
+	otpStore, err := otp.NewMongoStore(context.Background(), db.MongoDatabase)
+	if err != nil {
+		log.Fatal("Failed to set up OTP store: ", err)
+	}
+	metrics.WatchOTPStore(otpStore)
+
+	var limits ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Backend == "mongo" {
+		if limits, err = ratelimit.NewMongoStore(context.Background(), db.MongoDatabase); err != nil {
+			log.Fatal("Failed to set up rate limit store: ", err)
+		}
+	}
+
+	mail, err := mailer.FromConfig(cfg)
+	if err != nil {
+		log.Fatal("Failed to set up mailer: ", err)
+	}
+	if cfg.MailBackend() == "log" {
+		slog.Warn("MAIL_BACKEND is log: mail, one-time codes included, is not sent but written to the log or MAIL_DIR; use smtp in production")
+	}
 
-	errGo := godotenv.Load()
-	if errGo != nil {
-		log.Fatal("Error loading .env file")
-	}
-
-	// 1. Connect to MongoDB
-	mongoURI := os.Getenv("MONGO_URI")
-	db.ConnectMongo(mongoURI)
-
-	// 2. Use your handlers
-	http.HandleFunc("/", handlers.HelloHandler)
-	http.HandleFunc("/signup", handlers.PostManualSignUpHandler)
-	http.HandleFunc("/google-signup", handlers.PostGoogleSignUpHandler)
-	http.HandleFunc("/signin", handlers.PostManualSignInHandler)
-	http.HandleFunc("/google-signin", handlers.PostGoogleSignInHandler)
-	http.HandleFunc("/request-otp", handlers.PostRequestOTPHandler)
-	http.HandleFunc("/verify-otp", handlers.PostVerifyOTPHandler)
-	http.HandleFunc("/request-password-reset-otp", handlers.PostRequestPasswordResetOTPHandler)
-	http.HandleFunc("/verify-password-reset-otp", handlers.PostVerifyPasswordResetOTPHandler)
-	http.HandleFunc("/reset-password", handlers.PostResetPasswordHandler)
-	http.HandleFunc("/get-user-details", handlers.GetUserDetailsHandler)
-	http.HandleFunc("/update-user-details", handlers.PostUpdateUserDetailsHandler)
-
-	// News endpoint
-	http.HandleFunc("/news", handlers.GetNewsHandler)
-	http.HandleFunc("/news/article", handlers.GetNewsArticleByURLHandler)
-	http.HandleFunc("/news/summary", handlers.PostNewsSummaryHandler)
-
-	// Explore endpoints
-	http.HandleFunc("/explore/topics", handlers.GetExploreTopicsHandler)
-	http.HandleFunc("/explore/news", handlers.GetExploreNewsByTopicHandler)
-	http.HandleFunc("/explore/trending", handlers.GetExploreTrendingHandler)
-	http.HandleFunc("/explore/search", handlers.GetExploreSearchHandler)
-
-	// Bookmark endpoints
-	http.HandleFunc("/bookmarks/add", handlers.PostAddBookmarkHandler)
-	http.HandleFunc("/bookmarks/remove", handlers.PostRemoveBookmarkHandler)
-	http.HandleFunc("/bookmarks/list", handlers.GetBookmarksListHandler)
-
-	// Viewed news endpoints
-	http.HandleFunc("/viewed-news/add", handlers.PostViewedNewsHandler)
-	http.HandleFunc("/viewed-news/list", handlers.GetViewedNewsListHandler)
-
-	fmt.Println("Server starting on port 8080...")
-	err := http.ListenAndServe(":8080", nil)
+	provider, err := news.FromConfig(cfg)
 	if err != nil {
+		log.Fatal("Failed to set up news provider: ", err)
+	}
+
+	h := handlers.New(cfg, repository.NewMongo(db.MongoDatabase), otpStore, limits, mail, provider)
+
+	srv := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           handlers.NewRouter(h),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	stop, cancelSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancelSignals()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server starting", "addr", cfg.Server.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
 		log.Fatal(err)
+	case <-stop.Done():
+	}
+	cancelSignals() // a second signal kills the process immediately
+
+	slog.Info("shutting down, draining in-flight requests", "timeout", cfg.Server.ShutdownTimeout)
+	h.StartDraining()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("shutdown did not finish cleanly", "error", err)
+	}
+	if err := db.MongoClient.Disconnect(ctx); err != nil {
+		slog.Warn("failed to disconnect from MongoDB", "error", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Warn("failed to flush traces", "error", err)
+	}
+	slog.Info("server stopped")
+}
+
+// syncIndexesAtStartup reconciles the declared indexes. It refuses to start
+// when one cannot be built, since a missing unique index lets duplicates in.
+func syncIndexesAtStartup() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	report, err := repository.SyncIndexes(ctx, db.MongoDatabase, false, false)
+	if err != nil {
+		log.Fatal("Failed to sync indexes (see `sync-indexes -dry-run` and `migrate up`, or set MONGO_SYNC_INDEXES=false to skip): ", err)
+	}
+	if len(report.Created) > 0 || len(report.Rebuilt) > 0 || len(report.Dropped) > 0 {
+		slog.Info("indexes synced", "created", report.Created, "rebuilt", report.Rebuilt, "dropped", report.Dropped)
+	}
+	if len(report.Extra) > 0 {
+		slog.Warn("undeclared indexes left in place; `sync-indexes -prune` drops them", "indexes", report.Extra)
+	}
+}
+
+// warnPendingMigrations logs migrations this release expects but the
+// database has not had; they are run with `migrate up`, not at startup.
+func warnPendingMigrations() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pending, err := migrations.Pending(ctx, db.MongoDatabase)
+	if err != nil {
+		slog.Warn("failed to check migrations", "error", err)
+		return
+	}
+	if len(pending) > 0 {
+		slog.Warn("database has pending migrations; run `migrate up`", "pending", pending)
 	}
 }
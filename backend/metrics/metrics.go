@@ -0,0 +1,136 @@
+// Package metrics exposes the server's Prometheus metrics: inbound HTTP
+// traffic, rate limiting, calls to NewsAPI and Gemini, the OTP store and
+// the Mongo connection pool.
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/otp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "newsly"
+
+// Outcomes of an outbound call
+const (
+	OutcomeSuccess       = "success"
+	OutcomeNetworkError  = "network_error"
+	OutcomeUpstreamError = "upstream_error"
+	OutcomeDecodeError   = "decode_error"
+	// The resource had not changed since it was last fetched
+	OutcomeNotModified = "not_modified"
+)
+
+// Registry holds every metric served on /metrics. It is separate from the
+// global default registry so nothing registers into it by accident.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route pattern, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests, by route pattern and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	outboundRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbound_requests_total",
+		Help:      "Calls to external APIs, by service, operation and outcome.",
+	}, []string{"service", "operation", "outcome"})
+
+	outboundDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "outbound_request_duration_seconds",
+		Help:      "Time spent on calls to external APIs, by service and operation.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"service", "operation"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests refused by the rate limiter, by policy.",
+	}, []string{"policy"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		outboundRequests, outboundDuration,
+		rateLimited,
+		mongoPoolOpen, mongoPoolInUse, mongoPoolWaitDuration,
+	)
+}
+
+// Handler serves the registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveHTTP records one served request. route is the mux pattern that
+// matched, so the label set stays bounded whatever paths clients send.
+func ObserveHTTP(route, method string, status int, elapsed time.Duration) {
+	httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(route, method).Observe(elapsed.Seconds())
+}
+
+// ObserveOutbound records one call to an external API started at start.
+func ObserveOutbound(service, operation, outcome string, start time.Time) {
+	outboundRequests.WithLabelValues(service, operation, outcome).Inc()
+	outboundDuration.WithLabelValues(service, operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRateLimited records one request refused under policy.
+func ObserveRateLimited(policy string) {
+	rateLimited.WithLabelValues(policy).Inc()
+}
+
+// otpCollector reports the OTP store's size when scraped.
+type otpCollector struct {
+	store otp.Store
+	desc  *prometheus.Desc
+}
+
+// WatchOTPStore adds a gauge of outstanding codes per purpose, read from
+// store on every scrape.
+func WatchOTPStore(store otp.Store) {
+	Registry.MustRegister(&otpCollector{
+		store: store,
+		desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "otp", "outstanding"),
+			"Unexpired one-time codes and reset tokens, by purpose.", []string{"purpose"}, nil),
+	})
+}
+
+func (c *otpCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *otpCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	counts, err := c.store.Count(ctx)
+	if err != nil {
+		slog.Warn("failed to count OTP records for metrics", "error", err)
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	for _, purpose := range []otp.Purpose{otp.PurposeSignup, otp.PurposePasswordReset, otp.PurposeResetToken} {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(counts[purpose]), string(purpose))
+	}
+}
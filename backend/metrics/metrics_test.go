@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns the value of series in the /metrics exposition, or -1
+// when it is missing.
+func scrape(t *testing.T, series string) float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if v, ok := strings.CutPrefix(line, series+" "); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				t.Fatalf("%s: %v", line, err)
+			}
+			return f
+		}
+	}
+	return -1
+}
+
+func TestObserveHTTP(t *testing.T) {
+	ObserveHTTP("/news", "GET", 200, 30*time.Millisecond)
+	ObserveHTTP("/news", "GET", 200, 10*time.Millisecond)
+	ObserveHTTP("/news", "GET", 404, time.Millisecond)
+
+	for series, want := range map[string]float64{
+		`newsly_http_requests_total{method="GET",route="/news",status="200"}`:                2,
+		`newsly_http_requests_total{method="GET",route="/news",status="404"}`:                1,
+		`newsly_http_request_duration_seconds_count{method="GET",route="/news"}`:             3,
+		`newsly_http_request_duration_seconds_bucket{method="GET",route="/news",le="0.025"}`: 2,
+	} {
+		if got := scrape(t, series); got != want {
+			t.Errorf("%s = %v, want %v", series, got, want)
+		}
+	}
+}
+
+func TestObserveOutbound(t *testing.T) {
+	ObserveOutbound("newsapi", "top_headlines", OutcomeSuccess, time.Now())
+	ObserveOutbound("newsapi", "top_headlines", OutcomeUpstreamError, time.Now())
+
+	for series, want := range map[string]float64{
+		`newsly_outbound_requests_total{operation="top_headlines",outcome="success",service="newsapi"}`:        1,
+		`newsly_outbound_requests_total{operation="top_headlines",outcome="upstream_error",service="newsapi"}`: 1,
+		`newsly_outbound_request_duration_seconds_count{operation="top_headlines",service="newsapi"}`:          2,
+	} {
+		if got := scrape(t, series); got != want {
+			t.Errorf("%s = %v, want %v", series, got, want)
+		}
+	}
+}
+
+func TestObserveRateLimited(t *testing.T) {
+	ObserveRateLimited("otp_ip")
+	if got := scrape(t, `newsly_rate_limited_requests_total{policy="otp_ip"}`); got != 1 {
+		t.Errorf("rate limited count = %v, want 1", got)
+	}
+}
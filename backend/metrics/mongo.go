@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var (
+	mongoPoolOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mongo_pool_connections",
+		Help:      "Open connections in the MongoDB driver's pools.",
+	})
+
+	mongoPoolInUse = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mongo_pool_connections_in_use",
+		Help:      "MongoDB connections checked out by operations.",
+	})
+
+	mongoPoolWaitDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_pool_checkout_duration_seconds",
+		Help:      "Time operations waited for a MongoDB connection.",
+		Buckets:   []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+	})
+)
+
+// MongoPoolMonitor feeds the pool gauges from the driver's pool events.
+func MongoPoolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.ConnectionCreated:
+				mongoPoolOpen.Inc()
+			case event.ConnectionClosed:
+				mongoPoolOpen.Dec()
+			case event.GetSucceeded:
+				mongoPoolInUse.Inc()
+				mongoPoolWaitDuration.Observe(e.Duration.Seconds())
+			case event.ConnectionReturned:
+				mongoPoolInUse.Dec()
+			}
+		},
+	}
+}
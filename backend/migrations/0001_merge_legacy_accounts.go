@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"backend/accounts"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mergeLegacyAccounts is the migrate-accounts command as a migration, so
+// databases that never ran it by hand get it on their next deploy.
+var mergeLegacyAccounts = Migration{
+	ID:          "0001_merge_legacy_accounts",
+	Description: "merge users and google-signup-users into accounts",
+	Up: func(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+		report, err := accounts.MergeLegacy(ctx, db, dryRun)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d accounts created (%d merged from both collections, %d already migrated), %d conflicts",
+			report.Created, report.Merged, report.AlreadyMigrated, len(report.Conflicts)), nil
+	},
+}
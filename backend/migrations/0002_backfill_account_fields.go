@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"backend/accounts"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backfillAccountFields gives every account the fields the code assumes:
+// a logins array, and createdAt/updatedAt, taking the creation time from
+// the ObjectID when it was never stored.
+var backfillAccountFields = Migration{
+	ID:          "0002_backfill_account_fields",
+	Description: "backfill logins, createdAt and updatedAt on accounts",
+	Up: func(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+		col := db.Collection(accounts.CollectionName)
+		filter := bson.M{"$or": []bson.M{
+			{"logins": nil},
+			{"createdAt": bson.M{"$exists": false}},
+			{"updatedAt": bson.M{"$exists": false}},
+		}}
+		if dryRun {
+			n, err := col.CountDocuments(ctx, filter)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("would backfill %d accounts", n), nil
+		}
+		res, err := col.UpdateMany(ctx, filter, mongo.Pipeline{
+			{{Key: "$set", Value: bson.D{
+				{Key: "logins", Value: bson.M{"$ifNull": bson.A{"$logins", bson.M{"$literal": bson.A{}}}}},
+				{Key: "createdAt", Value: bson.M{"$ifNull": bson.A{"$createdAt", bson.M{"$toDate": "$_id"}}}},
+			}}},
+			{{Key: "$set", Value: bson.D{
+				{Key: "updatedAt", Value: bson.M{"$ifNull": bson.A{"$updatedAt", "$createdAt"}}},
+			}}},
+		})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("backfilled %d accounts", res.ModifiedCount), nil
+	},
+}
@@ -0,0 +1,74 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"backend/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// dedupeSavedArticles removes the duplicate bookmarks and history entries
+// the old check-then-insert code let through, which would otherwise stop
+// the user_article_url_unique indexes from being built. The first
+// bookmark and the latest view of each article are kept.
+var dedupeSavedArticles = Migration{
+	ID:          "0003_dedupe_saved_articles",
+	Description: "remove duplicate bookmarks and viewed news per user and article URL",
+	Up: func(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+		bookmarks, err := dedupeByArticleURL(ctx, db.Collection(repository.BookmarksCollection), "createdAt", 1, dryRun)
+		if err != nil {
+			return "", err
+		}
+		viewed, err := dedupeByArticleURL(ctx, db.Collection(repository.ViewedNewsCollection), "viewedAt", -1, dryRun)
+		if err != nil {
+			return "", err
+		}
+		verb := "removed"
+		if dryRun {
+			verb = "would remove"
+		}
+		return fmt.Sprintf("%s %d duplicate bookmarks and %d duplicate viewed news", verb, bookmarks, viewed), nil
+	},
+}
+
+// dedupeByArticleURL keeps one document per user and article.url, the
+// first when ordered by sortField in direction, and deletes the rest.
+func dedupeByArticleURL(ctx context.Context, col *mongo.Collection, sortField string, direction int, dryRun bool) (int64, error) {
+	cur, err := col.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"article.url": bson.M{"$type": "string"}}}},
+		{{Key: "$sort", Value: bson.D{{Key: sortField, Value: direction}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"user": "$user", "url": "$article.url"},
+			"ids": bson.M{"$push": "$_id"},
+		}}},
+		{{Key: "$match", Value: bson.M{"ids.1": bson.M{"$exists": true}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+	var total int64
+	for cur.Next(ctx) {
+		var group struct {
+			IDs []any `bson:"ids"`
+		}
+		if err := cur.Decode(&group); err != nil {
+			return total, err
+		}
+		extra := group.IDs[1:]
+		if dryRun {
+			total += int64(len(extra))
+			continue
+		}
+		res, err := col.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": extra}})
+		if err != nil {
+			return total, err
+		}
+		total += res.DeletedCount
+	}
+	return total, cur.Err()
+}
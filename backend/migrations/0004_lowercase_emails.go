@@ -0,0 +1,153 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"backend/accounts"
+	"backend/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// emailReferences are the fields outside accounts that hold an account's
+// email. Bookmarks and viewed news are unique per user and article URL, so
+// a row that would collide with one already stored under the lower-case
+// email is merged: timeField and keep pick the survivor as 0003 does, the
+// first bookmark and the latest view.
+var emailReferences = []struct {
+	collection, field string
+	timeField         string
+	keep              int
+}{
+	{repository.BookmarksCollection, "user", "createdAt", 1},
+	{repository.ViewedNewsCollection, "user", "viewedAt", -1},
+	{repository.SessionsCollection, "email", "", 0},
+}
+
+// lowercaseEmails stores every account email in accounts.NormalizeEmail form,
+// which is how the API now looks them up. An account whose normal form
+// already belongs to another account is left alone and reported, since
+// which of the two to keep is for a person to decide. References are
+// updated before the account so a run that fails part way can be repeated,
+// and duplicates are merged before the rename so the unique indexes built
+// at startup do not reject it.
+var lowercaseEmails = Migration{
+	ID:          "0004_lowercase_emails",
+	Description: "trim and lower-case account emails and the bookmarks, history and sessions that refer to them",
+	Up: func(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+		col := db.Collection(accounts.CollectionName)
+		cur, err := col.Find(ctx, bson.M{"email": bson.M{"$type": "string"}}, options.Find().SetProjection(bson.M{"email": 1}))
+		if err != nil {
+			return "", err
+		}
+		defer cur.Close(ctx)
+		var changed int
+		var merged int64
+		var clashes []string
+		for cur.Next(ctx) {
+			var doc struct {
+				ID    primitive.ObjectID `bson:"_id"`
+				Email string             `bson:"email"`
+			}
+			if err := cur.Decode(&doc); err != nil {
+				return "", err
+			}
+			normal := accounts.NormalizeEmail(doc.Email)
+			if normal == doc.Email {
+				continue
+			}
+			taken, err := col.CountDocuments(ctx, bson.M{"email": normal})
+			if err != nil {
+				return "", err
+			}
+			if taken > 0 {
+				clashes = append(clashes, doc.Email)
+				continue
+			}
+			changed++
+			for _, ref := range emailReferences {
+				if ref.timeField == "" {
+					continue
+				}
+				n, err := mergeSavedArticles(ctx, db.Collection(ref.collection), doc.Email, normal, ref.timeField, ref.keep, dryRun)
+				if err != nil {
+					return "", fmt.Errorf("merging %s for %s: %w", ref.collection, doc.Email, err)
+				}
+				merged += n
+			}
+			if dryRun {
+				continue
+			}
+			for _, ref := range emailReferences {
+				if _, err := db.Collection(ref.collection).UpdateMany(ctx, bson.M{ref.field: doc.Email}, bson.M{"$set": bson.M{ref.field: normal}}); err != nil {
+					return "", fmt.Errorf("updating %s for %s: %w", ref.collection, doc.Email, err)
+				}
+			}
+			if _, err := col.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{"email": normal}}); err != nil {
+				return "", fmt.Errorf("updating account %s: %w", doc.Email, err)
+			}
+		}
+		if err := cur.Err(); err != nil {
+			return "", err
+		}
+		verb := "lower-cased"
+		if dryRun {
+			verb = "would lower-case"
+		}
+		summary := fmt.Sprintf("%s %d account emails", verb, changed)
+		if merged > 0 {
+			summary += fmt.Sprintf(", merging %d duplicate bookmarks and viewed news", merged)
+		}
+		if len(clashes) > 0 {
+			summary += fmt.Sprintf("; left %d whose lower-case form belongs to another account: %s", len(clashes), strings.Join(clashes, ", "))
+		}
+		return summary, nil
+	},
+}
+
+// mergeSavedArticles removes, for each article saved under both from and
+// to, whichever of the two rows loses when ordered by timeField in
+// direction keep, so from's rows can then be renamed to to. It returns how
+// many rows were (or in a dry run would be) removed.
+func mergeSavedArticles(ctx context.Context, col *mongo.Collection, from, to, timeField string, keep int, dryRun bool) (int64, error) {
+	cur, err := col.Find(ctx, bson.M{"user": from, "article.url": bson.M{"$type": "string"}})
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+	var removed int64
+	for cur.Next(ctx) {
+		row := cur.Current
+		url, _ := row.Lookup("article", "url").StringValueOK()
+		other, err := col.FindOne(ctx, bson.M{"user": to, "article.url": url}).Raw()
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+		if dryRun {
+			continue
+		}
+		loser := row.Lookup("_id")
+		if mine, theirs := timeOf(row, timeField), timeOf(other, timeField); (keep > 0 && mine < theirs) || (keep < 0 && mine > theirs) {
+			loser = other.Lookup("_id")
+		}
+		if _, err := col.DeleteOne(ctx, bson.M{"_id": loser}); err != nil {
+			return removed, err
+		}
+	}
+	return removed, cur.Err()
+}
+
+// timeOf returns doc's field in milliseconds, or 0 when it is not a date.
+func timeOf(doc bson.Raw, field string) int64 {
+	t, _ := doc.Lookup(field).DateTimeOK()
+	return t
+}
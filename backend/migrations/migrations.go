@@ -0,0 +1,226 @@
+// Package migrations evolves stored documents between releases. Each
+// migration is Go code with a sortable ID; the IDs that have run are
+// recorded in the schema_migrations collection so every migration runs
+// once per database, in order.
+//
+// Migrations must be idempotent: a run that fails after changing data but
+// before being recorded is simply run again.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const CollectionName = "schema_migrations"
+
+// lockID is the schema_migrations document held while migrations run, so
+// two deploys cannot run them at once.
+const lockID = "_lock"
+
+// Migration is one schema change.
+type Migration struct {
+	// ID orders migrations, e.g. "0003_rename_fields"; never reuse or
+	// renumber one that has shipped.
+	ID          string
+	Description string
+	// Up applies the change and returns a one-line summary of what it did.
+	// With dryRun it must not write, only report what it would do.
+	Up func(ctx context.Context, db *mongo.Database, dryRun bool) (string, error)
+}
+
+// All lists the migrations in the order they run. Append new ones at the
+// end with the next number.
+var All = []Migration{
+	mergeLegacyAccounts,
+	backfillAccountFields,
+	dedupeSavedArticles,
+	lowercaseEmails,
+}
+
+// Record is a schema_migrations entry.
+type Record struct {
+	ID          string    `bson:"_id" json:"id"`
+	Description string    `bson:"description" json:"description"`
+	Summary     string    `bson:"summary" json:"summary"`
+	AppliedAt   time.Time `bson:"appliedAt" json:"appliedAt"`
+	DurationMS  int64     `bson:"durationMs" json:"durationMs"`
+}
+
+// Status is a migration and, once applied, its record.
+type Status struct {
+	ID          string  `json:"id"`
+	Description string  `json:"description"`
+	Applied     *Record `json:"applied,omitempty"`
+}
+
+// Result is what one migration did during Up.
+type Result struct {
+	ID      string `json:"id"`
+	Summary string `json:"summary"`
+	DryRun  bool   `json:"dryRun"`
+}
+
+// ErrLocked is returned when another run holds the migration lock.
+var ErrLocked = errors.New("migrations are locked by another run")
+
+// validate checks All is ordered and has no duplicate IDs.
+func validate(all []Migration) error {
+	for i, m := range all {
+		if m.ID == "" || m.Up == nil {
+			return fmt.Errorf("migration %d needs an ID and an Up function", i)
+		}
+		if i > 0 && all[i-1].ID >= m.ID {
+			return fmt.Errorf("migration %s must sort after %s", m.ID, all[i-1].ID)
+		}
+	}
+	return nil
+}
+
+func applied(ctx context.Context, db *mongo.Database) (map[string]Record, error) {
+	cur, err := db.Collection(CollectionName).Find(ctx, bson.M{"_id": bson.M{"$ne": lockID}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var records []Record
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	out := make(map[string]Record, len(records))
+	for _, r := range records {
+		out[r.ID] = r
+	}
+	return out, nil
+}
+
+// List reports every known migration and whether it has been applied.
+func List(ctx context.Context, db *mongo.Database) ([]Status, error) {
+	if err := validate(All); err != nil {
+		return nil, err
+	}
+	done, err := applied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Status, 0, len(All))
+	for _, m := range All {
+		s := Status{ID: m.ID, Description: m.Description}
+		if r, ok := done[m.ID]; ok {
+			s.Applied = &r
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// Pending returns the IDs of migrations that have not been applied.
+func Pending(ctx context.Context, db *mongo.Database) ([]string, error) {
+	if err := validate(All); err != nil {
+		return nil, err
+	}
+	done, err := applied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []string
+	for _, m := range plan(All, done, "") {
+		pending = append(pending, m.ID)
+	}
+	return pending, nil
+}
+
+// Up runs pending migrations in order, stopping after target when it is
+// set. It stops at the first failure; what ran before it stays recorded.
+// A dry run asks each pending migration what it would do and records
+// nothing.
+func Up(ctx context.Context, db *mongo.Database, target string, dryRun bool) ([]Result, error) {
+	if err := validate(All); err != nil {
+		return nil, err
+	}
+	if target != "" && !slices.ContainsFunc(All, func(m Migration) bool { return m.ID == target }) {
+		return nil, fmt.Errorf("unknown migration %q", target)
+	}
+	if !dryRun {
+		release, err := lock(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+	done, err := applied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, m := range plan(All, done, target) {
+		start := time.Now()
+		summary, err := m.Up(ctx, db, dryRun)
+		if err != nil {
+			return results, fmt.Errorf("migration %s: %w", m.ID, err)
+		}
+		results = append(results, Result{ID: m.ID, Summary: summary, DryRun: dryRun})
+		if !dryRun {
+			rec := Record{
+				ID:          m.ID,
+				Description: m.Description,
+				Summary:     summary,
+				AppliedAt:   time.Now(),
+				DurationMS:  time.Since(start).Milliseconds(),
+			}
+			if _, err := db.Collection(CollectionName).InsertOne(ctx, rec); err != nil {
+				return results, fmt.Errorf("recording migration %s: %w", m.ID, err)
+			}
+		}
+	}
+	return results, nil
+}
+
+// plan returns the migrations of all that are not in done, in order,
+// stopping after target when it is set.
+func plan(all []Migration, done map[string]Record, target string) []Migration {
+	var out []Migration
+	for _, m := range all {
+		if _, ok := done[m.ID]; !ok {
+			out = append(out, m)
+		}
+		if m.ID == target {
+			break
+		}
+	}
+	return out
+}
+
+// lock takes the migration lock and returns a function releasing it.
+func lock(ctx context.Context, db *mongo.Database) (func(), error) {
+	col := db.Collection(CollectionName)
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s/%d", host, os.Getpid())
+	_, err := col.InsertOne(ctx, bson.M{"_id": lockID, "owner": owner, "lockedAt": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		var held struct {
+			Owner    string    `bson:"owner"`
+			LockedAt time.Time `bson:"lockedAt"`
+		}
+		col.FindOne(ctx, bson.M{"_id": lockID}).Decode(&held)
+		return nil, fmt.Errorf("%w (%s since %s); if that run died, delete the %q document from %s",
+			ErrLocked, held.Owner, held.LockedAt.Format(time.RFC3339), lockID, CollectionName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		// The caller's context may have expired; release regardless
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		col.DeleteOne(ctx, bson.M{"_id": lockID, "owner": owner})
+	}, nil
+}
@@ -0,0 +1,342 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"backend/accounts"
+	"backend/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDB returns an empty database on the server in TEST_MONGO_URI, dropped
+// when the test ends. Migrations are MongoDB code through and through, so
+// without one the test is skipped.
+func testDB(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database(fmt.Sprintf("migrations_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return db
+}
+
+func insert(t *testing.T, col *mongo.Collection, docs ...any) {
+	t.Helper()
+	if _, err := col.InsertMany(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// seed stores data every migration has something to do with: a legacy
+// user, a mixed-case account and duplicate bookmarks and views.
+func seed(t *testing.T, db *mongo.Database) {
+	t.Helper()
+	at := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	insert(t, db.Collection(accounts.LegacyUsersCollection), bson.M{"email": "old@example.com", "username": "old", "password": "$2a$10$legacyhash", "createdAt": at})
+	insert(t, db.Collection(accounts.CollectionName), bson.M{"email": "Mixed@Example.com", "username": "mixed", "logins": bson.A{}, "createdAt": at, "updatedAt": at})
+	article := bson.M{"url": "https://news.example.com/a"}
+	insert(t, db.Collection(repository.BookmarksCollection),
+		bson.M{"user": "mixed@example.com", "article": article, "createdAt": at},
+		bson.M{"user": "mixed@example.com", "article": article, "createdAt": at.Add(time.Hour)},
+	)
+	insert(t, db.Collection(repository.ViewedNewsCollection),
+		bson.M{"user": "mixed@example.com", "article": article, "viewedAt": at},
+		bson.M{"user": "mixed@example.com", "article": article, "viewedAt": at.Add(time.Hour)},
+	)
+}
+
+// snapshot returns every document in db by collection.
+func snapshot(t *testing.T, db *mongo.Database) map[string][]bson.M {
+	t.Helper()
+	ctx := context.Background()
+	names, err := db.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string][]bson.M, len(names))
+	for _, name := range names {
+		cur, err := db.Collection(name).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var docs []bson.M
+		if err := cur.All(ctx, &docs); err != nil {
+			t.Fatal(err)
+		}
+		out[name] = docs
+	}
+	return out
+}
+
+func TestUpRunsOnce(t *testing.T) {
+	db := testDB(t)
+	seed(t, db)
+	ctx := context.Background()
+
+	results, err := Up(ctx, db, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(All) {
+		t.Fatalf("first run applied %d migrations, want %d", len(results), len(All))
+	}
+	after := snapshot(t, db)
+
+	results, err = Up(ctx, db, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("second run applied %v", results)
+	}
+	if !reflect.DeepEqual(snapshot(t, db), after) {
+		t.Fatal("second run changed the database")
+	}
+	if pending, err := Pending(ctx, db); err != nil || len(pending) != 0 {
+		t.Fatalf("pending after Up: %v, %v", pending, err)
+	}
+}
+
+func TestUpDryRunWritesNothing(t *testing.T) {
+	db := testDB(t)
+	seed(t, db)
+	ctx := context.Background()
+	before := snapshot(t, db)
+
+	results, err := Up(ctx, db, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(All) {
+		t.Fatalf("dry run reported %d migrations, want %d", len(results), len(All))
+	}
+	for _, r := range results {
+		if !r.DryRun {
+			t.Fatalf("result %s is not marked as a dry run", r.ID)
+		}
+	}
+	if !reflect.DeepEqual(snapshot(t, db), before) {
+		t.Fatal("dry run changed the database")
+	}
+}
+
+func TestUpLocked(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	insert(t, db.Collection(CollectionName), bson.M{"_id": lockID, "owner": "other-host/1", "lockedAt": time.Now()})
+
+	if _, err := Up(ctx, db, "", false); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Up under a held lock returned %v", err)
+	}
+	if pending, err := Pending(ctx, db); err != nil || len(pending) != len(All) {
+		t.Fatalf("pending under a held lock: %v, %v", pending, err)
+	}
+	var held bson.M
+	if err := db.Collection(CollectionName).FindOne(ctx, bson.M{"_id": lockID}).Decode(&held); err != nil || held["owner"] != "other-host/1" {
+		t.Fatalf("the other run's lock was touched: %v, %v", held, err)
+	}
+}
+
+func TestDedupeSavedArticles(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	at := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	a := bson.M{"url": "https://news.example.com/a"}
+	b := bson.M{"url": "https://news.example.com/b"}
+	ids := make([]primitive.ObjectID, 8)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+	}
+	// Inserted out of time order so the _id order does not decide
+	insert(t, db.Collection(repository.BookmarksCollection),
+		bson.M{"_id": ids[0], "user": "ada@example.com", "article": a, "createdAt": at.Add(time.Hour)},
+		bson.M{"_id": ids[1], "user": "ada@example.com", "article": a, "createdAt": at},
+		bson.M{"_id": ids[2], "user": "ada@example.com", "article": a, "createdAt": at.Add(2 * time.Hour)},
+		bson.M{"_id": ids[3], "user": "bob@example.com", "article": a, "createdAt": at.Add(time.Hour)},
+	)
+	insert(t, db.Collection(repository.ViewedNewsCollection),
+		bson.M{"_id": ids[4], "user": "ada@example.com", "article": b, "viewedAt": at.Add(time.Hour)},
+		bson.M{"_id": ids[5], "user": "ada@example.com", "article": b, "viewedAt": at.Add(2 * time.Hour)},
+		bson.M{"_id": ids[6], "user": "ada@example.com", "article": b, "viewedAt": at},
+		bson.M{"_id": ids[7], "user": "ada@example.com", "article": a, "viewedAt": at},
+	)
+
+	summary, err := dedupeSavedArticles.Up(ctx, db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "removed 2 duplicate bookmarks and 2 duplicate viewed news"; summary != want {
+		t.Errorf("summary %q, want %q", summary, want)
+	}
+	remaining := func(col string) []primitive.ObjectID {
+		var docs []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		cur, err := db.Collection(col).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cur.All(ctx, &docs); err != nil {
+			t.Fatal(err)
+		}
+		out := make([]primitive.ObjectID, len(docs))
+		for i, d := range docs {
+			out[i] = d.ID
+		}
+		return out
+	}
+	// The first bookmark and the most recent view of each article survive
+	if got, want := remaining(repository.BookmarksCollection), []primitive.ObjectID{ids[1], ids[3]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bookmarks left %v, want %v", got, want)
+	}
+	if got, want := remaining(repository.ViewedNewsCollection), []primitive.ObjectID{ids[5], ids[7]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("viewed news left %v, want %v", got, want)
+	}
+}
+
+func TestLowercaseEmailsMergesSavedArticles(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	// The unique indexes are in place before migrations run
+	if _, err := repository.SyncIndexes(ctx, db, false, false); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	a := bson.M{"url": "https://news.example.com/a"}
+	ids := make([]primitive.ObjectID, 4)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+	}
+	insert(t, db.Collection(accounts.CollectionName), bson.M{"email": "Ada@Example.com", "logins": bson.A{}, "createdAt": at, "updatedAt": at})
+	insert(t, db.Collection(repository.BookmarksCollection),
+		bson.M{"_id": ids[0], "user": "Ada@Example.com", "article": a, "createdAt": at},
+		bson.M{"_id": ids[1], "user": "ada@example.com", "article": a, "createdAt": at.Add(time.Hour)},
+	)
+	insert(t, db.Collection(repository.ViewedNewsCollection),
+		bson.M{"_id": ids[2], "user": "Ada@Example.com", "article": a, "viewedAt": at},
+		bson.M{"_id": ids[3], "user": "ada@example.com", "article": a, "viewedAt": at.Add(time.Hour)},
+	)
+
+	summary, err := lowercaseEmails.Up(ctx, db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "lower-cased 1 account emails, merging 2 duplicate bookmarks and viewed news"; summary != want {
+		t.Errorf("summary %q, want %q", summary, want)
+	}
+	for col, want := range map[string]primitive.ObjectID{
+		repository.BookmarksCollection:  ids[0],
+		repository.ViewedNewsCollection: ids[3],
+	} {
+		var docs []bson.M
+		cur, err := db.Collection(col).Find(ctx, bson.M{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cur.All(ctx, &docs); err != nil {
+			t.Fatal(err)
+		}
+		if len(docs) != 1 || docs[0]["_id"] != want || docs[0]["user"] != "ada@example.com" {
+			t.Errorf("%s left %v, want only %v under ada@example.com", col, docs, want)
+		}
+	}
+}
+
+func TestAllIsValid(t *testing.T) {
+	if err := validate(All); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, m := range All {
+		if m.Description == "" {
+			t.Errorf("%s has no description", m.ID)
+		}
+		if seen[m.ID] {
+			t.Errorf("%s is listed twice", m.ID)
+		}
+		seen[m.ID] = true
+	}
+}
+
+func TestValidate(t *testing.T) {
+	up := func(context.Context, *mongo.Database, bool) (string, error) { return "", nil }
+	m := func(id string) Migration { return Migration{ID: id, Description: id, Up: up} }
+	for name, all := range map[string][]Migration{
+		"out of order": {m("0001_a"), m("0003_c"), m("0002_b")},
+		"duplicate":    {m("0001_a"), m("0002_b"), m("0002_b")},
+		"no ID":        {m("0001_a"), m("")},
+		"no Up":        {m("0001_a"), {ID: "0002_b"}},
+	} {
+		if err := validate(all); err == nil {
+			t.Errorf("%s: validated", name)
+		}
+	}
+	if err := validate([]Migration{m("0001_a"), m("0002_b"), m("0010_c")}); err != nil {
+		t.Errorf("ordered list: %v", err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	ids := func(ms []Migration) []string {
+		out := []string{}
+		for _, m := range ms {
+			out = append(out, m.ID)
+		}
+		return out
+	}
+	all := make([]string, len(All))
+	for i, m := range All {
+		all[i] = m.ID
+	}
+	applied := func(ids ...string) map[string]Record {
+		done := map[string]Record{}
+		for _, id := range ids {
+			done[id] = Record{ID: id}
+		}
+		return done
+	}
+
+	tests := []struct {
+		name   string
+		done   map[string]Record
+		target string
+		want   []string
+	}{
+		{"fresh database", applied(), "", all},
+		{"up to a target", applied(), all[1], all[:2]},
+		{"some applied", applied(all[0], all[2]), "", append([]string{all[1]}, all[3:]...)},
+		{"target already applied", applied(all[0], all[1]), all[1], []string{}},
+		{"everything applied", applied(all...), "", []string{}},
+	}
+	for _, tt := range tests {
+		if got := ids(plan(All, tt.done, tt.target)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: plan = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package news
+
+import (
+	"encoding/xml"
+	"errors"
+	"html"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// parsedFeed is what a feed document holds, before the provider labels it
+// with the feed's source.
+type parsedFeed struct {
+	Title    string
+	Articles []Article
+}
+
+// parseFeed reads an RSS 2.0, RSS 1.0 or Atom document. Relative links and
+// image URLs are resolved against base, the feed's own URL.
+func parseFeed(r io.Reader, base *url.URL) (*parsedFeed, error) {
+	dec := xml.NewDecoder(r)
+	// Feeds are still published in Latin-1 and Windows code pages
+	dec.CharsetReader = charset.NewReaderLabel
+	// Feeds use HTML entities such as &nbsp; that XML does not define
+	dec.Strict = false
+	dec.Entity = xml.HTMLEntity
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("not an RSS or Atom feed")
+			}
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "rss":
+			var doc struct {
+				Channel struct {
+					XMLName xml.Name
+					Titles  []nsText  `xml:"title"`
+					Items   []rssItem `xml:"item"`
+				} `xml:"channel"`
+			}
+			if err := dec.DecodeElement(&doc, &start); err != nil {
+				return nil, err
+			}
+			return rssFeed(own(doc.Channel.Titles, doc.Channel.XMLName.Space), doc.Channel.Items, base), nil
+		case "RDF":
+			// RSS 1.0 keeps items beside the channel rather than in it
+			var doc struct {
+				Channel struct {
+					XMLName xml.Name
+					Titles  []nsText `xml:"title"`
+				} `xml:"channel"`
+				Items []rssItem `xml:"item"`
+			}
+			if err := dec.DecodeElement(&doc, &start); err != nil {
+				return nil, err
+			}
+			return rssFeed(own(doc.Channel.Titles, doc.Channel.XMLName.Space), doc.Items, base), nil
+		case "feed":
+			var doc struct {
+				Title   atomText    `xml:"http://www.w3.org/2005/Atom title"`
+				Entries []atomEntry `xml:"http://www.w3.org/2005/Atom entry"`
+			}
+			if err := dec.DecodeElement(&doc, &start); err != nil {
+				return nil, err
+			}
+			feed := &parsedFeed{Title: doc.Title.String()}
+			for _, e := range doc.Entries {
+				feed.Articles = append(feed.Articles, e.article(base))
+			}
+			return feed, nil
+		default:
+			return nil, errors.New("not an RSS or Atom feed: root element is " + start.Name.Local)
+		}
+	}
+}
+
+type mediaContent struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Medium string `xml:"medium,attr"`
+}
+
+func (m mediaContent) isImage() bool {
+	return m.Medium == "image" || strings.HasPrefix(m.Type, "image/") || (m.Medium == "" && m.Type == "")
+}
+
+// media is the Media RSS image markup shared by RSS and Atom.
+type media struct {
+	Contents   []mediaContent `xml:"http://search.yahoo.com/mrss/ content"`
+	Thumbnails []mediaContent `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	Groups     []struct {
+		Contents   []mediaContent `xml:"http://search.yahoo.com/mrss/ content"`
+		Thumbnails []mediaContent `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	} `xml:"http://search.yahoo.com/mrss/ group"`
+}
+
+// image is the first image the markup offers, preferring full-size
+// content to thumbnails.
+func (m media) image() string {
+	contents, thumbnails := m.Contents, m.Thumbnails
+	for _, g := range m.Groups {
+		contents = append(contents, g.Contents...)
+		thumbnails = append(thumbnails, g.Thumbnails...)
+	}
+	for _, c := range contents {
+		if c.URL != "" && c.isImage() {
+			return c.URL
+		}
+	}
+	for _, t := range thumbnails {
+		if t.URL != "" {
+			return t.URL
+		}
+	}
+	return ""
+}
+
+// nsText is an element's text along with its name. encoding/xml matches an
+// unqualified field tag in any namespace, so fields whose local name
+// extensions reuse, such as media:title or itunes:author, are collected
+// whole and the item's own element picked out with own.
+type nsText struct {
+	XMLName xml.Name
+	Text    string `xml:",chardata"`
+}
+
+// own returns the first non-blank text of the elements in space, the
+// namespace of the element that holds them.
+func own(elems []nsText, space string) string {
+	for _, e := range elems {
+		if e.XMLName.Space == space && strings.TrimSpace(e.Text) != "" {
+			return e.Text
+		}
+	}
+	return ""
+}
+
+// rssItem is an RSS item with the Dublin Core, content and Media RSS
+// extensions publishers use for authors, full text and images.
+type rssItem struct {
+	media
+	XMLName      xml.Name
+	Titles       []nsText `xml:"title"`
+	Links        []nsText `xml:"link"`
+	GUID         string   `xml:"guid"`
+	Descriptions []nsText `xml:"description"`
+	Content      string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Authors      []nsText `xml:"author"`
+	Creator      string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	PubDate      string   `xml:"pubDate"`
+	Date         string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Enclosures   []struct {
+		URL  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"enclosure"`
+}
+
+func rssFeed(title string, items []rssItem, base *url.URL) *parsedFeed {
+	feed := &parsedFeed{Title: plainText(title)}
+	for _, item := range items {
+		feed.Articles = append(feed.Articles, item.article(base))
+	}
+	return feed
+}
+
+func (item rssItem) article(base *url.URL) Article {
+	space := item.XMLName.Space
+	rawDescription := own(item.Descriptions, space)
+	link := strings.TrimSpace(own(item.Links, space))
+	// A guid is often the permalink when there is no link
+	if link == "" && strings.HasPrefix(item.GUID, "http") {
+		link = strings.TrimSpace(item.GUID)
+	}
+	link = resolve(base, link)
+
+	image := item.image()
+	for _, e := range item.Enclosures {
+		if image == "" && strings.HasPrefix(e.Type, "image/") {
+			image = e.URL
+		}
+	}
+	if image == "" {
+		image = firstImage(item.Content + rawDescription)
+	}
+
+	author := item.Creator
+	if author == "" {
+		author = rssAuthor(own(item.Authors, space))
+	}
+	published := parseTime(item.PubDate)
+	if published.IsZero() {
+		published = parseTime(item.Date)
+	}
+	description := plainText(rawDescription)
+	content := plainText(item.Content)
+	if content == "" {
+		content = description
+	}
+	return Article{
+		Author:      plainText(author),
+		Title:       plainText(own(item.Titles, space)),
+		Description: description,
+		URL:         link,
+		URLToImage:  resolve(base, image),
+		PublishedAt: published,
+		Content:     content,
+	}
+}
+
+// rssAuthor takes the name out of RSS's "email (Name)" form.
+func rssAuthor(s string) string {
+	if start, end := strings.Index(s, "("), strings.LastIndex(s, ")"); start >= 0 && end > start {
+		return s[start+1 : end]
+	}
+	return s
+}
+
+// atomText is an Atom text construct: plain text, escaped HTML or inline
+// XHTML.
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+func (t atomText) String() string {
+	if t.Type == "xhtml" {
+		return plainText(t.Inner)
+	}
+	return plainText(t.Text)
+}
+
+// atomEntry is an Atom entry. Its fields are bound to the Atom namespace so
+// extension elements with the same local name are not read in their place.
+type atomEntry struct {
+	media
+	Title atomText `xml:"http://www.w3.org/2005/Atom title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"http://www.w3.org/2005/Atom link"`
+	Summary atomText `xml:"http://www.w3.org/2005/Atom summary"`
+	Content atomText `xml:"http://www.w3.org/2005/Atom content"`
+	Authors []struct {
+		Name string `xml:"http://www.w3.org/2005/Atom name"`
+	} `xml:"http://www.w3.org/2005/Atom author"`
+	Published string `xml:"http://www.w3.org/2005/Atom published"`
+	Updated   string `xml:"http://www.w3.org/2005/Atom updated"`
+}
+
+func (e atomEntry) article(base *url.URL) Article {
+	var link, image string
+	for _, l := range e.Links {
+		switch {
+		case (l.Rel == "" || l.Rel == "alternate") && link == "":
+			link = l.Href
+		case l.Rel == "enclosure" && strings.HasPrefix(l.Type, "image/") && image == "":
+			image = l.Href
+		}
+	}
+	if image == "" {
+		image = e.image()
+	}
+	if image == "" {
+		markup := e.Content.Text + e.Summary.Text
+		if e.Content.Type == "xhtml" || e.Summary.Type == "xhtml" {
+			markup = e.Content.Inner + e.Summary.Inner
+		}
+		image = firstImage(markup)
+	}
+	var authors []string
+	for _, a := range e.Authors {
+		if name := strings.TrimSpace(a.Name); name != "" {
+			authors = append(authors, name)
+		}
+	}
+	published := parseTime(e.Published)
+	if published.IsZero() {
+		published = parseTime(e.Updated)
+	}
+	description := e.Summary.String()
+	content := e.Content.String()
+	if description == "" {
+		description = content
+	}
+	if content == "" {
+		content = description
+	}
+	return Article{
+		Author:      strings.Join(authors, ", "),
+		Title:       e.Title.String(),
+		Description: description,
+		URL:         resolve(base, link),
+		URLToImage:  resolve(base, image),
+		PublishedAt: published,
+		Content:     content,
+	}
+}
+
+// timeLayouts are the date formats seen in feeds: RFC 822 and its
+// variations in RSS, RFC 3339 in Atom and Dublin Core.
+var timeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"Mon, 02 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"02 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	time.DateOnly,
+}
+
+// parseTime reads a feed date, or returns the zero time.
+func parseTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+var (
+	scriptsAndStyles = regexp.MustCompile(`(?is)<script\b.*?</script>|<style\b.*?</style>`)
+	tags             = regexp.MustCompile(`(?s)<[^>]*>`)
+	imgSrc           = regexp.MustCompile(`(?is)<img\b[^>]*?\ssrc\s*=\s*["']([^"']+)["']`)
+)
+
+// plainText turns feed HTML into text: tags removed, entities decoded and
+// whitespace collapsed.
+func plainText(s string) string {
+	s = scriptsAndStyles.ReplaceAllString(s, " ")
+	s = tags.ReplaceAllString(s, " ")
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// firstImage is the source of the first <img> in an HTML fragment.
+func firstImage(fragment string) string {
+	if m := imgSrc.FindStringSubmatch(fragment); m != nil {
+		return html.UnescapeString(m[1])
+	}
+	return ""
+}
+
+// resolve makes ref absolute against base, leaving it empty when it is.
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || base == nil {
+		return ref
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
@@ -0,0 +1,202 @@
+package news
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFeed(t *testing.T) {
+	base, _ := url.Parse("https://news.example.com/feeds/top.xml")
+	published := time.Date(2024, 5, 6, 18, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		doc   string
+		title string
+		want  []Article
+	}{{
+		name: "rss with extensions",
+		doc: `<?xml version="1.0"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:media="http://search.yahoo.com/mrss/"
+     xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:content="http://purl.org/rss/1.0/modules/content/"
+     xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+  <title>Top Stories</title>
+  <atom:link href="https://news.example.com/feeds/top.xml" rel="self"/>
+  <item>
+    <media:title>Photo: the winning kick</media:title>
+    <title>Cup final goes to penalties</title>
+    <link>/sport/cup-final</link>
+    <media:description>Caption text</media:description>
+    <description>&lt;p&gt;The final&amp;nbsp;went to &lt;b&gt;penalties&lt;/b&gt; again&lt;/p&gt;</description>
+    <content:encoded><![CDATA[<p>Full report.</p><script>track()</script>]]></content:encoded>
+    <itunes:author>Podcast Host</itunes:author>
+    <author>desk@example.com (Jo Reporter)</author>
+    <pubDate>Mon, 06 May 2024 18:30:00 +0000</pubDate>
+    <media:thumbnail url="https://img.example.com/thumb.jpg"/>
+    <media:group><media:content url="/images/kick.jpg" type="image/jpeg"/></media:group>
+  </item>
+</channel>
+</rss>`,
+		title: "Top Stories",
+		want: []Article{{
+			Author:      "Jo Reporter",
+			Title:       "Cup final goes to penalties",
+			Description: "The final went to penalties again",
+			URL:         "https://news.example.com/sport/cup-final",
+			URLToImage:  "https://news.example.com/images/kick.jpg",
+			PublishedAt: published,
+			Content:     "Full report.",
+		}},
+	}, {
+		name: "rss in latin-1 with html entities",
+		doc: "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n" +
+			"<rss version=\"2.0\"><channel><title>Caf\xe9 Times</title><item>" +
+			"<title>Caf\xe9 opens&nbsp;today</title>" +
+			"<guid>https://cafe.example.com/opening</guid>" +
+			"<description>&lt;img src=&quot;pics/front.png&quot;&gt; Doors open at nine.</description>" +
+			"<pubDate>Mon, 6 May 2024 20:30:00 +0200</pubDate>" +
+			"</item></channel></rss>",
+		title: "Café Times",
+		want: []Article{{
+			Title:       "Café opens today",
+			Description: "Doors open at nine.",
+			URL:         "https://cafe.example.com/opening",
+			URLToImage:  "https://news.example.com/feeds/pics/front.png",
+			PublishedAt: published,
+			Content:     "Doors open at nine.",
+		}},
+	}, {
+		name: "rss 1.0",
+		doc: `<?xml version="1.0"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/"
+         xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel rdf:about="https://news.example.com/"><title>Old School</title></channel>
+  <item rdf:about="https://news.example.com/rdf-story">
+    <title>An RDF story</title>
+    <link>https://news.example.com/rdf-story</link>
+    <dc:creator>Ann Archivist</dc:creator>
+    <dc:date>2024-05-06T18:30:00Z</dc:date>
+    <enclosure url="https://img.example.com/rdf.gif" type="image/gif"/>
+  </item>
+</rdf:RDF>`,
+		title: "Old School",
+		want: []Article{{
+			Author:      "Ann Archivist",
+			Title:       "An RDF story",
+			URL:         "https://news.example.com/rdf-story",
+			URLToImage:  "https://img.example.com/rdf.gif",
+			PublishedAt: published,
+		}},
+	}, {
+		name: "atom",
+		doc: `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/">
+  <title type="html">Tech &amp;amp; Notes</title>
+  <entry>
+    <media:title>Gopher mascot</media:title>
+    <title>Release day</title>
+    <link rel="self" href="https://news.example.com/api/entry/1"/>
+    <link href="/tech/release-day"/>
+    <link rel="enclosure" type="image/png" href="/img/gopher.png"/>
+    <content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>Version <em>two</em> is out.</p></div></content>
+    <author><name>Sam Writer</name></author>
+    <author><name>Lee Editor</name></author>
+    <updated>2024-05-06T20:30:00+02:00</updated>
+  </entry>
+  <entry>
+    <title>Untimed note</title>
+    <id>urn:uuid:1</id>
+    <link rel="alternate" href="https://elsewhere.example.com/note"/>
+    <summary>&lt;img src='https://img.example.com/note.jpg'&gt;A note.</summary>
+  </entry>
+</feed>`,
+		title: "Tech & Notes",
+		want: []Article{{
+			Author:      "Sam Writer, Lee Editor",
+			Title:       "Release day",
+			Description: "Version two is out.",
+			URL:         "https://news.example.com/tech/release-day",
+			URLToImage:  "https://news.example.com/img/gopher.png",
+			PublishedAt: published,
+			Content:     "Version two is out.",
+		}, {
+			Title:       "Untimed note",
+			Description: "A note.",
+			URL:         "https://elsewhere.example.com/note",
+			URLToImage:  "https://img.example.com/note.jpg",
+			Content:     "A note.",
+		}},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := parseFeed(strings.NewReader(tt.doc), base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if feed.Title != tt.title {
+				t.Errorf("title = %q, want %q", feed.Title, tt.title)
+			}
+			if !reflect.DeepEqual(feed.Articles, tt.want) {
+				t.Errorf("articles =\n%+v\nwant\n%+v", feed.Articles, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFeedRejects(t *testing.T) {
+	for name, doc := range map[string]string{
+		"html page": "<!DOCTYPE html><html><body>Moved</body></html>",
+		"empty":     "",
+		"json":      `{"items": []}`,
+	} {
+		if _, err := parseFeed(strings.NewReader(doc), nil); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(2024, 5, 6, 18, 30, 0, 0, time.UTC)
+	for _, s := range []string{
+		"Mon, 06 May 2024 18:30:00 +0000",
+		"Mon, 06 May 2024 18:30:00 GMT",
+		"Mon, 6 May 2024 18:30:00 +0000",
+		"Mon, 6 May 2024 20:30 +0200",
+		"Mon, 06 May 2024 14:30 -0400",
+		"6 May 2024 18:30:00 +0000",
+		"06 May 2024 18:30:00 +0000",
+		"2024-05-06T18:30:00Z",
+		"2024-05-06T20:30:00+02:00",
+		"2024-05-06T18:30:00",
+		"  2024-05-06T18:30:00Z\n",
+	} {
+		if got := parseTime(s); !got.Equal(want) {
+			t.Errorf("parseTime(%q) = %v, want %v", s, got, want)
+		}
+	}
+	if got := parseTime("2024-05-06"); !got.Equal(time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("parseTime(date only) = %v", got)
+	}
+	for _, s := range []string{"", "yesterday", "06/05/2024"} {
+		if got := parseTime(s); !got.IsZero() {
+			t.Errorf("parseTime(%q) = %v, want the zero time", s, got)
+		}
+	}
+}
+
+func TestRSSAuthor(t *testing.T) {
+	for in, want := range map[string]string{
+		"desk@example.com (Jo Reporter)": "Jo Reporter",
+		"Jo Reporter":                    "Jo Reporter",
+		"desk@example.com":               "desk@example.com",
+		"":                               "",
+	} {
+		if got := rssAuthor(in); got != want {
+			t.Errorf("rssAuthor(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
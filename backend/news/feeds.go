@@ -0,0 +1,380 @@
+package news
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/logging"
+	"backend/metrics"
+	"backend/validate"
+)
+
+// Feed is one RSS or Atom feed and what it covers. An empty Country or
+// Language means the feed is not specific to one.
+type Feed struct {
+	URL      string `json:"url"`
+	Category string `json:"category"`
+	Country  string `json:"country,omitempty"`
+	Language string `json:"language,omitempty"`
+	// Source names the publisher; the feed's own title is used when empty
+	Source string `json:"source,omitempty"`
+}
+
+// LoadFeeds reads a JSON array of feeds from path, such as
+//
+//	[{"url": "https://feeds.bbci.co.uk/news/technology/rss.xml",
+//	  "category": "technology", "country": "gb", "language": "en",
+//	  "source": "BBC News"}]
+func LoadFeeds(path string) ([]Feed, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var feeds []Feed
+	if err := json.Unmarshal(b, &feeds); err != nil {
+		return nil, fmt.Errorf("reading feeds from %s: %w", path, err)
+	}
+	v := validate.New()
+	v.Assert(len(feeds) > 0, "feeds", "required", "must list at least one feed")
+	for i, f := range feeds {
+		field := fmt.Sprintf("[%d].", i)
+		v.Check(field+"url", f.URL, validate.Required, validate.URL)
+		v.Check(field+"category", f.Category, validate.Required, validate.OneOf(Categories...))
+		v.Check(field+"country", f.Country, validate.Country)
+		v.Check(field+"language", f.Language, validate.OneOf(Languages...))
+		feeds[i].Category = strings.ToLower(f.Category)
+		feeds[i].Country = strings.ToLower(f.Country)
+		feeds[i].Language = strings.ToLower(f.Language)
+	}
+	if err := v.Err(); err != nil {
+		return nil, fmt.Errorf("feeds in %s: %w", path, err)
+	}
+	return feeds, nil
+}
+
+// How many articles a query returns unless it asks, and at most, as with
+// NewsAPI
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Feeds serves articles from RSS and Atom feeds. Each feed is fetched at
+// most once per refresh interval, with a conditional GET so an unchanged
+// feed costs a 304, and kept in memory in between.
+type Feeds struct {
+	client  *http.Client
+	refresh time.Duration
+	feeds   []*feedState
+}
+
+// feedState is a feed and what was last fetched from it. Its mutex lets
+// one request refresh the feed while others wait for the result.
+type feedState struct {
+	Feed
+	base *url.URL
+
+	mu sync.Mutex
+	// checked is when the feed was last fetched or tried, fetched when a
+	// copy was last received or confirmed unchanged
+	checked      time.Time
+	fetched      time.Time
+	err          error // why the feed has no copy yet
+	etag         string
+	lastModified string
+	title        string
+	articles     []Article
+}
+
+// NewFeeds returns a provider over feeds, refetching each at most every
+// refresh. client's Timeout bounds each fetch; a nil client gets one with a
+// 10 second timeout.
+func NewFeeds(feeds []Feed, client *http.Client, refresh time.Duration) *Feeds {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	p := &Feeds{client: client, refresh: refresh}
+	for _, f := range feeds {
+		base, _ := url.Parse(f.URL)
+		p.feeds = append(p.feeds, &feedState{Feed: f, base: base})
+	}
+	return p
+}
+
+func (p *Feeds) TopHeadlines(ctx context.Context, q HeadlinesQuery) ([]Article, error) {
+	feeds := p.matching(func(f Feed) bool {
+		return (q.Category == "" || strings.EqualFold(f.Category, q.Category)) &&
+			(q.Country == "" || f.Country == "" || strings.EqualFold(f.Country, q.Country))
+	})
+	articles, err := p.articles(ctx, feeds)
+	if err != nil {
+		return nil, err
+	}
+	terms := searchTerms(q.Query)
+	articles = slices.DeleteFunc(articles, func(a Article) bool { return !matchesTerms(a.Title, terms) })
+	return page(articles, q.Page, q.PageSize), nil
+}
+
+// Everything searches every feed. Terms match the text and the URL, so an
+// article can be found by its slug. Feeds carry no popularity or relevance,
+// so results always come newest first whatever SortBy asks for.
+func (p *Feeds) Everything(ctx context.Context, q SearchQuery) ([]Article, error) {
+	feeds := p.matching(func(f Feed) bool {
+		return q.Language == "" || f.Language == "" || strings.EqualFold(f.Language, q.Language)
+	})
+	articles, err := p.articles(ctx, feeds)
+	if err != nil {
+		return nil, err
+	}
+	terms := searchTerms(q.Query)
+	articles = slices.DeleteFunc(articles, func(a Article) bool {
+		return (len(q.Sources) > 0 && !slices.Contains(q.Sources, a.Source.ID)) ||
+			(!q.From.IsZero() && a.PublishedAt.Before(q.From)) ||
+			(!q.To.IsZero() && a.PublishedAt.After(q.To)) ||
+			(len(q.Domains) > 0 && !inDomains(a.URL, q.Domains)) ||
+			!matchesTerms(a.Title+" "+a.Description+" "+a.Content+" "+a.URL, terms)
+	})
+	return page(articles, q.Page, q.PageSize), nil
+}
+
+// Sources lists the configured publishers. Feeds without a Source are
+// named by their title, so they appear once they have been fetched.
+func (p *Feeds) Sources(ctx context.Context, q SourcesQuery) ([]Source, error) {
+	var sources []Source
+	seen := map[string]bool{}
+	for _, f := range p.feeds {
+		if (q.Category != "" && !strings.EqualFold(f.Category, q.Category)) ||
+			(q.Country != "" && !strings.EqualFold(f.Country, q.Country)) ||
+			(q.Language != "" && !strings.EqualFold(f.Language, q.Language)) {
+			continue
+		}
+		f.mu.Lock()
+		name := f.sourceName()
+		f.mu.Unlock()
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		home := ""
+		if f.base != nil {
+			home = f.base.Scheme + "://" + f.base.Host
+		}
+		sources = append(sources, Source{
+			ID:       sourceID(name),
+			Name:     name,
+			URL:      home,
+			Category: f.Category,
+			Language: f.Language,
+			Country:  f.Country,
+		})
+	}
+	return sources, nil
+}
+
+func (p *Feeds) matching(keep func(Feed) bool) []*feedState {
+	var feeds []*feedState
+	for _, f := range p.feeds {
+		if keep(f.Feed) {
+			feeds = append(feeds, f)
+		}
+	}
+	return feeds
+}
+
+// articles gathers the articles of feeds, newest first and each URL once.
+// Feeds are fetched in parallel; one that fails is left out, and only
+// when every feed failed is the query an error.
+func (p *Feeds) articles(ctx context.Context, feeds []*feedState) ([]Article, error) {
+	results := make([][]Article, len(feeds))
+	errs := make([]error, len(feeds))
+	var wg sync.WaitGroup
+	for i, f := range feeds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = p.load(ctx, f)
+		}()
+	}
+	wg.Wait()
+
+	var articles []Article
+	var failed []error
+	seen := map[string]bool{}
+	for i, f := range feeds {
+		if errs[i] != nil {
+			slog.WarnContext(ctx, "fetching feed failed", "feed", f.URL, logging.Err(errs[i]))
+			failed = append(failed, errs[i])
+			continue
+		}
+		for _, a := range results[i] {
+			if a.URL == "" || seen[a.URL] {
+				continue
+			}
+			seen[a.URL] = true
+			articles = append(articles, a)
+		}
+	}
+	if len(failed) > 0 && len(failed) == len(feeds) {
+		return nil, errors.Join(failed...)
+	}
+	// Undated articles go last
+	slices.SortStableFunc(articles, func(a, b Article) int { return b.PublishedAt.Compare(a.PublishedAt) })
+	return articles, nil
+}
+
+// load returns f's articles, fetching the feed again when it was last
+// checked longer ago than the refresh interval. A failed fetch counts as a
+// check too, so a feed that is down is not retried on every request; until
+// the next try, articles fetched before are served, or the error when there
+// are none.
+func (p *Feeds) load(ctx context.Context, f *feedState) ([]Article, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.checked.IsZero() && time.Since(f.checked) < p.refresh {
+		if f.fetched.IsZero() {
+			return nil, f.err
+		}
+		return f.labelled(), nil
+	}
+	if err := p.fetch(ctx, f); err != nil {
+		// A request that gave up says nothing about the feed
+		if ctx.Err() == nil {
+			f.checked = time.Now()
+		}
+		if f.fetched.IsZero() {
+			f.err = err
+			return nil, err
+		}
+		slog.WarnContext(ctx, "refreshing feed failed, serving the last copy", "feed", f.URL, "fetched", f.fetched, logging.Err(err))
+	}
+	return f.labelled(), nil
+}
+
+// fetch gets the feed with a conditional GET. f.mu is held.
+func (p *Feeds) fetch(ctx context.Context, f *feedState) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.9, */*;q=0.1")
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.lastModified)
+	}
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		metrics.ObserveOutbound("feeds", "fetch", metrics.OutcomeNetworkError, start)
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && !f.fetched.IsZero():
+		metrics.ObserveOutbound("feeds", "fetch", metrics.OutcomeNotModified, start)
+		f.checked = time.Now()
+		f.fetched = f.checked
+		return nil
+	case resp.StatusCode != http.StatusOK:
+		metrics.ObserveOutbound("feeds", "fetch", metrics.OutcomeUpstreamError, start)
+		return &Error{Provider: f.URL, Status: resp.StatusCode}
+	}
+	parsed, err := parseFeed(io.LimitReader(resp.Body, maxResponseBytes), f.base)
+	if err != nil {
+		metrics.ObserveOutbound("feeds", "fetch", metrics.OutcomeDecodeError, start)
+		return fmt.Errorf("parsing feed %s: %w", f.URL, err)
+	}
+	metrics.ObserveOutbound("feeds", "fetch", metrics.OutcomeSuccess, start)
+	f.checked = time.Now()
+	f.fetched = f.checked
+	f.err = nil
+	f.etag = resp.Header.Get("ETag")
+	f.lastModified = resp.Header.Get("Last-Modified")
+	f.title = parsed.Title
+	f.articles = parsed.Articles
+	return nil
+}
+
+// sourceName is the configured source, or the feed's title. f.mu is held.
+func (f *feedState) sourceName() string {
+	if f.Source != "" {
+		return f.Source
+	}
+	return f.title
+}
+
+// labelled returns f's articles with its source set. f.mu is held.
+func (f *feedState) labelled() []Article {
+	name := f.sourceName()
+	articles := slices.Clone(f.articles)
+	for i := range articles {
+		articles[i].Source = Source{ID: sourceID(name), Name: name}
+	}
+	return articles
+}
+
+// sourceID makes an ID from a source name the way NewsAPI's look:
+// "BBC News" becomes "bbc-news".
+func sourceID(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r > 0x7f)
+	}), "-")
+}
+
+// searchTerms splits a query into lower-case words, all of which must
+// appear in a match.
+func searchTerms(q string) []string {
+	return strings.Fields(strings.ToLower(q))
+}
+
+func matchesTerms(text string, terms []string) bool {
+	text = strings.ToLower(text)
+	for _, t := range terms {
+		if !strings.Contains(text, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// inDomains reports whether rawURL is on one of domains or a subdomain.
+func inDomains(rawURL string, domains []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// page returns one page of articles; pages count from 1.
+func page(articles []Article, number, size int) []Article {
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	size = min(size, maxPageSize)
+	number = max(number, 1)
+	start := (number - 1) * size
+	if start >= len(articles) {
+		return []Article{}
+	}
+	return articles[start:min(start+size, len(articles))]
+}
@@ -0,0 +1,116 @@
+package news
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testFeed = `<rss version="2.0"><channel><title>Desk</title>
+<item><title>Story</title><link>https://news.example.com/story</link></item>
+</channel></rss>`
+
+// feedServer serves testFeed with validators, or fails with status when it
+// is set, and records the conditional headers of each request.
+type feedServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []http.Header
+}
+
+func newFeedServer(t *testing.T) *feedServer {
+	s := &feedServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, r.Header.Clone())
+		if s.status != 0 {
+			w.WriteHeader(s.status)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 06 May 2024 18:30:00 GMT")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(testFeed))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *feedServer) fail(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *feedServer) headers() []http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func headlines(t *testing.T, p *Feeds) ([]Article, error) {
+	t.Helper()
+	return p.TopHeadlines(context.Background(), HeadlinesQuery{Category: "general"})
+}
+
+func TestFeedsConditionalGet(t *testing.T) {
+	server := newFeedServer(t)
+	p := NewFeeds([]Feed{{URL: server.URL, Category: "general"}}, server.Client(), time.Nanosecond)
+
+	for range 2 {
+		articles, err := headlines(t, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(articles) != 1 || articles[0].Title != "Story" || articles[0].Source.Name != "Desk" {
+			t.Fatalf("articles = %+v", articles)
+		}
+	}
+	requests := server.headers()
+	if len(requests) != 2 {
+		t.Fatalf("feed fetched %d times, want 2", len(requests))
+	}
+	if requests[0].Get("If-None-Match") != "" {
+		t.Errorf("first fetch was conditional")
+	}
+	if requests[1].Get("If-None-Match") != `"v1"` || requests[1].Get("If-Modified-Since") != "Mon, 06 May 2024 18:30:00 GMT" {
+		t.Errorf("refetch sent If-None-Match %q and If-Modified-Since %q", requests[1].Get("If-None-Match"), requests[1].Get("If-Modified-Since"))
+	}
+}
+
+func TestFeedsServeLastCopyOnFailure(t *testing.T) {
+	server := newFeedServer(t)
+	p := NewFeeds([]Feed{{URL: server.URL, Category: "general"}}, server.Client(), time.Nanosecond)
+	if _, err := headlines(t, p); err != nil {
+		t.Fatal(err)
+	}
+	server.fail(http.StatusBadGateway)
+	articles, err := headlines(t, p)
+	if err != nil || len(articles) != 1 {
+		t.Fatalf("after a failed refresh got %+v, %v; want the last copy", articles, err)
+	}
+}
+
+func TestFeedsBackOffAfterFailure(t *testing.T) {
+	server := newFeedServer(t)
+	server.fail(http.StatusServiceUnavailable)
+	p := NewFeeds([]Feed{{URL: server.URL, Category: "general"}}, server.Client(), time.Hour)
+
+	for range 3 {
+		if _, err := headlines(t, p); err == nil {
+			t.Fatal("a feed that never answered served articles")
+		}
+	}
+	if n := len(server.headers()); n != 1 {
+		t.Fatalf("failing feed fetched %d times within the refresh interval, want 1", n)
+	}
+}
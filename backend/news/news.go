@@ -0,0 +1,121 @@
+// Package news fetches articles from news providers. Handlers work with
+// the Provider interface and the Article model; each provider maps its own
+// API or feed format onto them.
+package news
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"backend/config"
+	"backend/tracing"
+)
+
+// Article is a news story as the API serves it. The JSON shape follows
+// NewsAPI's, which the app was written against.
+type Article struct {
+	Source      Source    `json:"source"`
+	Author      string    `json:"author,omitempty"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	URL         string    `json:"url"`
+	URLToImage  string    `json:"urlToImage,omitempty"`
+	PublishedAt time.Time `json:"publishedAt,omitzero"`
+	Content     string    `json:"content,omitempty"`
+}
+
+// Source is a publisher. Articles only carry its ID and name; the rest is
+// filled in when sources are listed.
+type Source struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Category    string `json:"category,omitempty"`
+	Language    string `json:"language,omitempty"`
+	Country     string `json:"country,omitempty"`
+}
+
+// HeadlinesQuery selects top headlines. Empty fields are not filtered on.
+type HeadlinesQuery struct {
+	Country  string // ISO 3166-1 alpha-2 code
+	Category string // one of Categories
+	Query    string
+	PageSize int
+	Page     int
+}
+
+// SearchQuery searches every article a provider has.
+type SearchQuery struct {
+	Query    string
+	Sources  []string // source IDs
+	Domains  []string
+	From, To time.Time
+	Language string // one of Languages
+	SortBy   string // one of SortOrders
+	PageSize int
+	Page     int
+}
+
+// SourcesQuery filters the publishers a provider knows.
+type SourcesQuery struct {
+	Country  string
+	Category string
+	Language string
+}
+
+// Provider is a source of articles.
+type Provider interface {
+	TopHeadlines(ctx context.Context, q HeadlinesQuery) ([]Article, error)
+	Everything(ctx context.Context, q SearchQuery) ([]Article, error)
+	Sources(ctx context.Context, q SourcesQuery) ([]Source, error)
+}
+
+// FromConfig builds the provider selected by NEWS_PROVIDER: the NewsAPI
+// client, or the feeds listed in NEWS_FEEDS_FILE.
+func FromConfig(cfg *config.Config) (Provider, error) {
+	switch cfg.News.Provider {
+	case "newsapi":
+		return NewNewsAPI(cfg.NewsAPI.BaseURL, cfg.NewsAPI.Key, tracing.HTTPClient("newsapi", cfg.NewsAPI.Timeout)), nil
+	case "rss":
+		feeds, err := LoadFeeds(cfg.News.FeedsFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewFeeds(feeds, tracing.HTTPClient("feeds", cfg.News.FeedsTimeout), cfg.News.FeedsRefresh), nil
+	default:
+		return nil, fmt.Errorf("unknown NEWS_PROVIDER %q (want newsapi or rss)", cfg.News.Provider)
+	}
+}
+
+// What queries may ask for, matching NewsAPI's parameters
+var (
+	Categories = []string{"business", "entertainment", "general", "health", "science", "sports", "technology"}
+	Languages  = []string{"ar", "de", "en", "es", "fr", "he", "it", "nl", "no", "pt", "ru", "sv", "ud", "zh"}
+	SortOrders = []string{"relevancy", "popularity", "publishedAt"}
+)
+
+// ErrNotConfigured is returned by a provider that lacks its credentials.
+var ErrNotConfigured = errors.New("news provider is not configured")
+
+// Error is a failure reported by the provider's API. Its message may quote
+// the provider and is for logs, not clients.
+type Error struct {
+	Provider string
+	Status   int
+	Code     string
+	Message  string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s answered %d", e.Provider, e.Status)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
@@ -0,0 +1,178 @@
+package news
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/metrics"
+)
+
+// maxResponseBytes bounds how much of a NewsAPI response is read.
+const maxResponseBytes = 8 << 20
+
+// NewsAPI is the newsapi.org client.
+type NewsAPI struct {
+	baseURL string
+	key     string
+	client  *http.Client
+}
+
+// NewNewsAPI returns a client for the NewsAPI at baseURL, such as
+// https://newsapi.org. The key is sent in a header, keeping it out of URLs,
+// logs and trace attributes. client's Timeout bounds each call; a nil client
+// gets one with a 10 second timeout.
+func NewNewsAPI(baseURL, key string, client *http.Client) *NewsAPI {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &NewsAPI{baseURL: strings.TrimRight(baseURL, "/"), key: key, client: client}
+}
+
+func (n *NewsAPI) TopHeadlines(ctx context.Context, q HeadlinesQuery) ([]Article, error) {
+	params := url.Values{}
+	set(params, "country", q.Country)
+	set(params, "category", q.Category)
+	set(params, "q", q.Query)
+	setInt(params, "pageSize", q.PageSize)
+	setInt(params, "page", q.Page)
+	var resp articlesResponse
+	if err := n.get(ctx, "/v2/top-headlines", "top_headlines", params, &resp); err != nil {
+		return nil, err
+	}
+	return resp.articles(), nil
+}
+
+func (n *NewsAPI) Everything(ctx context.Context, q SearchQuery) ([]Article, error) {
+	params := url.Values{}
+	set(params, "q", q.Query)
+	set(params, "sources", strings.Join(q.Sources, ","))
+	set(params, "domains", strings.Join(q.Domains, ","))
+	setTime(params, "from", q.From)
+	setTime(params, "to", q.To)
+	set(params, "language", q.Language)
+	set(params, "sortBy", q.SortBy)
+	setInt(params, "pageSize", q.PageSize)
+	setInt(params, "page", q.Page)
+	var resp articlesResponse
+	if err := n.get(ctx, "/v2/everything", "everything", params, &resp); err != nil {
+		return nil, err
+	}
+	return resp.articles(), nil
+}
+
+func (n *NewsAPI) Sources(ctx context.Context, q SourcesQuery) ([]Source, error) {
+	params := url.Values{}
+	set(params, "country", q.Country)
+	set(params, "category", q.Category)
+	set(params, "language", q.Language)
+	var resp struct {
+		Sources []Source `json:"sources"`
+	}
+	if err := n.get(ctx, "/v2/top-headlines/sources", "sources", params, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Sources, nil
+}
+
+// get calls one endpoint and decodes its answer into dst, recording the
+// outcome under operation.
+func (n *NewsAPI) get(ctx context.Context, path, operation string, params url.Values, dst any) error {
+	if n.key == "" {
+		return ErrNotConfigured
+	}
+	u := n.baseURL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", n.key)
+	start := time.Now()
+	resp, err := n.client.Do(req)
+	if err != nil {
+		metrics.ObserveOutbound("newsapi", operation, metrics.OutcomeNetworkError, start)
+		return err
+	}
+	defer resp.Body.Close()
+	body := io.LimitReader(resp.Body, maxResponseBytes)
+	if resp.StatusCode != http.StatusOK {
+		metrics.ObserveOutbound("newsapi", operation, metrics.OutcomeUpstreamError, start)
+		apiErr := &Error{Provider: "NewsAPI", Status: resp.StatusCode}
+		// NewsAPI explains failures as {"status": "error", "code", "message"}
+		var explained struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(io.LimitReader(body, 4096)).Decode(&explained) == nil {
+			apiErr.Code, apiErr.Message = explained.Code, explained.Message
+		}
+		return apiErr
+	}
+	if err := json.NewDecoder(body).Decode(dst); err != nil {
+		metrics.ObserveOutbound("newsapi", operation, metrics.OutcomeDecodeError, start)
+		return fmt.Errorf("decoding NewsAPI %s response: %w", operation, err)
+	}
+	metrics.ObserveOutbound("newsapi", operation, metrics.OutcomeSuccess, start)
+	return nil
+}
+
+// articlesResponse is NewsAPI's article list. Dates are read as strings so
+// one malformed date does not fail the whole response.
+type articlesResponse struct {
+	Articles []struct {
+		Source      Source `json:"source"`
+		Author      string `json:"author"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		URL         string `json:"url"`
+		URLToImage  string `json:"urlToImage"`
+		PublishedAt string `json:"publishedAt"`
+		Content     string `json:"content"`
+	} `json:"articles"`
+}
+
+func (r *articlesResponse) articles() []Article {
+	articles := make([]Article, len(r.Articles))
+	for i, a := range r.Articles {
+		published, _ := time.Parse(time.RFC3339, a.PublishedAt)
+		articles[i] = Article{
+			Source:      Source{ID: a.Source.ID, Name: a.Source.Name},
+			Author:      a.Author,
+			Title:       a.Title,
+			Description: a.Description,
+			URL:         a.URL,
+			URLToImage:  a.URLToImage,
+			PublishedAt: published,
+			Content:     a.Content,
+		}
+	}
+	return articles
+}
+
+func set(params url.Values, key, value string) {
+	if value != "" {
+		params.Set(key, value)
+	}
+}
+
+func setInt(params url.Values, key string, n int) {
+	if n > 0 {
+		params.Set(key, strconv.Itoa(n))
+	}
+}
+
+// setTime sends t in the ISO 8601 form NewsAPI documents, in UTC.
+func setTime(params url.Values, key string, t time.Time) {
+	if !t.IsZero() {
+		params.Set(key, t.UTC().Format("2006-01-02T15:04:05"))
+	}
+}
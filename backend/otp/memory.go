@@ -0,0 +1,149 @@
+package otp
+
+import (
+	"context"
+	"crypto/hmac"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a process-local Store for tests and single-instance runs.
+type MemoryStore struct {
+	mu       sync.Mutex
+	records  map[string]Record
+	counters map[string]memoryCounter
+}
+
+type memoryCounter struct {
+	count   int
+	resetAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record), counters: make(map[string]memoryCounter)}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(time.Now())
+	s.records[recordKey(rec.Purpose, rec.Email)] = rec
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, purpose Purpose, email string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := recordKey(purpose, email)
+	rec, ok := s.records[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if time.Now().After(rec.ExpiresAt) {
+		delete(s.records, key)
+		return nil, ErrExpired
+	}
+	return &rec, nil
+}
+
+func (s *MemoryStore) Attempt(ctx context.Context, purpose Purpose, email string, maxAttempts int) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := recordKey(purpose, email)
+	rec, ok := s.records[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if time.Now().After(rec.ExpiresAt) {
+		delete(s.records, key)
+		return nil, ErrExpired
+	}
+	if rec.Attempts >= maxAttempts {
+		delete(s.records, key)
+		return nil, ErrTooManyAttempts
+	}
+	rec.Attempts++
+	s.records[key] = rec
+	return &rec, nil
+}
+
+func (s *MemoryStore) Consume(ctx context.Context, purpose Purpose, email, codeHash string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := recordKey(purpose, email)
+	rec, ok := s.records[key]
+	if !ok || !hmac.Equal([]byte(rec.CodeHash), []byte(codeHash)) {
+		return nil, ErrNotFound
+	}
+	delete(s.records, key)
+	if time.Now().After(rec.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	return &rec, nil
+}
+
+func (s *MemoryStore) Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.resetAt) {
+		c = memoryCounter{resetAt: now.Truncate(window).Add(window)}
+	}
+	c.count++
+	s.counters[key] = c
+	return c.count, c.resetAt, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, purpose Purpose, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, recordKey(purpose, email))
+	return nil
+}
+
+func (s *MemoryStore) Count(ctx context.Context) (map[Purpose]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	counts := map[Purpose]int{}
+	for _, rec := range s.records {
+		if now.Before(rec.ExpiresAt) {
+			counts[rec.Purpose]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *MemoryStore) Flush(ctx context.Context, email string) (codes, counters int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, rec := range s.records {
+		if email == "" || rec.Email == email {
+			delete(s.records, key)
+			codes++
+		}
+	}
+	for key := range s.counters {
+		if email == "" || isEmailCounter(key, email) {
+			delete(s.counters, key)
+			counters++
+		}
+	}
+	return codes, counters, nil
+}
+
+// sweep drops lapsed records so abandoned codes do not accumulate.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, rec := range s.records {
+		if now.After(rec.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+	for key, c := range s.counters {
+		if !now.Before(c.resetAt) {
+			delete(s.counters, key)
+		}
+	}
+}
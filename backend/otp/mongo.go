@@ -0,0 +1,176 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	CollectionName         = "otps"
+	CountersCollectionName = "otp_counters"
+)
+
+// MongoStore keeps codes in a collection shared by every backend instance.
+// A TTL index on expiresAt lets Mongo delete lapsed codes on its own.
+type MongoStore struct {
+	col      *mongo.Collection
+	counters *mongo.Collection
+}
+
+type mongoRecord struct {
+	ID     string `bson:"_id"`
+	Record `bson:",inline"`
+}
+
+// NewMongoStore returns a store over db's otps and otp_counters
+// collections, creating their TTL indexes if they do not exist yet.
+func NewMongoStore(ctx context.Context, db *mongo.Database) (*MongoStore, error) {
+	s := &MongoStore{col: db.Collection(CollectionName), counters: db.Collection(CountersCollectionName)}
+	for _, col := range []*mongo.Collection{s.col, s.counters} {
+		_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *MongoStore) Put(ctx context.Context, rec Record) error {
+	doc := mongoRecord{ID: recordKey(rec.Purpose, rec.Email), Record: rec}
+	_, err := s.col.ReplaceOne(ctx, bson.M{"_id": doc.ID}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *MongoStore) Get(ctx context.Context, purpose Purpose, email string) (*Record, error) {
+	var doc mongoRecord
+	err := s.col.FindOne(ctx, bson.M{"_id": recordKey(purpose, email)}).Decode(&doc)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	// The TTL monitor only runs about once a minute
+	if time.Now().After(doc.ExpiresAt) {
+		s.Delete(ctx, purpose, email)
+		return nil, ErrExpired
+	}
+	return &doc.Record, nil
+}
+
+func (s *MongoStore) Attempt(ctx context.Context, purpose Purpose, email string, maxAttempts int) (*Record, error) {
+	id := recordKey(purpose, email)
+	var doc mongoRecord
+	err := s.col.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "attempts": bson.M{"$lt": maxAttempts}},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Either there is no code or its tries are used up
+		if _, err := s.Get(ctx, purpose, email); err != nil {
+			return nil, err
+		}
+		s.Delete(ctx, purpose, email)
+		return nil, ErrTooManyAttempts
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(doc.ExpiresAt) {
+		s.Delete(ctx, purpose, email)
+		return nil, ErrExpired
+	}
+	return &doc.Record, nil
+}
+
+func (s *MongoStore) Consume(ctx context.Context, purpose Purpose, email, codeHash string) (*Record, error) {
+	var doc mongoRecord
+	err := s.col.FindOneAndDelete(ctx, bson.M{"_id": recordKey(purpose, email), "codeHash": codeHash}).Decode(&doc)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if time.Now().After(doc.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	return &doc.Record, nil
+}
+
+func (s *MongoStore) Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	start := time.Now().Truncate(window)
+	resetAt := start.Add(window)
+	var doc struct {
+		Count int `bson:"count"`
+	}
+	err := s.counters.FindOneAndUpdate(ctx,
+		bson.M{"_id": key + "@" + strconv.FormatInt(start.Unix(), 10)},
+		bson.M{"$inc": bson.M{"count": 1}, "$setOnInsert": bson.M{"expiresAt": resetAt}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&doc)
+	if err != nil {
+		return 0, resetAt, err
+	}
+	return doc.Count, resetAt, nil
+}
+
+func (s *MongoStore) Delete(ctx context.Context, purpose Purpose, email string) error {
+	_, err := s.col.DeleteOne(ctx, bson.M{"_id": recordKey(purpose, email)})
+	return err
+}
+
+func (s *MongoStore) Count(ctx context.Context) (map[Purpose]int, error) {
+	// The TTL monitor runs about once a minute, so filter lapsed records out
+	cur, err := s.col.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"expiresAt": bson.M{"$gt": time.Now()}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$purpose", "n": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var rows []struct {
+		Purpose Purpose `bson:"_id"`
+		N       int     `bson:"n"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	counts := make(map[Purpose]int, len(rows))
+	for _, row := range rows {
+		counts[row.Purpose] = row.N
+	}
+	return counts, nil
+}
+
+func (s *MongoStore) Flush(ctx context.Context, email string) (codes, counters int, err error) {
+	codeFilter, counterFilter := bson.M{}, bson.M{}
+	if email != "" {
+		codeFilter = bson.M{"email": email}
+		// Counter IDs are the Hit key and the window start
+		counterFilter = bson.M{"_id": primitive.Regex{Pattern: "^email:[^:]+:" + regexp.QuoteMeta(email) + "@[0-9]+$"}}
+	}
+	res, err := s.col.DeleteMany(ctx, codeFilter)
+	if err != nil {
+		return 0, 0, err
+	}
+	codes = int(res.DeletedCount)
+	res, err = s.counters.DeleteMany(ctx, counterFilter)
+	if err != nil {
+		return codes, 0, err
+	}
+	return codes, int(res.DeletedCount), nil
+}
@@ -0,0 +1,104 @@
+// Package otp stores one-time codes sent by email. Codes are kept only as
+// keyed hashes, and at most one code is outstanding per purpose and email.
+package otp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Purpose separates codes for different flows sent to the same address.
+type Purpose string
+
+const (
+	PurposeSignup        Purpose = "signup"
+	PurposePasswordReset Purpose = "password-reset"
+	// A reset token is issued once the password reset code is verified
+	PurposeResetToken Purpose = "reset-token"
+)
+
+var (
+	ErrNotFound        = errors.New("no OTP requested for this email")
+	ErrExpired         = errors.New("OTP expired")
+	ErrTooManyAttempts = errors.New("too many incorrect OTP attempts")
+)
+
+// Record is an outstanding code. Username and PasswordHash carry a pending
+// signup until its code is verified. Attempts counts verification tries.
+type Record struct {
+	Purpose      Purpose   `bson:"purpose"`
+	Email        string    `bson:"email"`
+	CodeHash     string    `bson:"codeHash"`
+	Attempts     int       `bson:"attempts"`
+	Username     string    `bson:"username,omitempty"`
+	PasswordHash string    `bson:"passwordHash,omitempty"`
+	CreatedAt    time.Time `bson:"createdAt"`
+	ExpiresAt    time.Time `bson:"expiresAt"`
+}
+
+// Store persists outstanding codes.
+type Store interface {
+	// Put replaces any outstanding code for the record's purpose and email.
+	Put(ctx context.Context, rec Record) error
+	// Get returns the outstanding record, ErrExpired if it has lapsed (the
+	// record is then removed) or ErrNotFound.
+	Get(ctx context.Context, purpose Purpose, email string) (*Record, error)
+	// Attempt uses up one of maxAttempts verification tries and returns the
+	// record to check the code against. Taking the attempt before comparing
+	// means parallel guesses cannot outrun the limit. Once the tries are
+	// used up the record is removed and ErrTooManyAttempts returned.
+	Attempt(ctx context.Context, purpose Purpose, email string, maxAttempts int) (*Record, error)
+	// Consume atomically removes and returns the record if its hash is
+	// codeHash, so the code can be used only once. A wrong code leaves the
+	// record in place and returns ErrNotFound.
+	Consume(ctx context.Context, purpose Purpose, email, codeHash string) (*Record, error)
+	Delete(ctx context.Context, purpose Purpose, email string) error
+	// Hit counts one event against key in the fixed window containing now
+	// and returns the window's count so far and when it resets.
+	Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+	// Count returns how many unexpired records are outstanding per purpose.
+	Count(ctx context.Context) (map[Purpose]int, error)
+	// Flush removes the codes and per-email rate limit counters for email,
+	// or every code and counter when email is empty, and returns how many
+	// of each it removed.
+	Flush(ctx context.Context, email string) (codes, counters int, err error)
+}
+
+// HashCode derives the stored form of a code. The purpose and email are
+// mixed in so a hash cannot be replayed against another record.
+func HashCode(secret []byte, purpose Purpose, email, code string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(string(purpose) + "\x00" + email + "\x00" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Matches reports whether code is the one this record was created for.
+func (r *Record) Matches(secret []byte, code string) bool {
+	want := HashCode(secret, r.Purpose, r.Email, code)
+	return hmac.Equal([]byte(want), []byte(r.CodeHash))
+}
+
+// EmailCounterKey is the Hit key that limits how many codes are sent to an
+// address for a purpose.
+func EmailCounterKey(purpose Purpose, email string) string {
+	return "email:" + string(purpose) + ":" + email
+}
+
+// isEmailCounter reports whether key was made by EmailCounterKey for email.
+func isEmailCounter(key, email string) bool {
+	rest, ok := strings.CutPrefix(key, "email:")
+	if !ok {
+		return false
+	}
+	purpose, addr, ok := strings.Cut(rest, ":")
+	return ok && purpose != "" && addr == email
+}
+
+func recordKey(purpose Purpose, email string) string {
+	return string(purpose) + ":" + email
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many takes pass between scans for full buckets.
+const sweepEvery = 1024
+
+// MemoryStore keeps buckets in the process, so each backend instance
+// limits on its own.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]memoryBucket
+	takes   int
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will have refilled, and can be forgotten
+	full time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]memoryBucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.takes++
+	if s.takes%sweepEvery == 0 {
+		s.sweep(now)
+	}
+	key = p.Name + ":" + key
+	b, ok := s.buckets[key]
+	if !ok {
+		b = memoryBucket{tokens: float64(p.Burst), updated: now}
+	}
+	tokens, res := take(b.tokens, b.updated, now, p)
+	s.buckets[key] = memoryBucket{tokens: tokens, updated: now, full: now.Add(p.refill())}
+	return res, nil
+}
+
+// sweep drops buckets that have refilled, since a new bucket starts full.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
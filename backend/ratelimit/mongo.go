@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const CollectionName = "rate_limits"
+
+// MongoStore keeps buckets in a collection shared by every backend
+// instance, so a client's limit holds wherever its requests land. A TTL
+// index drops buckets once they have refilled.
+type MongoStore struct {
+	col *mongo.Collection
+}
+
+// NewMongoStore returns a store over db's rate_limits collection, creating
+// its TTL index if it does not exist yet.
+func NewMongoStore(ctx context.Context, db *mongo.Database) (*MongoStore, error) {
+	s := &MongoStore{col: db.Collection(CollectionName)}
+	_, err := s.col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Take refills and debits the bucket in one pipeline update, so concurrent
+// requests from any instance cannot spend the same token. Time is the
+// server's $$NOW, which keeps instances with skewed clocks consistent.
+func (s *MongoStore) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	burst := float64(p.Burst)
+	elapsed := bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$$NOW", bson.M{"$ifNull": bson.A{"$updatedAt", "$$NOW"}}}}, 1000}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"tokens": bson.M{"$min": bson.A{burst, bson.M{"$add": bson.A{
+				bson.M{"$ifNull": bson.A{"$tokens", burst}},
+				bson.M{"$multiply": bson.A{elapsed, p.Rate}},
+			}}}},
+		}}},
+		{{Key: "$set", Value: bson.M{"allowed": bson.M{"$gte": bson.A{"$tokens", 1}}}}},
+		{{Key: "$set", Value: bson.M{
+			"tokens":    bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}},
+			"updatedAt": "$$NOW",
+			"expiresAt": bson.M{"$add": bson.A{"$$NOW", p.refill().Milliseconds()}},
+		}}},
+	}
+	var doc struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	err := s.col.FindOneAndUpdate(ctx, bson.M{"_id": p.Name + ":" + key}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&doc)
+	if err != nil {
+		return Result{}, err
+	}
+	if doc.Allowed {
+		return Result{Allowed: true, Remaining: int(doc.Tokens)}, nil
+	}
+	// The bucket was left as it was; work out the wait from its level
+	_, res := result(doc.Tokens, p)
+	return res, nil
+}
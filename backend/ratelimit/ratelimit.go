@@ -0,0 +1,58 @@
+// Package ratelimit meters requests with token buckets. Each key gets a
+// bucket holding up to Burst tokens that refills at Rate tokens a second;
+// a request takes one token and is refused when none is left.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Policy sizes the buckets of one group of routes. Its Name namespaces the
+// keys, so one client has separate buckets per policy.
+type Policy struct {
+	Name  string
+	Rate  float64 // tokens added per second
+	Burst int     // bucket size
+}
+
+// refill is how long an empty bucket takes to fill up again; a bucket left
+// alone that long is full and need not be kept.
+func (p Policy) refill() time.Duration {
+	return time.Duration(float64(p.Burst) / p.Rate * float64(time.Second))
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed bool
+	// Remaining is how many whole tokens are left
+	Remaining int
+	// RetryAfter is how long until a token is available when not allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps buckets.
+type Store interface {
+	// Take removes a token from key's bucket under p, if it has one.
+	Take(ctx context.Context, key string, p Policy) (Result, error)
+}
+
+// take applies the token bucket to a bucket last updated at last that held
+// tokens then, and returns the bucket's new level with the result.
+func take(tokens float64, last, now time.Time, p Policy) (float64, Result) {
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens = math.Min(float64(p.Burst), tokens+elapsed*p.Rate)
+	}
+	return result(tokens, p)
+}
+
+// result takes a token from a refilled bucket.
+func result(tokens float64, p Policy) (float64, Result) {
+	if tokens >= 1 {
+		tokens--
+		return tokens, Result{Allowed: true, Remaining: int(tokens)}
+	}
+	wait := (1 - tokens) / p.Rate
+	return tokens, Result{RetryAfter: time.Duration(math.Ceil(wait * float64(time.Second)))}
+}
@@ -0,0 +1,323 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"backend/accounts"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Index is one index the application relies on.
+type Index struct {
+	Collection string
+	Name       string
+	Keys       bson.D
+	Unique     bool
+	// Partial limits the index to matching documents, so unique indexes can
+	// skip records that lack the field.
+	Partial bson.D
+	// ExpireAfter makes a TTL index; documents go once the indexed time is
+	// this many seconds in the past.
+	ExpireAfter *int32
+}
+
+func (i Index) String() string {
+	return i.Collection + "." + i.Name
+}
+
+func (i Index) text() bool {
+	for _, k := range i.Keys {
+		if k.Value == "text" {
+			return true
+		}
+	}
+	return false
+}
+
+func (i Index) model() mongo.IndexModel {
+	opts := options.Index().SetName(i.Name)
+	if i.Unique {
+		opts.SetUnique(true)
+	}
+	if i.Partial != nil {
+		opts.SetPartialFilterExpression(i.Partial)
+	}
+	if i.ExpireAfter != nil {
+		opts.SetExpireAfterSeconds(*i.ExpireAfter)
+	}
+	return mongo.IndexModel{Keys: i.Keys, Options: opts}
+}
+
+var (
+	hasString = func(field string) bson.D {
+		return bson.D{{Key: field, Value: bson.D{{Key: "$type", Value: "string"}}}}
+	}
+	expireAtOnce = int32(0)
+)
+
+// Indexes declares every index outside the OTP and rate limit stores,
+// which manage their own. SyncIndexes makes the database match this list.
+var Indexes = []Index{
+	{Collection: accounts.CollectionName, Name: "email_unique", Keys: bson.D{{Key: "email", Value: 1}}, Unique: true},
+	// Google accounts may have no username yet
+	{Collection: accounts.CollectionName, Name: "username_unique", Keys: bson.D{{Key: "username", Value: 1}}, Unique: true, Partial: hasString("username")},
+	// One provider identity signs in to one account; logins linked before
+	// their subject was known have none
+	{Collection: accounts.CollectionName, Name: "logins_provider_subject", Keys: bson.D{{Key: "logins.provider", Value: 1}, {Key: "logins.subject", Value: 1}}, Unique: true, Partial: hasString("logins.subject")},
+
+	{Collection: BookmarksCollection, Name: "user_article_url_unique", Keys: bson.D{{Key: "user", Value: 1}, {Key: "article.url", Value: 1}}, Unique: true, Partial: hasString("article.url")},
+	{Collection: BookmarksCollection, Name: "user_createdAt", Keys: bson.D{{Key: "user", Value: 1}, {Key: "createdAt", Value: -1}}},
+
+	{Collection: ViewedNewsCollection, Name: "user_article_url_unique", Keys: bson.D{{Key: "user", Value: 1}, {Key: "article.url", Value: 1}}, Unique: true, Partial: hasString("article.url")},
+	{Collection: ViewedNewsCollection, Name: "user_viewedAt", Keys: bson.D{{Key: "user", Value: 1}, {Key: "viewedAt", Value: -1}}},
+
+	{Collection: NewsCollection, Name: "category", Keys: bson.D{{Key: "category", Value: 1}}},
+	{Collection: NewsCollection, Name: "url", Keys: bson.D{{Key: "url", Value: 1}}},
+	{Collection: NewsCollection, Name: "trending", Keys: bson.D{{Key: "trending", Value: 1}}},
+	{Collection: NewsCollection, Name: "search_text", Keys: bson.D{{Key: "title", Value: "text"}, {Key: "description", Value: "text"}, {Key: "category", Value: "text"}}},
+
+	{Collection: SessionsCollection, Name: "email", Keys: bson.D{{Key: "email", Value: 1}}},
+	{Collection: SessionsCollection, Name: "expiresAt_ttl", Keys: bson.D{{Key: "expiresAt", Value: 1}}, ExpireAfter: &expireAtOnce},
+}
+
+// IndexReport says what SyncIndexes did, or would do in a dry run. Entries
+// are "collection.index".
+type IndexReport struct {
+	DryRun    bool     `json:"dryRun"`
+	Created   []string `json:"created"`
+	Rebuilt   []string `json:"rebuilt"`
+	Unchanged []string `json:"unchanged"`
+	// Extra indexes exist but are not declared. They are only dropped
+	// when SyncIndexes is asked to prune.
+	Extra   []string `json:"extra"`
+	Dropped []string `json:"dropped"`
+}
+
+// existingIndex is the part of a listIndexes entry SyncIndexes compares.
+type existingIndex struct {
+	Name        string   `bson:"name"`
+	Key         bson.D   `bson:"key"`
+	Unique      bool     `bson:"unique"`
+	Partial     bson.D   `bson:"partialFilterExpression"`
+	ExpireAfter *float64 `bson:"expireAfterSeconds"`
+	Weights     bson.D   `bson:"weights"`
+}
+
+// matches reports whether the index was built from want's spec.
+func (e existingIndex) matches(want Index) bool {
+	if e.Unique != want.Unique || !sameValue(e.Partial, want.Partial) {
+		return false
+	}
+	if (e.ExpireAfter == nil) != (want.ExpireAfter == nil) || (e.ExpireAfter != nil && *e.ExpireAfter != float64(*want.ExpireAfter)) {
+		return false
+	}
+	if want.text() {
+		// Text indexes list their fields as weights rather than keys
+		fields := map[string]bool{}
+		for _, w := range e.Weights {
+			fields[w.Key] = true
+		}
+		if len(fields) != len(want.Keys) {
+			return false
+		}
+		for _, k := range want.Keys {
+			if !fields[k.Key] {
+				return false
+			}
+		}
+		return true
+	}
+	return sameValue(e.Key, want.Keys)
+}
+
+// conflicts reports whether Mongo would refuse want while e exists: there
+// can only be one index over the same keys, and one text index at all.
+func (e existingIndex) conflicts(want Index) bool {
+	if want.text() {
+		return len(e.Weights) > 0
+	}
+	return sameKeys(e.Key, want.Keys)
+}
+
+// sameKeys reports whether two key specs index the same fields in the same
+// order and direction.
+func sameKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || !sameValue(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameValue compares specs read back from the server with declared ones,
+// treating every numeric type as equal when the values are.
+func sameValue(a, b any) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	if da, ok := a.(bson.D); ok {
+		db, ok := b.(bson.D)
+		return ok && sameKeys(da, db)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// SyncIndexes reconciles db with Indexes: missing indexes are created and
+// ones whose definition changed are dropped and rebuilt. An undeclared
+// index over the same keys as a declared one is replaced by it. Other
+// undeclared indexes are reported, and dropped only when prune is set.
+// Nothing is written in a dry run.
+//
+// A unique index cannot be built while the collection holds duplicates;
+// such failures are returned together once every other index was handled.
+func SyncIndexes(ctx context.Context, db *mongo.Database, dryRun, prune bool) (*IndexReport, error) {
+	report := &IndexReport{DryRun: dryRun}
+	var errs []error
+
+	byCollection := map[string][]Index{}
+	var collections []string
+	for _, idx := range Indexes {
+		if _, seen := byCollection[idx.Collection]; !seen {
+			collections = append(collections, idx.Collection)
+		}
+		byCollection[idx.Collection] = append(byCollection[idx.Collection], idx)
+	}
+
+	for _, name := range collections {
+		col := db.Collection(name)
+		existing, err := listIndexes(ctx, col)
+		if err != nil {
+			return report, fmt.Errorf("listing indexes on %s: %w", name, err)
+		}
+		drop := func(index string) error {
+			if dryRun {
+				return nil
+			}
+			_, err := col.Indexes().DropOne(ctx, index)
+			return err
+		}
+		create := func(idx Index) error {
+			if dryRun {
+				return nil
+			}
+			_, err := col.Indexes().CreateOne(ctx, idx.model())
+			return err
+		}
+
+		declared := map[string]bool{"_id_": true}
+		for _, idx := range byCollection[name] {
+			declared[idx.Name] = true
+		}
+		for _, idx := range byCollection[name] {
+			current, exists := existing[idx.Name]
+			if exists && current.matches(idx) {
+				report.Unchanged = append(report.Unchanged, idx.String())
+				continue
+			}
+			rebuild := exists
+			if !exists {
+				for _, other := range sortedKeys(existing) {
+					if !declared[other] && existing[other].conflicts(idx) {
+						if err := drop(other); err != nil {
+							errs = append(errs, fmt.Errorf("dropping %s.%s to replace it with %s: %w", name, other, idx.Name, err))
+							continue
+						}
+						delete(existing, other)
+						report.Dropped = append(report.Dropped, name+"."+other)
+						rebuild = true
+					}
+				}
+			} else if err := drop(idx.Name); err != nil {
+				errs = append(errs, fmt.Errorf("dropping %s to rebuild it: %w", idx, err))
+				continue
+			}
+			if err := create(idx); err != nil {
+				if mongo.IsDuplicateKeyError(err) {
+					err = fmt.Errorf("%w; remove the duplicate records (`migrate up` does so for bookmarks and viewed news) and run sync-indexes again", err)
+				}
+				errs = append(errs, fmt.Errorf("creating %s: %w", idx, err))
+				continue
+			}
+			if rebuild {
+				report.Rebuilt = append(report.Rebuilt, idx.String())
+			} else {
+				report.Created = append(report.Created, idx.String())
+			}
+		}
+
+		for _, other := range sortedKeys(existing) {
+			if declared[other] {
+				continue
+			}
+			report.Extra = append(report.Extra, name+"."+other)
+			if prune {
+				if err := drop(other); err != nil {
+					errs = append(errs, fmt.Errorf("dropping %s.%s: %w", name, other, err))
+					continue
+				}
+				report.Dropped = append(report.Dropped, name+"."+other)
+			}
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// namespaceNotFound is the server's error code for a missing collection.
+const namespaceNotFound = 26
+
+func sortedKeys(m map[string]existingIndex) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func listIndexes(ctx context.Context, col *mongo.Collection) (map[string]existingIndex, error) {
+	cur, err := col.Indexes().List(ctx)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == namespaceNotFound {
+		// The collection is created along with its first index
+		return map[string]existingIndex{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var list []existingIndex
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	out := make(map[string]existingIndex, len(list))
+	for _, idx := range list {
+		out[idx.Name] = idx
+	}
+	return out, nil
+}
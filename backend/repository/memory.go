@@ -0,0 +1,603 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"backend/accounts"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewMemory returns empty process-local repositories for tests and runs
+// without MongoDB. Topics and curated news can be seeded with Seed.
+func NewMemory() *Repositories {
+	m := &memory{
+		sessions: make(map[string]Session),
+	}
+	return &Repositories{
+		Users:      memoryUsers{m},
+		Bookmarks:  memoryBookmarks{m},
+		ViewedNews: memoryViewedNews{m},
+		Topics:     memoryTopics{m},
+		News:       memoryNews{m},
+		Sessions:   memorySessions{m},
+	}
+}
+
+// Seed replaces the topics and curated news of repositories made by
+// NewMemory. It panics on any other repositories.
+func Seed(r *Repositories, topics, news []Document) {
+	m := r.Topics.(memoryTopics).memory
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.topics = slices.Clone(topics)
+	m.news = slices.Clone(news)
+}
+
+// memory holds every collection behind one lock; it is built for
+// correctness in tests, not throughput.
+type memory struct {
+	mu        sync.Mutex
+	accounts  []*accounts.Account
+	bookmarks []memoryEntry
+	viewed    []memoryEntry
+	topics    []Document
+	news      []Document
+	sessions  map[string]Session
+}
+
+// memoryEntry is one bookmark or viewed article.
+type memoryEntry struct {
+	user    string
+	article Article
+	at      time.Time
+}
+
+// cloneAccount copies a so callers never share slices with the store.
+func cloneAccount(a *accounts.Account) *accounts.Account {
+	c := *a
+	c.Logins = slices.Clone(a.Logins)
+	c.Categories = slices.Clone(a.Categories)
+	c.NewsSources = slices.Clone(a.NewsSources)
+	if a.DisabledAt != nil {
+		at := *a.DisabledAt
+		c.DisabledAt = &at
+	}
+	return &c
+}
+
+// account returns the stored account matching fn. Callers hold m.mu.
+func (m *memory) account(fn func(*accounts.Account) bool) *accounts.Account {
+	for _, a := range m.accounts {
+		if fn(a) {
+			return a
+		}
+	}
+	return nil
+}
+
+func (m *memory) accountByEmail(email string) *accounts.Account {
+	return m.account(func(a *accounts.Account) bool { return a.Email == email })
+}
+
+type memoryUsers struct{ *memory }
+
+func (u memoryUsers) FindByEmail(ctx context.Context, email string) (*accounts.Account, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if a := u.accountByEmail(email); a != nil {
+		return cloneAccount(a), nil
+	}
+	return nil, accounts.ErrNotFound
+}
+
+func (u memoryUsers) FindByLogin(ctx context.Context, p accounts.Provider, subject string) (*accounts.Account, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	a := u.account(func(a *accounts.Account) bool {
+		l, ok := a.Login(p)
+		return ok && l.Subject == subject
+	})
+	if a == nil {
+		return nil, accounts.ErrNotFound
+	}
+	return cloneAccount(a), nil
+}
+
+func (u memoryUsers) Conflict(ctx context.Context, username, email string) (bool, string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if username != "" && u.account(func(a *accounts.Account) bool { return a.Username == username }) != nil {
+		return true, "username", nil
+	}
+	if email != "" && u.accountByEmail(email) != nil {
+		return true, "email", nil
+	}
+	return false, "", nil
+}
+
+func (u memoryUsers) Create(ctx context.Context, a *accounts.Account) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.accountByEmail(a.Email) != nil {
+		return &DuplicateError{Field: "email"}
+	}
+	if a.Username != "" && u.account(func(o *accounts.Account) bool { return o.Username == a.Username }) != nil {
+		return &DuplicateError{Field: "username"}
+	}
+	for _, l := range a.Logins {
+		if u.loginTaken(nil, l.Provider, l.Subject) {
+			return &DuplicateError{Field: "login"}
+		}
+	}
+	now := time.Now()
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = now
+	}
+	a.UpdatedAt = now
+	for i := range a.Logins {
+		if a.Logins[i].LinkedAt.IsZero() {
+			a.Logins[i].LinkedAt = now
+		}
+	}
+	a.ID = primitive.NewObjectID()
+	u.accounts = append(u.accounts, cloneAccount(a))
+	return nil
+}
+
+func (u memoryUsers) LinkLogin(ctx context.Context, email string, l accounts.Login) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	a := u.accountByEmail(email)
+	if a == nil {
+		return nil
+	}
+	if _, linked := a.Login(l.Provider); linked {
+		return nil
+	}
+	if u.loginTaken(a, l.Provider, l.Subject) {
+		return &DuplicateError{Field: "login"}
+	}
+	if l.LinkedAt.IsZero() {
+		l.LinkedAt = time.Now()
+	}
+	a.Logins = append(a.Logins, l)
+	a.UpdatedAt = time.Now()
+	return nil
+}
+
+func (u memoryUsers) SetLoginSubject(ctx context.Context, email string, p accounts.Provider, subject string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	a := u.accountByEmail(email)
+	if a == nil {
+		return nil
+	}
+	if u.loginTaken(a, p, subject) {
+		return &DuplicateError{Field: "login"}
+	}
+	for i := range a.Logins {
+		if a.Logins[i].Provider == p {
+			a.Logins[i].Subject = subject
+			a.UpdatedAt = time.Now()
+			break
+		}
+	}
+	return nil
+}
+
+// loginTaken reports whether an account other than self has the provider
+// identity, as the Mongo logins_provider_subject index would. Logins
+// without a subject never clash.
+func (u memoryUsers) loginTaken(self *accounts.Account, p accounts.Provider, subject string) bool {
+	if subject == "" {
+		return false
+	}
+	return u.account(func(a *accounts.Account) bool {
+		l, ok := a.Login(p)
+		return a != self && ok && l.Subject == subject
+	}) != nil
+}
+
+func (u memoryUsers) SetPassword(ctx context.Context, email, passwordHash string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	a := u.accountByEmail(email)
+	if a == nil {
+		return accounts.ErrNotFound
+	}
+	now := time.Now()
+	a.UpdatedAt = now
+	setPassword(a, passwordHash, now)
+	return nil
+}
+
+// setPassword replaces a's password hash or links a password login.
+func setPassword(a *accounts.Account, passwordHash string, now time.Time) {
+	for i := range a.Logins {
+		if a.Logins[i].Provider == accounts.ProviderPassword {
+			a.Logins[i].PasswordHash = passwordHash
+			return
+		}
+	}
+	a.Logins = append(a.Logins, accounts.Login{Provider: accounts.ProviderPassword, PasswordHash: passwordHash, LinkedAt: now})
+}
+
+func (u memoryUsers) UpdateProfile(ctx context.Context, email string, p ProfileUpdate) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	a := u.accountByEmail(email)
+	if a == nil {
+		return accounts.ErrNotFound
+	}
+	if p.Username != "" && u.account(func(o *accounts.Account) bool { return o != a && o.Username == p.Username }) != nil {
+		return &DuplicateError{Field: "username"}
+	}
+	for _, f := range []struct {
+		dst *string
+		src string
+	}{
+		{&a.Username, p.Username},
+		{&a.FullName, p.FullName},
+		{&a.Phone, p.Phone},
+		{&a.Bio, p.Bio},
+		{&a.Website, p.Website},
+		{&a.Avatar, p.Avatar},
+		{&a.Country, p.Country},
+	} {
+		if f.src != "" {
+			*f.dst = f.src
+		}
+	}
+	if len(p.Categories) > 0 {
+		a.Categories = slices.Clone(p.Categories)
+	}
+	if len(p.NewsSources) > 0 {
+		a.NewsSources = slices.Clone(p.NewsSources)
+	}
+	now := time.Now()
+	a.UpdatedAt = now
+	if p.PasswordHash != "" {
+		setPassword(a, p.PasswordHash, now)
+	}
+	return nil
+}
+
+func (u memoryUsers) List(ctx context.Context, query string, limit int) ([]*accounts.Account, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	query = strings.ToLower(query)
+	out := []*accounts.Account{}
+	for _, a := range u.accounts {
+		if strings.Contains(strings.ToLower(a.Email), query) || strings.Contains(strings.ToLower(a.Username), query) {
+			out = append(out, cloneAccount(a))
+		}
+	}
+	slices.SortStableFunc(out, func(a, b *accounts.Account) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (u memoryUsers) SetDisabled(ctx context.Context, email string, disabled bool) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	a := u.accountByEmail(email)
+	if a == nil {
+		return accounts.ErrNotFound
+	}
+	now := time.Now()
+	a.DisabledAt = nil
+	if disabled {
+		a.DisabledAt = &now
+	}
+	a.UpdatedAt = now
+	return nil
+}
+
+func (u memoryUsers) Delete(ctx context.Context, email string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i, a := range u.accounts {
+		if a.Email == email {
+			u.accounts = slices.Delete(u.accounts, i, i+1)
+			return nil
+		}
+	}
+	return accounts.ErrNotFound
+}
+
+// deleteEntries removes the entries matching fn and returns how many.
+// Callers hold m.mu.
+func deleteEntries(entries *[]memoryEntry, fn func(memoryEntry) bool) int {
+	before := len(*entries)
+	*entries = slices.DeleteFunc(*entries, fn)
+	return before - len(*entries)
+}
+
+type memoryBookmarks struct{ *memory }
+
+func (b memoryBookmarks) Add(ctx context.Context, user string, article Article) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if url := ArticleURL(article); url != "" {
+		for _, e := range b.bookmarks {
+			if e.user == user && ArticleURL(e.article) == url {
+				return false, nil
+			}
+		}
+	}
+	b.bookmarks = append(b.bookmarks, memoryEntry{user: user, article: article, at: time.Now()})
+	return true, nil
+}
+
+func (b memoryBookmarks) Remove(ctx context.Context, user, articleURL string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, e := range b.bookmarks {
+		if e.user == user && ArticleURL(e.article) == articleURL {
+			b.bookmarks = slices.Delete(b.bookmarks, i, i+1)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (b memoryBookmarks) List(ctx context.Context, user string) ([]Article, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := []Article{}
+	for _, e := range b.bookmarks {
+		if e.user == user {
+			out = append(out, e.article)
+		}
+	}
+	return out, nil
+}
+
+func (b memoryBookmarks) DeleteAll(ctx context.Context, user string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return deleteEntries(&b.bookmarks, func(e memoryEntry) bool { return e.user == user }), nil
+}
+
+type memoryViewedNews struct{ *memory }
+
+func (v memoryViewedNews) Record(ctx context.Context, user string, article Article) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	url := ArticleURL(article)
+	if url == "" {
+		return ErrNoArticleURL
+	}
+	entry := memoryEntry{user: user, article: article, at: time.Now()}
+	for i, e := range v.viewed {
+		if e.user == user && ArticleURL(e.article) == url {
+			v.viewed[i] = entry
+			return nil
+		}
+	}
+	v.viewed = append(v.viewed, entry)
+	return nil
+}
+
+func (v memoryViewedNews) Recent(ctx context.Context, user string) ([]Article, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var entries []memoryEntry
+	for _, e := range v.viewed {
+		if e.user == user {
+			entries = append(entries, e)
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b memoryEntry) int { return b.at.Compare(a.at) })
+	out := make([]Article, 0, min(len(entries), ViewedNewsLimit))
+	for _, e := range entries[:min(len(entries), ViewedNewsLimit)] {
+		out = append(out, e.article)
+	}
+	return out, nil
+}
+
+func (v memoryViewedNews) DeleteAll(ctx context.Context, user string) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return deleteEntries(&v.viewed, func(e memoryEntry) bool { return e.user == user }), nil
+}
+
+func (v memoryViewedNews) PurgeBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return deleteEntries(&v.viewed, func(e memoryEntry) bool { return e.at.Before(cutoff) }), nil
+}
+
+type memoryTopics struct{ *memory }
+
+func (t memoryTopics) List(ctx context.Context) ([]Document, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Document{}, t.topics...), nil
+}
+
+func (t memoryTopics) Replace(ctx context.Context, topics []Document) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.topics = slices.Clone(topics)
+	return nil
+}
+
+type memoryNews struct{ *memory }
+
+func (n memoryNews) filter(fn func(Document) bool) []Document {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := []Document{}
+	for _, doc := range n.news {
+		if fn(doc) {
+			out = append(out, doc)
+		}
+	}
+	return out
+}
+
+func (n memoryNews) ByCategory(ctx context.Context, category string) ([]Document, error) {
+	return n.filter(func(doc Document) bool { return doc["category"] == category }), nil
+}
+
+func (n memoryNews) Trending(ctx context.Context) ([]Document, error) {
+	return n.filter(func(doc Document) bool { return doc["trending"] == true }), nil
+}
+
+// Search approximates Mongo's text search: a document matches when any
+// of q's words is one of its words, ignoring case and without stemming.
+func (n memoryNews) Search(ctx context.Context, q string) ([]Document, error) {
+	terms := strings.Fields(strings.ToLower(q))
+	return n.filter(func(doc Document) bool {
+		for _, field := range []string{"title", "description", "category"} {
+			s, _ := doc[field].(string)
+			for _, word := range strings.FieldsFunc(strings.ToLower(s), notWordChar) {
+				if slices.Contains(terms, word) {
+					return true
+				}
+			}
+		}
+		return false
+	}), nil
+}
+
+func notWordChar(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func (n memoryNews) Import(ctx context.Context, docs []Document) (added, replaced int, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	for _, doc := range docs {
+		stamped := Document{}
+		for k, v := range doc {
+			stamped[k] = v
+		}
+		stamped["importedAt"] = now
+		url, _ := stamped["url"].(string)
+		i := -1
+		if url != "" {
+			i = slices.IndexFunc(n.news, func(d Document) bool { return d["url"] == url })
+		}
+		if i >= 0 {
+			n.news[i] = stamped
+			replaced++
+		} else {
+			n.news = append(n.news, stamped)
+			added++
+		}
+	}
+	return added, replaced, nil
+}
+
+func (n memoryNews) PurgeBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	before := len(n.news)
+	n.news = slices.DeleteFunc(n.news, func(d Document) bool {
+		at, ok := d["importedAt"].(time.Time)
+		return ok && at.Before(cutoff)
+	})
+	return before - len(n.news), nil
+}
+
+type memorySessions struct{ *memory }
+
+func (s memorySessions) Create(ctx context.Context, session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.sessions[session.ID]; exists {
+		return ErrDuplicate
+	}
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s memorySessions) Get(ctx context.Context, id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (s memorySessions) Rotate(ctx context.Context, id, oldHash, newHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.RefreshHash != oldHash || session.RevokedAt != nil {
+		return false, nil
+	}
+	session.RefreshHash = newHash
+	session.LastUsedAt = time.Now()
+	s.sessions[id] = session
+	return true, nil
+}
+
+func (s memorySessions) Active(ctx context.Context, id, email string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	return ok && session.Email == email && session.RevokedAt == nil && time.Now().Before(session.ExpiresAt), nil
+}
+
+func (s memorySessions) Revoke(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[id]; ok && session.RevokedAt == nil {
+		now := time.Now()
+		session.RevokedAt = &now
+		s.sessions[id] = session
+	}
+	return nil
+}
+
+func (s memorySessions) RevokeAll(ctx context.Context, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, session := range s.sessions {
+		if session.Email == email && session.RevokedAt == nil {
+			session.RevokedAt = &now
+			s.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+func (s memorySessions) SetUsername(ctx context.Context, email, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, session := range s.sessions {
+		if session.Email == email && session.RevokedAt == nil {
+			session.Username = username
+			s.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+func (s memorySessions) DeleteAll(ctx context.Context, email string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, session := range s.sessions {
+		if session.Email == email {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"backend/accounts"
+)
+
+// TestLoginIdentityUnique checks that the memory store enforces the
+// logins_provider_subject index the way Mongo does.
+func TestLoginIdentityUnique(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemory().Users
+	google := func(subject string) []accounts.Login {
+		return []accounts.Login{{Provider: accounts.ProviderGoogle, Subject: subject}}
+	}
+	for _, a := range []*accounts.Account{
+		{Email: "ada@example.com", Logins: google("g-1")},
+		// Linked before subjects were recorded
+		{Email: "bob@example.com", Logins: google("")},
+		{Email: "cy@example.com", Logins: google("")},
+		{Email: "dee@example.com", Logins: []accounts.Login{{Provider: accounts.ProviderPassword, PasswordHash: "hash"}}},
+	} {
+		if err := users.Create(ctx, a); err != nil {
+			t.Fatalf("creating %s: %v", a.Email, err)
+		}
+	}
+
+	isLogin := func(err error) bool {
+		var dup *DuplicateError
+		return errors.As(err, &dup) && dup.Field == "login"
+	}
+	if err := users.Create(ctx, &accounts.Account{Email: "eve@example.com", Logins: google("g-1")}); !isLogin(err) {
+		t.Errorf("Create with a taken identity returned %v", err)
+	}
+	if err := users.LinkLogin(ctx, "dee@example.com", google("g-1")[0]); !isLogin(err) {
+		t.Errorf("LinkLogin with a taken identity returned %v", err)
+	}
+	if err := users.SetLoginSubject(ctx, "bob@example.com", accounts.ProviderGoogle, "g-1"); !isLogin(err) {
+		t.Errorf("SetLoginSubject with a taken identity returned %v", err)
+	}
+	if a, err := users.FindByLogin(ctx, accounts.ProviderGoogle, "g-1"); err != nil || a.Email != "ada@example.com" {
+		t.Fatalf("g-1 signs in to %v, %v", a, err)
+	}
+
+	// Setting an account's own subject again, or a free one, is fine
+	if err := users.SetLoginSubject(ctx, "ada@example.com", accounts.ProviderGoogle, "g-1"); err != nil {
+		t.Error(err)
+	}
+	if err := users.SetLoginSubject(ctx, "bob@example.com", accounts.ProviderGoogle, "g-2"); err != nil {
+		t.Error(err)
+	}
+	if err := users.LinkLogin(ctx, "dee@example.com", google("g-3")[0]); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,376 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/accounts"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMongo returns repositories backed by db.
+func NewMongo(db *mongo.Database) *Repositories {
+	return &Repositories{
+		Users:      mongoUsers{db: db},
+		Bookmarks:  mongoBookmarks{col: db.Collection(BookmarksCollection)},
+		ViewedNews: mongoViewedNews{col: db.Collection(ViewedNewsCollection)},
+		Topics:     mongoTopics{col: db.Collection(TopicsCollection)},
+		News:       mongoNews{col: db.Collection(NewsCollection)},
+		Sessions:   mongoSessions{col: db.Collection(SessionsCollection)},
+		Ping: func(ctx context.Context) error {
+			return db.Client().Ping(ctx, nil)
+		},
+	}
+}
+
+// findAll runs filter against col and decodes every match.
+func findAll(ctx context.Context, col *mongo.Collection, filter bson.M, opts ...*options.FindOptions) ([]Document, error) {
+	cur, err := col.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var docs []bson.M
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	out := make([]Document, 0, len(docs))
+	for _, doc := range docs {
+		out = append(out, Document(doc))
+	}
+	return out, nil
+}
+
+// articles pulls the "article" field out of bookmark and history documents.
+func articles(docs []Document) []Article {
+	out := make([]Article, 0, len(docs))
+	for _, doc := range docs {
+		if art, ok := doc["article"].(bson.M); ok {
+			out = append(out, Article(art))
+		}
+	}
+	return out
+}
+
+// mongoUsers delegates to the accounts package, which owns the schema.
+type mongoUsers struct {
+	db *mongo.Database
+}
+
+func (u mongoUsers) FindByEmail(ctx context.Context, email string) (*accounts.Account, error) {
+	return accounts.FindByEmail(ctx, u.db, email)
+}
+
+func (u mongoUsers) FindByLogin(ctx context.Context, p accounts.Provider, subject string) (*accounts.Account, error) {
+	return accounts.FindByLogin(ctx, u.db, p, subject)
+}
+
+func (u mongoUsers) Conflict(ctx context.Context, username, email string) (bool, string, error) {
+	return accounts.Conflict(ctx, u.db, username, email)
+}
+
+func (u mongoUsers) Create(ctx context.Context, a *accounts.Account) error {
+	return accountDuplicate(accounts.Create(ctx, u.db, a))
+}
+
+// accountDuplicate turns a duplicate key error from an account write into a
+// *DuplicateError naming the field whose unique index was violated.
+func accountDuplicate(err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	// The message names the violated index
+	switch {
+	case strings.Contains(err.Error(), "username_unique"):
+		return &DuplicateError{Field: "username"}
+	case strings.Contains(err.Error(), "logins_provider_subject"):
+		return &DuplicateError{Field: "login"}
+	}
+	return &DuplicateError{Field: "email"}
+}
+
+func (u mongoUsers) LinkLogin(ctx context.Context, email string, l accounts.Login) error {
+	return accountDuplicate(accounts.LinkLogin(ctx, u.db, email, l))
+}
+
+func (u mongoUsers) SetLoginSubject(ctx context.Context, email string, p accounts.Provider, subject string) error {
+	return accountDuplicate(accounts.SetLoginSubject(ctx, u.db, email, p, subject))
+}
+
+func (u mongoUsers) SetPassword(ctx context.Context, email, passwordHash string) error {
+	return accounts.SetPassword(ctx, u.db, email, passwordHash)
+}
+
+func (u mongoUsers) UpdateProfile(ctx context.Context, email string, p ProfileUpdate) error {
+	fields := bson.M{}
+	for key, value := range map[string]string{
+		"username": p.Username,
+		"fullName": p.FullName,
+		"phone":    p.Phone,
+		"bio":      p.Bio,
+		"website":  p.Website,
+		"avatar":   p.Avatar,
+		"country":  p.Country,
+	} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	if len(p.Categories) > 0 {
+		fields["categories"] = p.Categories
+	}
+	if len(p.NewsSources) > 0 {
+		fields["newsSources"] = p.NewsSources
+	}
+	err := accounts.UpdateProfile(ctx, u.db, email, fields, p.PasswordHash)
+	if mongo.IsDuplicateKeyError(err) {
+		return &DuplicateError{Field: "username"}
+	}
+	return err
+}
+
+func (u mongoUsers) List(ctx context.Context, query string, limit int) ([]*accounts.Account, error) {
+	return accounts.List(ctx, u.db, query, int64(limit))
+}
+
+func (u mongoUsers) SetDisabled(ctx context.Context, email string, disabled bool) error {
+	return accounts.SetDisabled(ctx, u.db, email, disabled)
+}
+
+func (u mongoUsers) Delete(ctx context.Context, email string) error {
+	return accounts.Delete(ctx, u.db, email)
+}
+
+// deleteMany removes the documents matching filter and returns how many.
+func deleteMany(ctx context.Context, col *mongo.Collection, filter bson.M) (int, error) {
+	res, err := col.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
+
+type mongoBookmarks struct {
+	col *mongo.Collection
+}
+
+func (b mongoBookmarks) Add(ctx context.Context, user string, article Article) (bool, error) {
+	// user_article_url_unique rejects a second bookmark of the same URL
+	_, err := b.col.InsertOne(ctx, bson.M{"user": user, "article": article, "createdAt": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b mongoBookmarks) Remove(ctx context.Context, user, articleURL string) (bool, error) {
+	res, err := b.col.DeleteOne(ctx, bson.M{"user": user, "article.url": articleURL})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
+
+func (b mongoBookmarks) List(ctx context.Context, user string) ([]Article, error) {
+	docs, err := findAll(ctx, b.col, bson.M{"user": user})
+	if err != nil {
+		return nil, err
+	}
+	return articles(docs), nil
+}
+
+func (b mongoBookmarks) DeleteAll(ctx context.Context, user string) (int, error) {
+	return deleteMany(ctx, b.col, bson.M{"user": user})
+}
+
+type mongoViewedNews struct {
+	col *mongo.Collection
+}
+
+func (v mongoViewedNews) Record(ctx context.Context, user string, article Article) error {
+	url := ArticleURL(article)
+	if url == "" {
+		return ErrNoArticleURL
+	}
+	_, err := v.col.UpdateOne(ctx, bson.M{"user": user, "article.url": url},
+		bson.M{"$set": bson.M{"user": user, "article": article, "viewedAt": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (v mongoViewedNews) Recent(ctx context.Context, user string) ([]Article, error) {
+	docs, err := findAll(ctx, v.col, bson.M{"user": user},
+		options.Find().SetSort(bson.M{"viewedAt": -1}).SetLimit(ViewedNewsLimit))
+	if err != nil {
+		return nil, err
+	}
+	return articles(docs), nil
+}
+
+func (v mongoViewedNews) DeleteAll(ctx context.Context, user string) (int, error) {
+	return deleteMany(ctx, v.col, bson.M{"user": user})
+}
+
+func (v mongoViewedNews) PurgeBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	return deleteMany(ctx, v.col, bson.M{"viewedAt": bson.M{"$lt": cutoff}})
+}
+
+type mongoTopics struct {
+	col *mongo.Collection
+}
+
+func (t mongoTopics) List(ctx context.Context) ([]Document, error) {
+	return findAll(ctx, t.col, bson.M{})
+}
+
+// Replace inserts the new topics before deleting the old ones, so readers
+// never see an empty list; for a moment they may see both.
+func (t mongoTopics) Replace(ctx context.Context, topics []Document) error {
+	var keep []interface{}
+	if len(topics) > 0 {
+		docs := make([]interface{}, len(topics))
+		for i, topic := range topics {
+			// Fresh IDs, so a list exported with its IDs can be seeded again
+			doc := bson.M{}
+			for k, v := range topic {
+				doc[k] = v
+			}
+			delete(doc, "_id")
+			docs[i] = doc
+		}
+		res, err := t.col.InsertMany(ctx, docs)
+		if err != nil {
+			return err
+		}
+		keep = res.InsertedIDs
+	}
+	_, err := t.col.DeleteMany(ctx, bson.M{"_id": bson.M{"$nin": append(bson.A{}, keep...)}})
+	return err
+}
+
+type mongoNews struct {
+	col *mongo.Collection
+}
+
+func (n mongoNews) ByCategory(ctx context.Context, category string) ([]Document, error) {
+	return findAll(ctx, n.col, bson.M{"category": category})
+}
+
+func (n mongoNews) Trending(ctx context.Context) ([]Document, error) {
+	return findAll(ctx, n.col, bson.M{"trending": true})
+}
+
+// Search needs the search_text index; see Indexes.
+func (n mongoNews) Search(ctx context.Context, q string) ([]Document, error) {
+	return findAll(ctx, n.col, bson.M{"$text": bson.M{"$search": q}},
+		options.Find().SetSort(bson.M{"score": bson.M{"$meta": "textScore"}}))
+}
+
+func (n mongoNews) Import(ctx context.Context, docs []Document) (added, replaced int, err error) {
+	now := time.Now()
+	for _, doc := range docs {
+		stamped := Document{}
+		for k, v := range doc {
+			stamped[k] = v
+		}
+		delete(stamped, "_id")
+		stamped["importedAt"] = now
+		url, ok := stamped["url"].(string)
+		if !ok || url == "" {
+			if _, err := n.col.InsertOne(ctx, stamped); err != nil {
+				return added, replaced, err
+			}
+			added++
+			continue
+		}
+		res, err := n.col.ReplaceOne(ctx, bson.M{"url": url}, stamped, options.Replace().SetUpsert(true))
+		if err != nil {
+			return added, replaced, err
+		}
+		if res.MatchedCount > 0 {
+			replaced++
+		} else {
+			added++
+		}
+	}
+	return added, replaced, nil
+}
+
+func (n mongoNews) PurgeBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	return deleteMany(ctx, n.col, bson.M{"importedAt": bson.M{"$lt": cutoff}})
+}
+
+type mongoSessions struct {
+	col *mongo.Collection
+}
+
+func (s mongoSessions) Create(ctx context.Context, session Session) error {
+	_, err := s.col.InsertOne(ctx, session)
+	return err
+}
+
+func (s mongoSessions) Get(ctx context.Context, id string) (*Session, error) {
+	var session Session
+	if err := s.col.FindOne(ctx, bson.M{"_id": id}).Decode(&session); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s mongoSessions) Rotate(ctx context.Context, id, oldHash, newHash string) (bool, error) {
+	res, err := s.col.UpdateOne(ctx,
+		bson.M{"_id": id, "refreshHash": oldHash, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"refreshHash": newHash, "lastUsedAt": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+func (s mongoSessions) Active(ctx context.Context, id, email string) (bool, error) {
+	count, err := s.col.CountDocuments(ctx, bson.M{
+		"_id":       id,
+		"email":     email,
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	})
+	return count > 0, err
+}
+
+func (s mongoSessions) Revoke(ctx context.Context, id string) error {
+	_, err := s.col.UpdateOne(ctx,
+		bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	return err
+}
+
+func (s mongoSessions) RevokeAll(ctx context.Context, email string) error {
+	_, err := s.col.UpdateMany(ctx,
+		bson.M{"email": email, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	return err
+}
+
+func (s mongoSessions) SetUsername(ctx context.Context, email, username string) error {
+	_, err := s.col.UpdateMany(ctx,
+		bson.M{"email": email, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"username": username}},
+	)
+	return err
+}
+
+func (s mongoSessions) DeleteAll(ctx context.Context, email string) (int, error) {
+	return deleteMany(ctx, s.col, bson.M{"email": email})
+}
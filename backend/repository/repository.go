@@ -0,0 +1,241 @@
+// Package repository is the persistence boundary for the HTTP handlers. Each
+// collection the API touches sits behind a small interface with a MongoDB
+// implementation for production and an in-memory one for tests and local
+// runs without a database.
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/accounts"
+)
+
+// Collection names used by the Mongo implementation.
+const (
+	BookmarksCollection  = "bookmarks"
+	ViewedNewsCollection = "viewed_news"
+	TopicsCollection     = "topics"
+	NewsCollection       = "news"
+	SessionsCollection   = "sessions"
+)
+
+// ViewedNewsLimit is how many recently viewed articles Recent returns.
+const ViewedNewsLimit = 20
+
+var (
+	// ErrDuplicate is returned when a write would break a uniqueness rule.
+	// Account writes return it as a *DuplicateError naming the field.
+	ErrDuplicate = errors.New("duplicate record")
+	// ErrSessionNotFound is returned for an unknown session ID.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrNoArticleURL is returned when an article to record has no string
+	// "url" field to identify it by.
+	ErrNoArticleURL = errors.New("article has no url")
+)
+
+// DuplicateError names the field ("email", "username" or "login", for a
+// provider identity) another account already holds.
+type DuplicateError struct {
+	Field string
+}
+
+func (e *DuplicateError) Error() string {
+	return e.Field + " already belongs to another account"
+}
+
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+// Article is a news article as the client sent it. Its "url" field
+// identifies it for bookmarks and view history.
+type Article = map[string]interface{}
+
+// Document is a record from a collection whose shape the API passes through
+// untouched, such as topics and curated news.
+type Document = map[string]interface{}
+
+// ArticleURL returns the article's "url" field, or "" when it has none.
+func ArticleURL(a Article) string {
+	url, _ := a["url"].(string)
+	return url
+}
+
+// ProfileUpdate holds the profile fields to change. Empty fields are left
+// as they are.
+type ProfileUpdate struct {
+	Username    string
+	FullName    string
+	Phone       string
+	Bio         string
+	Website     string
+	Avatar      string
+	Country     string
+	Categories  []string
+	NewsSources []string
+	// PasswordHash replaces the password as SetPassword does, in the same
+	// write as the profile.
+	PasswordHash string
+}
+
+// Users stores accounts; see the accounts package for the model. Lookups
+// that match nothing return accounts.ErrNotFound.
+type Users interface {
+	FindByEmail(ctx context.Context, email string) (*accounts.Account, error)
+	// FindByLogin finds the account a provider identity is linked to.
+	FindByLogin(ctx context.Context, p accounts.Provider, subject string) (*accounts.Account, error)
+	// Conflict reports whether the username or email is already taken, and
+	// which of the two fields ("username" or "email") clashed.
+	Conflict(ctx context.Context, username, email string) (bool, string, error)
+	// Create inserts the account, returning a *DuplicateError if the email,
+	// username or a login's identity is taken.
+	Create(ctx context.Context, a *accounts.Account) error
+	// LinkLogin adds a login unless one for the provider is already linked.
+	// It returns a *DuplicateError if another account has the identity.
+	LinkLogin(ctx context.Context, email string, l accounts.Login) error
+	// SetLoginSubject records the provider's user ID on a linked login,
+	// returning a *DuplicateError if another account has it.
+	SetLoginSubject(ctx context.Context, email string, p accounts.Provider, subject string) error
+	// SetPassword replaces the password hash, linking a password login if
+	// the account has none.
+	SetPassword(ctx context.Context, email, passwordHash string) error
+	// UpdateProfile applies u in one write. It returns a *DuplicateError if
+	// the new username is taken.
+	UpdateProfile(ctx context.Context, email string, u ProfileUpdate) error
+	// List returns up to limit accounts (0 for all), newest first, whose
+	// email or username contains query, ignoring case.
+	List(ctx context.Context, query string, limit int) ([]*accounts.Account, error)
+	// SetDisabled locks the account out of signing in, or lets it back in.
+	SetDisabled(ctx context.Context, email string, disabled bool) error
+	// Delete removes the account only; see Repositories.DeleteUser.
+	Delete(ctx context.Context, email string) error
+}
+
+// Bookmarks stores the articles each user saved, at most once per URL.
+type Bookmarks interface {
+	// Add saves the article and reports false if one with the same URL was
+	// already bookmarked.
+	Add(ctx context.Context, user string, article Article) (bool, error)
+	// Remove deletes the bookmark for articleURL and reports whether there
+	// was one.
+	Remove(ctx context.Context, user, articleURL string) (bool, error)
+	List(ctx context.Context, user string) ([]Article, error)
+	// DeleteAll removes every bookmark of user and returns how many there were.
+	DeleteAll(ctx context.Context, user string) (int, error)
+}
+
+// ViewedNews keeps each user's reading history, one entry per article URL.
+type ViewedNews interface {
+	// Record marks the article as viewed now, replacing an earlier entry
+	// for the same URL. It returns ErrNoArticleURL for an article without
+	// one.
+	Record(ctx context.Context, user string, article Article) error
+	// Recent returns the last ViewedNewsLimit articles, newest first.
+	Recent(ctx context.Context, user string) ([]Article, error)
+	// DeleteAll removes user's whole history and returns how many entries
+	// there were.
+	DeleteAll(ctx context.Context, user string) (int, error)
+	// PurgeBefore removes every user's entries viewed before cutoff.
+	PurgeBefore(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// Topics lists the curated explore topics.
+type Topics interface {
+	List(ctx context.Context) ([]Document, error)
+	// Replace swaps the whole list for topics.
+	Replace(ctx context.Context, topics []Document) error
+}
+
+// News queries the curated news collection behind the explore tab.
+type News interface {
+	ByCategory(ctx context.Context, category string) ([]Document, error)
+	Trending(ctx context.Context) ([]Document, error)
+	// Search finds q's words in the title, description or category, best
+	// matches first.
+	Search(ctx context.Context, q string) ([]Document, error)
+	// Import stores the documents, stamping each with importedAt. A document
+	// whose "url" matches a stored one replaces it. It returns how many were
+	// added and how many replaced.
+	Import(ctx context.Context, docs []Document) (added, replaced int, err error)
+	// PurgeBefore removes documents imported before cutoff.
+	PurgeBefore(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// Session is one signed-in device. Only a hash of its current refresh token
+// is stored; every refresh replaces it, so a stolen token that is replayed
+// after the real client refreshed is detected and kills the session.
+type Session struct {
+	ID          string     `bson:"_id"`
+	Email       string     `bson:"email"`
+	Username    string     `bson:"username,omitempty"`
+	RefreshHash string     `bson:"refreshHash"`
+	UserAgent   string     `bson:"userAgent,omitempty"`
+	CreatedAt   time.Time  `bson:"createdAt"`
+	LastUsedAt  time.Time  `bson:"lastUsedAt"`
+	ExpiresAt   time.Time  `bson:"expiresAt"`
+	RevokedAt   *time.Time `bson:"revokedAt,omitempty"`
+}
+
+// Sessions stores refresh-token sessions.
+type Sessions interface {
+	Create(ctx context.Context, s Session) error
+	// Get returns ErrSessionNotFound for an unknown ID.
+	Get(ctx context.Context, id string) (*Session, error)
+	// Rotate swaps the refresh hash only if it is still oldHash and the
+	// session is not revoked, so two concurrent refreshes cannot both win.
+	Rotate(ctx context.Context, id, oldHash, newHash string) (bool, error)
+	// Active reports whether the session exists for email, is not revoked
+	// and has not expired.
+	Active(ctx context.Context, id, email string) (bool, error)
+	Revoke(ctx context.Context, id string) error
+	// RevokeAll signs the user out everywhere.
+	RevokeAll(ctx context.Context, email string) error
+	// SetUsername records a new username on the user's live sessions, so
+	// the access tokens they are refreshed into carry it.
+	SetUsername(ctx context.Context, email, username string) error
+	// DeleteAll removes the user's sessions, revoked or not.
+	DeleteAll(ctx context.Context, email string) (int, error)
+}
+
+// Repositories bundles everything the handlers persist through.
+type Repositories struct {
+	Users      Users
+	Bookmarks  Bookmarks
+	ViewedNews ViewedNews
+	Topics     Topics
+	News       News
+	Sessions   Sessions
+
+	// Ping checks that the backing database answers. It is nil when there
+	// is nothing to check.
+	Ping func(ctx context.Context) error
+}
+
+// Deleted counts what DeleteUser removed besides the account.
+type Deleted struct {
+	Bookmarks  int `json:"bookmarks"`
+	ViewedNews int `json:"viewedNews"`
+	Sessions   int `json:"sessions"`
+}
+
+// DeleteUser removes the account and everything stored under its email.
+// The account goes last, so a failed run can be repeated.
+func (r *Repositories) DeleteUser(ctx context.Context, email string) (Deleted, error) {
+	var d Deleted
+	if _, err := r.Users.FindByEmail(ctx, email); err != nil {
+		return d, err
+	}
+	var err error
+	if d.Sessions, err = r.Sessions.DeleteAll(ctx, email); err != nil {
+		return d, err
+	}
+	if d.Bookmarks, err = r.Bookmarks.DeleteAll(ctx, email); err != nil {
+		return d, err
+	}
+	if d.ViewedNews, err = r.ViewedNews.DeleteAll(ctx, email); err != nil {
+		return d, err
+	}
+	return d, r.Users.Delete(ctx, email)
+}
@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MongoMonitor traces every command the driver sends as a client span under
+// the span in the operation's context. Command bodies are not recorded
+// because they hold emails and credential hashes.
+func MongoMonitor() *event.CommandMonitor {
+	var (
+		mu    sync.Mutex
+		spans = map[int64]trace.Span{}
+	)
+	end := func(requestID int64, failure string) {
+		mu.Lock()
+		span, ok := spans[requestID]
+		delete(spans, requestID)
+		mu.Unlock()
+		if !ok {
+			return
+		}
+		if failure != "" {
+			span.SetStatus(codes.Error, failure)
+		}
+		span.End()
+	}
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			_, span := Tracer().Start(ctx, "mongo "+e.CommandName,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("db.system", "mongodb"),
+					attribute.String("db.name", e.DatabaseName),
+					attribute.String("db.operation", e.CommandName),
+					attribute.String("db.mongodb.collection", collection(e)),
+				),
+			)
+			mu.Lock()
+			spans[e.RequestID] = span
+			mu.Unlock()
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			end(e.RequestID, "")
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			end(e.RequestID, e.Failure)
+		},
+	}
+}
+
+// collection is the target of a command, which by convention is the value
+// of its first field, e.g. {"find": "accounts", ...}.
+func collection(e *event.CommandStartedEvent) string {
+	elem, err := e.Command.IndexErr(0)
+	if err != nil {
+		return ""
+	}
+	name, _ := elem.Value().StringValueOK()
+	return name
+}
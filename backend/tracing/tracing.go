@@ -0,0 +1,91 @@
+// Package tracing sets up OpenTelemetry tracing and the instrumented
+// clients used for outbound calls. Spans follow the request context, so a
+// handler's span parents the NewsAPI, Gemini and Mongo spans it causes.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"backend/config"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "backend"
+
+// Setup installs the global tracer provider and W3C propagators for the
+// configured exporter and returns a function that flushes and stops it.
+// With the "none" exporter spans are still created, so trace IDs reach the
+// logs, but nothing is exported.
+func Setup(ctx context.Context, cfg config.TracingConfig, stdout io.Writer) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+	}
+	switch cfg.Exporter {
+	case "none":
+	case "stdout":
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(stdout))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithSyncer(exp))
+	case "otlp":
+		var clientOpts []otlptracehttp.Option
+		if cfg.OTLPEndpoint != "" {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+		}
+		exp, err := otlptracehttp.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Tracer is the tracer for spans the server starts itself.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// HTTPClient returns a client whose requests are traced as client spans
+// named after service, e.g. "newsapi GET /v2/everything". Callers must send
+// API keys in headers: span attributes include the full URL.
+func HTTPClient(service string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: otelhttp.NewTransport(http.DefaultTransport,
+			otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+				return service + " " + r.Method + " " + r.URL.Path
+			}),
+		),
+	}
+}
+
+// Middleware starts a server span per request, named by spanName.
+func Middleware(spanName func(*http.Request) string) func(http.Handler) http.Handler {
+	return otelhttp.NewMiddleware("http",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return spanName(r)
+		}),
+	)
+}
@@ -0,0 +1,146 @@
+// Package validate checks request fields against declared rules and
+// collects every failure, so a client learns about all its mistakes in one
+// response:
+//
+//	v := validate.New()
+//	v.Check("email", data.Email, validate.Required, validate.Email)
+//	v.Check("bio", data.Bio, validate.MaxLen(500))
+//	if err := v.Err(); err != nil { ... }
+//
+// Rules other than Required pass empty values, so optional fields are
+// only checked when they are set.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError is one field failing one rule.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors are the failures of a whole request, in the order checked.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, f := range e {
+		parts[i] = f.Field + " " + f.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Rule is a named check on a string value.
+type Rule struct {
+	name    string
+	message string
+	ok      func(string) bool
+}
+
+var (
+	Required = Rule{"required", "is required", func(s string) bool { return strings.TrimSpace(s) != "" }}
+	Email    = Rule{"email", "must be an email address", isEmail}
+	URL      = Rule{"url", "must be an http or https URL", isURL}
+	// Username allows letters, digits, dots, dashes and underscores
+	Username = Rule{"username", "must be 3 to 30 letters, digits, dots, dashes or underscores", usernamePattern.MatchString}
+	// Country is an ISO 3166-1 alpha-2 code, in either case
+	Country = Rule{"country", "must be an ISO 3166-1 alpha-2 country code", func(s string) bool { return countryCodes[strings.ToUpper(s)] }}
+)
+
+var usernamePattern = regexp.MustCompile(`^[\p{L}\p{N}_.-]{3,30}$`)
+
+// MinLen requires at least n characters.
+func MinLen(n int) Rule {
+	return Rule{"minLen", fmt.Sprintf("must be at least %d characters", n), func(s string) bool { return utf8.RuneCountInString(s) >= n }}
+}
+
+// MaxLen allows at most n characters.
+func MaxLen(n int) Rule {
+	return Rule{"maxLen", fmt.Sprintf("must be at most %d characters", n), func(s string) bool { return utf8.RuneCountInString(s) <= n }}
+}
+
+// OneOf requires one of values, ignoring case.
+func OneOf(values ...string) Rule {
+	return Rule{"oneOf", "must be one of " + strings.Join(values, ", "), func(s string) bool {
+		for _, v := range values {
+			if strings.EqualFold(s, v) {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// isEmail accepts a bare address, not one with a display name.
+func isEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s && strings.Contains(s[strings.LastIndex(s, "@"):], ".")
+}
+
+func isURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Validator collects the failures of one request.
+type Validator struct {
+	errs Errors
+}
+
+func New() *Validator {
+	return &Validator{}
+}
+
+// Check applies rules to a field's value in order, recording the first
+// that fails.
+func (v *Validator) Check(field, value string, rules ...Rule) {
+	for _, r := range rules {
+		if value == "" && r.name != Required.name {
+			continue
+		}
+		if !r.ok(value) {
+			v.Add(field, r.name, r.message)
+			return
+		}
+	}
+}
+
+// Each checks every element of a list field, naming them field[i], and
+// allows at most max elements when max is above zero.
+func (v *Validator) Each(field string, values []string, max int, rules ...Rule) {
+	if max > 0 && len(values) > max {
+		v.Add(field, "maxItems", fmt.Sprintf("must have at most %d items", max))
+		return
+	}
+	for i, value := range values {
+		v.Check(fmt.Sprintf("%s[%d]", field, i), value, append([]Rule{Required}, rules...)...)
+	}
+}
+
+// Assert records a failure of a check made by the caller unless ok.
+func (v *Validator) Assert(ok bool, field, rule, message string) {
+	if !ok {
+		v.Add(field, rule, message)
+	}
+}
+
+// Add records a failure.
+func (v *Validator) Add(field, rule, message string) {
+	v.errs = append(v.errs, FieldError{Field: field, Rule: rule, Message: message})
+}
+
+// Err returns the failures as Errors, or nil when there were none.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}